@@ -1,28 +1,47 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	router "ts6-viewer/http"
 	"ts6-viewer/internal/config"
 )
 
 func main() {
+	configPath := flag.String("config", "config.json", "path to the configuration file")
+	webDir := flag.String("web-dir", "", "directory overriding the embedded templates and static assets (overrides web_dir)")
+	flag.Parse()
+
 	log.Println("Starting TS6 Viewer...")
 
 	// Load config.json
-	log.Println("Loading config.json")
-	cfg, err := config.Load("config.json")
+	log.Printf("Loading %s", *configPath)
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 	serverPort := cfg.ServerPort
-	log.Println("Successfully loaded config.json")
+	log.Printf("Successfully loaded %s", *configPath)
+
+	if *webDir != "" {
+		cfg.WebDir = *webDir
+	}
+
+	// Cancelled on SIGINT/SIGTERM; stops background work and aborts
+	// in-flight ServerQuery calls
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Create HTTP router
-	r := router.NewRouter(*cfg)
+	r := router.NewRouter(ctx, *cfg)
 
 	// Create listener first
 	ln, err := net.Listen("tcp", ":"+serverPort)
@@ -36,9 +55,30 @@ func main() {
 	// Start the server (blocking)
 	srv := &http.Server{
 		Handler: r,
+		// Request contexts derive from ctx, so shutdown cancels them too
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+
+		<-ctx.Done()
+		log.Println("Shutting down HTTP server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP server shutdown failed: %v", err)
+		}
+	}()
+
 	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 		log.Fatal("HTTP server failed:", err)
 	}
+
+	// Serve returns as soon as shutdown starts; wait for it to finish
+	<-shutdownDone
+	log.Println("TS6 Viewer stopped")
 }
@@ -1,9 +1,9 @@
 #!/bin/sh
 set -eu
 
-EXAMPLE="/app/cmd/server/config.example.json"
-TARGET_ABS="/app/cmd/server/config.json"
-BINARY="/app/cmd/server/ts6viewer"
+EXAMPLE="/app/config.example.json"
+TARGET_ABS="/app/config.json"
+BINARY="/app/ts6viewer"
 
 export SERVER_PORT="${SERVER_PORT:-8080}"
 export THEME="${THEME:-dark}"
@@ -17,6 +17,35 @@ export PASSWORD="${PASSWORD:-}"
 export ENABLE_VOICE_STATUS="${ENABLE_VOICE_STATUS:-true}"
 export SERVER_ID="${SERVER_ID:-1}"
 export MAX_WIDTH="${MAX_WIDTH:-800px}"
+export WEB_DIR="${WEB_DIR:-}"
+export RATE_LIMIT="${RATE_LIMIT:-1}"
+export RATE_LIMIT_BURST="${RATE_LIMIT_BURST:-10}"
+export TRUSTED_PROXIES="${TRUSTED_PROXIES:-}"
+export STATS_DB="${STATS_DB:-}"
+export STATS_SAMPLE_INTERVAL="${STATS_SAMPLE_INTERVAL:-60}"
+export STATS_RAW_RETENTION="${STATS_RAW_RETENTION:-7}"
+export STATS_RETENTION="${STATS_RETENTION:-365}"
+export GROUP_BADGES="${GROUP_BADGES:-true}"
+export SHOW_GROUPS="${SHOW_GROUPS:-}"
+export HIDE_GROUPS="${HIDE_GROUPS:-Guest}"
+export SORT_CLIENTS="${SORT_CLIENTS:-name}"
+export ACTIVITY_SIZE="${ACTIVITY_SIZE:-500}"
+export ACTIVITY_DIR="${ACTIVITY_DIR:-}"
+export CACHE_DIR="${CACHE_DIR:-}"
+export AVATARS="${AVATARS:-false}"
+export IMAGE_PROXY="${IMAGE_PROXY:-false}"
+export WEBHOOKS="${WEBHOOKS:-[]}"
+export HOST_KEY_FINGERPRINT="${HOST_KEY_FINGERPRINT:-}"
+export HOST_KEY_TOFU="${HOST_KEY_TOFU:-false}"
+# Keys trusted on first use are kept in the data volume, so that they
+# survive re-creating the container
+if [ "$HOST_KEY_TOFU" = "true" ]; then
+  export KNOWN_HOSTS_FILE="${KNOWN_HOSTS_FILE:-/app/data/known_hosts}"
+else
+  export KNOWN_HOSTS_FILE="${KNOWN_HOSTS_FILE:-}"
+fi
+export FILE_TRANSFER_HOST="${FILE_TRANSFER_HOST:-}"
+export FILE_TRANSFER_PORT="${FILE_TRANSFER_PORT:-}"
 
 echo "[entrypoint] starting TS6 Viewer"
 
@@ -49,6 +78,29 @@ echo "  PASSWORD=*********"
 echo "  ENABLE_VOICE_STATUS=$ENABLE_VOICE_STATUS"
 echo "  SERVER_ID=$SERVER_ID"
 echo "  MAX_WIDTH=$MAX_WIDTH"
+echo "  WEB_DIR=$WEB_DIR"
+echo "  RATE_LIMIT=$RATE_LIMIT"
+echo "  RATE_LIMIT_BURST=$RATE_LIMIT_BURST"
+echo "  TRUSTED_PROXIES=$TRUSTED_PROXIES"
+echo "  STATS_DB=$STATS_DB"
+echo "  STATS_SAMPLE_INTERVAL=$STATS_SAMPLE_INTERVAL"
+echo "  STATS_RAW_RETENTION=$STATS_RAW_RETENTION"
+echo "  STATS_RETENTION=$STATS_RETENTION"
+echo "  GROUP_BADGES=$GROUP_BADGES"
+echo "  SHOW_GROUPS=$SHOW_GROUPS"
+echo "  HIDE_GROUPS=$HIDE_GROUPS"
+echo "  SORT_CLIENTS=$SORT_CLIENTS"
+echo "  ACTIVITY_SIZE=$ACTIVITY_SIZE"
+echo "  ACTIVITY_DIR=$ACTIVITY_DIR"
+echo "  CACHE_DIR=$CACHE_DIR"
+echo "  AVATARS=$AVATARS"
+echo "  IMAGE_PROXY=$IMAGE_PROXY"
+echo "  WEBHOOKS=*********"
+echo "  HOST_KEY_FINGERPRINT=$HOST_KEY_FINGERPRINT"
+echo "  KNOWN_HOSTS_FILE=$KNOWN_HOSTS_FILE"
+echo "  HOST_KEY_TOFU=$HOST_KEY_TOFU"
+echo "  FILE_TRANSFER_HOST=$FILE_TRANSFER_HOST"
+echo "  FILE_TRANSFER_PORT=$FILE_TRANSFER_PORT"
 
 echo "[entrypoint] Starting server..."
 
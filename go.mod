@@ -2,6 +2,11 @@ module ts6-viewer
 
 go 1.25.6
 
-require golang.org/x/crypto v0.47.0
+require (
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.47.0
+	golang.org/x/net v0.49.0
+	golang.org/x/sync v0.19.0
+)
 
 require golang.org/x/sys v0.40.0 // indirect
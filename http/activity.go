@@ -0,0 +1,82 @@
+package http
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"ts6-viewer/internal/activity"
+	"ts6-viewer/internal/config"
+)
+
+const (
+	defaultActivitySize = 500 // events kept per server
+	activityPanelSize   = 20  // events shown on the page
+	activityPageSize    = 100 // events per /activity response
+)
+
+// activityPage is the response of the /activity endpoint. Next is the
+// since value of the following page.
+type activityPage struct {
+	Events []activity.Event `json:"events"`
+	Next   uint64           `json:"next"`
+}
+
+// openActivityFeed returns the activity feed of the server named name. It
+// is kept in memory only unless activity_dir is set; the file is closed
+// when ctx is done.
+func openActivityFeed(ctx context.Context, cfg *config.Config, name string) *activity.Feed {
+	size, err := strconv.Atoi(cfg.ActivitySize)
+	if err != nil || size <= 0 {
+		size = defaultActivitySize
+	}
+
+	if cfg.ActivityDir == "" {
+		return activity.NewFeed(size)
+	}
+
+	feed, err := activity.Open(filepath.Join(cfg.ActivityDir, name+".jsonl"), size)
+	if err != nil {
+		log.Printf("[HTTP] Keeping activity of %q in memory only: %v\n", name, err)
+		return activity.NewFeed(size)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := feed.Close(); err != nil {
+			log.Printf("[HTTP] Error closing activity of %q: %v\n", name, err)
+		}
+	}()
+
+	return feed
+}
+
+// activitySince returns a page of events after since, which is either the
+// next value of the previous page or an RFC 3339 time. An empty since
+// starts at the oldest event kept.
+func activitySince(feed *activity.Feed, since string) (activityPage, bool) {
+	var page activityPage
+
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		page.Events = feed.SinceTime(t, activityPageSize)
+		page.Next = feed.LastID()
+	} else {
+		if since != "" {
+			page.Next, err = strconv.ParseUint(since, 10, 64)
+			if err != nil {
+				return page, false
+			}
+		}
+		page.Events = feed.Since(page.Next, activityPageSize)
+	}
+
+	if len(page.Events) > 0 {
+		page.Next = page.Events[len(page.Events)-1].ID
+	} else {
+		page.Events = []activity.Event{}
+	}
+
+	return page, true
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/activity"
+	"ts6-viewer/internal/ts6"
+)
+
+func TestActivitySince(t *testing.T) {
+	at := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	// 250 clients join at 10:00; a minute later the first 10 leave
+	var clients []ts6.Client
+	for clid := 1; clid <= 250; clid++ {
+		clients = append(clients, ts6.Client{CLID: clid, CID: 1})
+	}
+	feed := activity.NewFeed(500)
+	feed.Observe(nil, nil, at.Add(-time.Minute))
+	feed.Observe(clients, nil, at)
+	feed.Observe(clients[10:], nil, at.Add(time.Minute))
+
+	tests := []struct {
+		since       string
+		first, last uint64 // IDs, 0 for no events
+		next        uint64
+	}{
+		{"", 1, 100, 100},
+		{"100", 101, 200, 200},
+		{"200", 201, 260, 260},
+		{"260", 0, 0, 260},
+		{"1000", 0, 0, 1000},
+		{at.Add(-time.Second).Format(time.RFC3339), 1, 100, 100},
+		{at.Format(time.RFC3339), 251, 260, 260},
+		{at.Add(time.Hour).Format(time.RFC3339), 0, 0, 260},
+	}
+
+	for _, tt := range tests {
+		page, ok := activitySince(feed, tt.since)
+		if !ok {
+			t.Errorf("since %q refused", tt.since)
+			continue
+		}
+		if page.Next != tt.next {
+			t.Errorf("since %q: next = %d, want %d", tt.since, page.Next, tt.next)
+		}
+
+		if tt.first == 0 {
+			if page.Events == nil || len(page.Events) != 0 {
+				t.Errorf("since %q: events = %#v, want an empty list", tt.since, page.Events)
+			}
+			continue
+		}
+		if n := len(page.Events); n == 0 || page.Events[0].ID != tt.first || page.Events[n-1].ID != tt.last {
+			t.Errorf("since %q: got %d events, want %d to %d", tt.since, n, tt.first, tt.last)
+		}
+	}
+
+	for _, since := range []string{"abc", "-1", "1.5", "2026-03-02"} {
+		if _, ok := activitySince(feed, since); ok {
+			t.Errorf("since %q accepted", since)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"ts6-viewer/internal/thumbnail"
+	"ts6-viewer/internal/ts6"
+)
+
+const (
+	// maxAvatarSize bounds avatar downloads before they are scaled down.
+	maxAvatarSize = 2 << 20
+
+	// avatarSize is the width and height of the served thumbnails, enough
+	// for the rows on high-density screens.
+	avatarSize = 64
+
+	// avatarCheckInterval is how often clientinfo is asked whether the
+	// avatar of a client changed. Browsers keep avatars as long.
+	avatarCheckInterval = 10 * time.Minute
+
+	// maxKnownAvatars bounds the remembered avatar properties; older
+	// entries are dropped beyond it.
+	maxKnownAvatars = 10000
+)
+
+var avatarCacheControl = fmt.Sprintf("public, max-age=%d", int(avatarCheckInterval/time.Second))
+
+// reAvatarToken matches the avatar flag and UID hash as sent by the
+// server. Anything else is not used in file names.
+var reAvatarToken = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// errBadAvatar reports an avatar that is not a usable image.
+var errBadAvatar = errors.New("avatar is not a usable image")
+
+// avatarStore tracks which avatar each client has and serves the
+// thumbnails from the file store.
+type avatarStore struct {
+	files *fileStore
+	group singleflight.Group // shares clientinfo lookups
+
+	mu    sync.Mutex
+	known map[string]avatarInfo // by server name and UID
+}
+
+type avatarInfo struct {
+	key     string // cache key of the thumbnail, empty without avatar
+	uidHash string
+	checked time.Time
+}
+
+func newAvatarStore(files *fileStore) *avatarStore {
+	return &avatarStore{files: files, known: make(map[string]avatarInfo)}
+}
+
+// url returns the avatar URL of a client of v, or "" if the client is
+// known to have no avatar.
+func (s *avatarStore) url(v *viewer, uid string) string {
+	if uid == "" {
+		return ""
+	}
+
+	s.mu.Lock()
+	info, ok := s.known[v.name+"/"+uid]
+	s.mu.Unlock()
+
+	if ok && info.key == "" && time.Since(info.checked) < avatarCheckInterval {
+		return ""
+	}
+	return v.basePath + "/avatar/" + url.PathEscape(uid)
+}
+
+// lookup returns the avatar of client c of v, asking the server with
+// clientinfo at most every avatarCheckInterval. A changed avatar flag
+// drops the old thumbnail from the cache.
+func (s *avatarStore) lookup(ctx context.Context, v *viewer, c ts6.Client) (avatarInfo, error) {
+	id := v.name + "/" + c.UniqueIdentifier
+
+	s.mu.Lock()
+	info, ok := s.known[id]
+	s.mu.Unlock()
+	if ok && time.Since(info.checked) < avatarCheckInterval {
+		return info, nil
+	}
+
+	res, err, _ := s.group.Do(id, func() (any, error) {
+		a, err := v.watcher.ClientAvatar(context.WithoutCancel(ctx), c.CLID)
+		if err != nil {
+			return avatarInfo{}, err
+		}
+		// The client ID may have been reused by another client since
+		if a.UniqueIdentifier != "" && a.UniqueIdentifier != c.UniqueIdentifier {
+			return avatarInfo{}, ts6.ErrInvalidClientID
+		}
+
+		next := avatarInfo{checked: time.Now()}
+		if reAvatarToken.MatchString(a.Flag) && reAvatarToken.MatchString(a.UIDHash) {
+			next.uidHash = a.UIDHash
+			next.key = "avatar_" + a.UIDHash + "_" + a.Flag
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if old, ok := s.known[id]; ok && old.key != "" && old.key != next.key {
+			s.files.forget(v, old.key)
+		}
+		if len(s.known) >= maxKnownAvatars {
+			for k, i := range s.known {
+				if time.Since(i.checked) >= avatarCheckInterval {
+					delete(s.known, k)
+				}
+			}
+		}
+		s.known[id] = next
+
+		return next, nil
+	})
+
+	return res.(avatarInfo), err
+}
+
+// serveAvatar serves the avatar thumbnail of an online client of v. With
+// avatars disabled, nothing is looked up on the server.
+func serveAvatar(avatars *avatarStore, v *viewer, w http.ResponseWriter, r *http.Request) {
+	if avatars == nil {
+		http.Error(w, "Avatars are disabled", http.StatusNotFound)
+		return
+	}
+
+	c, ok := onlineClient(v, r.PathValue("uid"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	info, err := avatars.lookup(r.Context(), v, c)
+	switch {
+	case errors.Is(err, ts6.ErrInvalidClientID):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		writeQueryError(w, err)
+		return
+	case info.key == "":
+		http.NotFound(w, r)
+		return
+	}
+
+	e, err := avatars.files.get(r.Context(), v, info.key, 0, ts6.AvatarName(info.uidHash), maxAvatarSize, func(data []byte) ([]byte, error) {
+		thumb, err := thumbnail.Make(data, avatarSize)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBadAvatar, err)
+		}
+		return thumb, nil
+	})
+	switch {
+	case errors.Is(err, ts6.ErrFileNotFound), errors.Is(err, errBadAvatar):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		writeQueryError(w, err)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "image/png")
+	h.Set("Cache-Control", avatarCacheControl)
+	if e.Hash != "" {
+		h.Set("ETag", `"`+e.Hash+`"`)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(e.Data))
+}
+
+// onlineClient finds the client with the unique identifier uid among the
+// clients of v, so that avatars of clients not shown are never fetched.
+func onlineClient(v *viewer, uid string) (ts6.Client, bool) {
+	if uid == "" {
+		return ts6.Client{}, false
+	}
+
+	_, clients, _, _ := v.watcher.Model().Snapshot()
+	for _, c := range clients {
+		if c.UniqueIdentifier == uid {
+			return c, true
+		}
+	}
+	return ts6.Client{}, false
+}
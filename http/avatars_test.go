@@ -0,0 +1,196 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/ts6"
+	"ts6-viewer/internal/ts6/ts6test"
+)
+
+// Alice of the ts6test fixtures, and the file name of her avatar.
+const (
+	aliceUID     = "YWxpY2UtdWlkLWZvci10ZXN0cz0="
+	aliceUIDHash = "gbhmjgdlgdbgjmhehnilgbiocheefaocpmdnbmji"
+)
+
+var alice = ts6.Client{CLID: 5, CID: 1, Nickname: "Alice", UniqueIdentifier: aliceUID}
+
+// newTestViewer returns a viewer of a fake server, synced once.
+func newTestViewer(t *testing.T) (*ts6test.Server, *viewer) {
+	t.Helper()
+
+	srv, err := ts6test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	cfg := &config.Config{Teamspeak6: srv.Teamspeak6()}
+	v := &viewer{
+		name:     cfg.Teamspeak6.Name,
+		basePath: "/ts6viewer/" + cfg.Teamspeak6.Name,
+		cfg:      cfg,
+		watcher:  ts6.NewWatcher(cfg, time.Hour),
+	}
+	if err := v.watcher.Resync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return srv, v
+}
+
+// setAvatar makes clientinfo report uid with the given avatar flag.
+func setAvatar(srv *ts6test.Server, uid, flag string) {
+	srv.SetResponse("clientinfo", "cid=1 client_unique_identifier="+uid+" client_nickname=Alice client_type=0"+
+		" client_flag_avatar="+flag+" client_base64HashClientUID="+aliceUIDHash)
+}
+
+// countClientInfo returns how often clientinfo was sent to srv.
+func countClientInfo(srv *ts6test.Server) int {
+	n := 0
+	for _, cmd := range srv.Commands() {
+		if strings.HasPrefix(cmd, "clientinfo ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestAvatarLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		uid     string
+		flag    string
+		wantKey string
+		wantErr error
+	}{
+		{"avatar", aliceUID, "0123abcdef", "avatar_" + aliceUIDHash + "_0123abcdef", nil},
+		{"no avatar", aliceUID, "", "", nil},
+		{"unusable flag", aliceUID, `..\/x`, "", nil},
+		{"client ID reused", "b3RoZXI=", "0123abcdef", "", ts6.ErrInvalidClientID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, v := newTestViewer(t)
+			s := newAvatarStore(newFileStore(v.cfg))
+
+			setAvatar(srv, tt.uid, tt.flag)
+
+			info, err := s.lookup(context.Background(), v, alice)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if info.key != tt.wantKey {
+				t.Errorf("key = %q, want %q", info.key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestAvatarLookupCached(t *testing.T) {
+	srv, v := newTestViewer(t)
+	s := newAvatarStore(newFileStore(v.cfg))
+
+	setAvatar(srv, aliceUID, "")
+	if _, err := s.lookup(context.Background(), v, alice); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.url(v, aliceUID); got != "" {
+		t.Errorf("url without avatar = %q, want none", got)
+	}
+
+	// Within avatarCheckInterval, the server is not asked again
+	setAvatar(srv, aliceUID, "0123abcdef")
+	info, err := s.lookup(context.Background(), v, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.key != "" || countClientInfo(srv) != 1 {
+		t.Errorf("key %q after %d clientinfo, want the remembered one after 1", info.key, countClientInfo(srv))
+	}
+}
+
+func TestAvatarFlagChanged(t *testing.T) {
+	srv, v := newTestViewer(t)
+	s := newAvatarStore(newFileStore(v.cfg))
+	ctx := context.Background()
+
+	setAvatar(srv, aliceUID, "0123abcdef")
+	old, err := s.lookup(ctx, v, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.files.cache.Put(v.name+"/"+old.key, []byte("old thumbnail")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the check is due, the changed flag drops the old thumbnail
+	s.known[v.name+"/"+aliceUID] = avatarInfo{key: old.key, uidHash: old.uidHash, checked: time.Now().Add(-avatarCheckInterval)}
+	setAvatar(srv, aliceUID, "fedcba9876")
+
+	info, err := s.lookup(ctx, v, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.key == old.key {
+		t.Fatalf("key = %q, want a new one", info.key)
+	}
+	if _, ok := s.files.cache.Get(v.name + "/" + old.key); ok {
+		t.Error("old thumbnail still cached")
+	}
+	if size := s.files.cache.Size(); size != 0 {
+		t.Errorf("cache holds %d bytes, want the old thumbnail removed", size)
+	}
+}
+
+func TestServeAvatar(t *testing.T) {
+	srv, v := newTestViewer(t)
+	s := newAvatarStore(newFileStore(v.cfg))
+
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 200, 100))); err != nil {
+		t.Fatal(err)
+	}
+	setAvatar(srv, aliceUID, "0123abcdef")
+	srv.SetFile(ts6.AvatarName(aliceUIDHash), src.Bytes())
+
+	serve := func(uid string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/ts6viewer/default/avatar/"+uid, nil)
+		req.SetPathValue("uid", uid)
+		rec := httptest.NewRecorder()
+		serveAvatar(s, v, rec, req)
+		return rec.Result()
+	}
+
+	res := serve(aliceUID)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("status %d, type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	img, err := png.Decode(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != avatarSize || b.Dy() != avatarSize {
+		t.Errorf("thumbnail is %v, want %d×%d", b.Size(), avatarSize, avatarSize)
+	}
+
+	// Served from the cache the second time
+	if res := serve(aliceUID); res.StatusCode != http.StatusOK || srv.Downloads() != 1 {
+		t.Errorf("status %d after %d downloads, want 200 after 1", res.StatusCode, srv.Downloads())
+	}
+
+	// Clients that are not online are not looked up
+	if res := serve("b3RoZXI="); res.StatusCode != http.StatusNotFound {
+		t.Errorf("offline client = %d, want 404", res.StatusCode)
+	}
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"ts6-viewer/internal/ts6"
+)
+
+// descriptionCache keeps channel descriptions for one refresh interval,
+// so that visitors expanding the same channel cost one channelinfo.
+type descriptionCache struct {
+	group singleflight.Group // shares channelinfo of the same channel
+
+	mu      sync.Mutex
+	entries map[string]cachedDescription // by server name and CID
+}
+
+type cachedDescription struct {
+	text string
+	at   time.Time
+}
+
+func newDescriptionCache() *descriptionCache {
+	return &descriptionCache{entries: make(map[string]cachedDescription)}
+}
+
+// get returns the description of channel cid of v. Channels that are not
+// in the model are reported as ts6.ErrInvalidChannelID without asking the
+// server. Concurrent calls for an uncached channel share one channelinfo;
+// ctx only bounds how long the caller waits for it.
+func (c *descriptionCache) get(ctx context.Context, v *viewer, cid int) (string, error) {
+	if !channelExists(v, cid) {
+		return "", ts6.ErrInvalidChannelID
+	}
+
+	key := v.name + "/" + strconv.Itoa(cid)
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(e.at) < v.resyncInterval {
+		return e.text, nil
+	}
+
+	ch := c.group.DoChan(key, func() (any, error) {
+		info, err := v.watcher.ChannelInfo(context.WithoutCancel(ctx), cid)
+		if err != nil {
+			return "", err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		// Drop what expired, e.g. deleted channels
+		for k, e := range c.entries {
+			if time.Since(e.at) >= v.resyncInterval {
+				delete(c.entries, k)
+			}
+		}
+		c.entries[key] = cachedDescription{text: info.Description, at: time.Now()}
+
+		return info.Description, nil
+	})
+
+	select {
+	case res := <-ch:
+		return res.Val.(string), res.Err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// channelExists reports whether channel cid is in the model of v.
+func channelExists(v *viewer, cid int) bool {
+	channels, _, _, _ := v.watcher.Model().Snapshot()
+	for _, ch := range channels {
+		if ch.CID == cid {
+			return true
+		}
+	}
+	return false
+}
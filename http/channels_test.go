@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/ts6"
+	"ts6-viewer/internal/ts6/ts6test"
+)
+
+func TestDescriptionCacheSharesChannelInfo(t *testing.T) {
+	srv, v := newTestViewer(t)
+	v.resyncInterval = time.Minute
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv.Handle("channelinfo", func(req *ts6test.Request) ts6test.Response {
+		calls.Add(1)
+		<-release
+		return ts6test.Response{Body: `channel_name=Lobby channel_description=Hello`}
+	})
+
+	c := newDescriptionCache()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Go(func() {
+			text, err := c.get(context.Background(), v, 1)
+			if err == nil && text != "Hello" {
+				err = errors.New("description = " + text)
+			}
+			errs <- err
+		})
+	}
+
+	// Let every request wait for the first channelinfo
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d channelinfo for concurrent requests, want 1", n)
+	}
+
+	// Cached for the refresh interval
+	if _, err := c.get(context.Background(), v, 1); err != nil || calls.Load() != 1 {
+		t.Errorf("cached description: err %v after %d channelinfo", err, calls.Load())
+	}
+}
+
+func TestDescriptionCacheCancel(t *testing.T) {
+	srv, v := newTestViewer(t)
+	v.resyncInterval = time.Minute
+
+	release := make(chan struct{})
+	srv.Handle("channelinfo", func(req *ts6test.Request) ts6test.Response {
+		<-release
+		return ts6test.Response{Body: `channel_name=Lobby channel_description=Hello`}
+	})
+
+	c := newDescriptionCache()
+
+	// A request giving up does not fail the channelinfo for others
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.get(ctx, v, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the deadline", err)
+	}
+
+	close(release)
+	text, err := c.get(context.Background(), v, 1)
+	if err != nil || text != "Hello" {
+		t.Errorf("description = %q, %v", text, err)
+	}
+
+	// Unknown channels are not looked up
+	if _, err := c.get(context.Background(), v, 99); !errors.Is(err, ts6.ErrInvalidChannelID) {
+		t.Errorf("unknown channel: err = %v", err)
+	}
+	n := 0
+	for _, cmd := range srv.Commands() {
+		if strings.HasPrefix(cmd, "channelinfo ") {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d channelinfo, want 1", n)
+	}
+}
@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/filecache"
+	"ts6-viewer/internal/ts6"
+)
+
+const (
+	// maxIconSize bounds icon downloads; TeamSpeak icons are a few KB.
+	maxIconSize = 1 << 20
+
+	// fileRetryInterval is how long a failed download is not attempted
+	// again, so that missing files do not cost a ServerQuery command on
+	// every request. Downloads failing for want of a connection are
+	// retried right away.
+	fileRetryInterval = 10 * time.Minute
+
+	// defaultCacheSize is the size in MB of the file cache.
+	defaultCacheSize = 64
+
+	// iconCacheControl lets browsers keep icons: an icon ID is the CRC32
+	// of the icon, so a changed icon gets a new URL.
+	iconCacheControl = "public, max-age=31536000, immutable"
+)
+
+// fileStore downloads files of the virtual servers over ServerQuery file
+// transfer and keeps them in a cache shared by all servers.
+type fileStore struct {
+	cache *filecache.Cache
+	group singleflight.Group // shares downloads of the same file
+
+	mu     sync.Mutex
+	failed map[string]failedDownload // by cache key
+}
+
+type failedDownload struct {
+	err error
+	at  time.Time
+}
+
+// newFileStore caches files in cfg.CacheDir, or in memory if it is empty
+// or cannot be used, up to cfg.CacheSize MB.
+func newFileStore(cfg *config.Config) *fileStore {
+	size, err := strconv.Atoi(cfg.CacheSize)
+	if err != nil || size <= 0 {
+		size = defaultCacheSize
+	}
+	maxSize := int64(size) << 20
+
+	s := &fileStore{cache: filecache.New(maxSize), failed: make(map[string]failedDownload)}
+
+	if cfg.CacheDir == "" {
+		return s
+	}
+
+	cache, err := filecache.Open(cfg.CacheDir, maxSize)
+	if err != nil {
+		log.Printf("[HTTP] Cannot use cache directory, caching in memory: %v\n", err)
+		return s
+	}
+	s.cache = cache
+	log.Printf("[HTTP] Caching downloaded files in %s\n", cfg.CacheDir)
+
+	return s
+}
+
+// get returns the file cached under key for v, or downloads the file name
+// of channel cid, 0 for icons and avatars. If convert is not nil, it is
+// applied to the download and its result is cached instead.
+func (s *fileStore) get(ctx context.Context, v *viewer, key string, cid int, name string, maxSize int64, convert func([]byte) ([]byte, error)) (filecache.Entry, error) {
+	key = v.name + "/" + key
+
+	if e, ok := s.cache.Get(key); ok {
+		return e, nil
+	}
+
+	s.mu.Lock()
+	f, failed := s.failed[key]
+	s.mu.Unlock()
+	if failed && time.Since(f.at) < fileRetryInterval {
+		return filecache.Entry{}, f.err
+	}
+
+	e, err, _ := s.group.Do(key, func() (any, error) {
+		// Downloads outlive the request that started them, so that
+		// other waiting requests are not failed by its cancellation
+		data, err := v.watcher.DownloadFile(context.WithoutCancel(ctx), cid, name, maxSize)
+		if err == nil && convert != nil {
+			data, err = convert(data)
+		}
+
+		s.mu.Lock()
+		if err != nil && !errors.Is(err, ts6.ErrConnectionLost) {
+			s.failed[key] = failedDownload{err: err, at: time.Now()}
+		} else {
+			delete(s.failed, key)
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			log.Printf("[HTTP] Downloading %s of %q failed: %v\n", name, v.name, err)
+			return filecache.Entry{}, err
+		}
+
+		e, err := s.cache.Put(key, data)
+		if err != nil {
+			log.Printf("[HTTP] Caching %s of %q failed: %v\n", name, v.name, err)
+			return filecache.Entry{Data: data}, nil
+		}
+		return e, nil
+	})
+
+	return e.(filecache.Entry), err
+}
+
+// forget drops key of v from the cache, e.g. once the file changed.
+func (s *fileStore) forget(v *viewer, key string) {
+	if err := s.cache.Delete(v.name + "/" + key); err != nil {
+		log.Printf("[HTTP] %v\n", err)
+	}
+}
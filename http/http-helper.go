@@ -1,89 +1,204 @@
 package http
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 	"ts6-viewer/internal/config"
 	"ts6-viewer/internal/ts6"
 	"ts6-viewer/internal/view"
 )
 
-// getIP extracts the IP address from the request.
+// getIP returns the client address determined by clientIPMiddleware.
 func getIP(r *http.Request) string {
-	ip := r.RemoteAddr
-	if ipForwarded := r.Header.Get("X-Forwarded-For"); ipForwarded != "" {
-		ip = ipForwarded
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
 	}
-	return ip
+	return r.RemoteAddr
 }
 
-// allowRequest checks rate limiting per IP.
-func allowRequest(ip string) bool {
-	mu.Lock()
-	defer mu.Unlock()
+// writeRateLimited answers a request over the rate limit with 429 and the
+// number of seconds until the client may retry.
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	retry := int((wait + time.Second - 1) / time.Second)
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	http.Error(w, "Too many requests, please slow down", http.StatusTooManyRequests)
+}
 
-	now := time.Now()
-	last, ok := lastRequestTime[ip]
-	if ok && now.Sub(last) < rateLimitWindow {
-		return false
+// writeQueryError maps an error from the TS6 server onto an HTTP status.
+// The ServerQuery status line is only logged, never sent to the client.
+func writeQueryError(w http.ResponseWriter, err error) {
+	var (
+		qe *ts6.QueryError
+		ue *ts6.UnavailableError
+	)
+
+	switch {
+	case errors.As(err, &ue):
+		http.Error(w, unreachableMessage(ue.Since), http.StatusServiceUnavailable)
+	case errors.Is(err, ts6.ErrFlood):
+		retry := 1
+		if errors.As(err, &qe) {
+			if after := qe.RetryAfter(); after > time.Second {
+				retry = int((after + time.Second - 1) / time.Second)
+			}
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		http.Error(w, "TeamSpeak server is rate limiting requests", http.StatusServiceUnavailable)
+	case errors.Is(err, ts6.ErrConnectionLost):
+		http.Error(w, "TeamSpeak server unreachable", http.StatusServiceUnavailable)
+	case errors.Is(err, ts6.ErrInvalidLogin):
+		http.Error(w, "TeamSpeak server rejected the ServerQuery login", http.StatusBadGateway)
+	case errors.Is(err, ts6.ErrInvalidServerID):
+		http.Error(w, "TeamSpeak virtual server not found", http.StatusBadGateway)
+	case errors.Is(err, ts6.ErrInsufficientPermissions):
+		http.Error(w, "ServerQuery user lacks required permissions", http.StatusBadGateway)
+	case errors.As(err, &qe):
+		http.Error(w, "TeamSpeak server returned an error", http.StatusBadGateway)
+	default:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
+}
+
+// unreachableMessage tells users since when the TS6 server has been down.
+func unreachableMessage(since time.Time) string {
+	layout := "15:04"
+	if now := time.Now(); since.YearDay() != now.YearDay() || since.Year() != now.Year() {
+		layout = "Jan 2 15:04"
+	}
+	return "TeamSpeak server unreachable since " + since.Format(layout)
+}
 
-	lastRequestTime[ip] = now
-	return true
+// serverStatus describes the connection of one server for /health.
+type serverStatus struct {
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	Message    string `json:"message,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+	Reconnects int    `json:"reconnects"`
 }
 
-// getViewerData fetches or returns cached viewer data.
-func getViewerData(cfg *config.Config, force bool) (view.VMTS6Viewer, error) {
-	mu.Lock()
-	defer mu.Unlock()
+// buildServerStatus reports the connection state of v. The second return
+// value is false unless the connection is ready.
+func buildServerStatus(v *viewer) (serverStatus, bool) {
+	st := v.watcher.Status()
 
-	if !force && time.Since(cacheTimestamp) < cacheTTL {
-		log.Println("[HTTP] Returning cached viewer data")
-		return cacheData, nil
+	s := serverStatus{
+		Name:       v.name,
+		State:      st.State.String(),
+		Reconnects: st.Reconnects,
 	}
+	if st.LastError != nil {
+		s.LastError = st.LastError.Error()
+	}
+	if st.State == ts6.StateReady {
+		return s, true
+	}
+
+	if st.DownSince.IsZero() {
+		s.Message = "Connecting to TeamSpeak server"
+	} else {
+		s.Message = unreachableMessage(st.DownSince)
+	}
+
+	return s, false
+}
 
-	log.Println("[HTTP] Fetching new viewer data from TS6 server")
-	sshClient, err := ts6.GetPersistentClient(cfg, cfg.Teamspeak6.ServerID)
-	if err != nil {
-		log.Printf("[HTTP] Failed to get SSH client: %v\n", err)
-		return view.VMTS6Viewer{}, err
+// unavailableViewerData shows the last known state of the server with
+// status as a notice, or an empty page if it was never synced.
+func unavailableViewerData(v *viewer, status string) view.VMTS6Viewer {
+	channels, clients, info, ok := v.watcher.Model().Snapshot()
+	if !ok {
+		info = &ts6.ServerInfo{Name: v.name}
 	}
 
-	channels, err := ts6.GetChannelList(cfg, sshClient)
-	if err != nil {
-		log.Printf("[HTTP] Failed to get channels: %v\n", err)
-		return view.VMTS6Viewer{}, err
+	data := buildViewerData(v, channels, clients, info)
+	data.Status = status
+	return data
+}
+
+// serverName is the name of the virtual server as last synced, or the
+// configured name before the first sync.
+func serverName(v *viewer) string {
+	if _, _, info, ok := v.watcher.Model().Snapshot(); ok && info.Name != "" {
+		return info.Name
 	}
+	return v.name
+}
 
-	clients, err := ts6.GetClientList(cfg, sshClient)
-	if err != nil {
-		log.Printf("[HTTP] Failed to get clients: %v\n", err)
-		return view.VMTS6Viewer{}, err
+// countOnline counts the clients that are not ServerQuery clients.
+func countOnline(clients []ts6.Client) int {
+	n := 0
+	for _, cl := range clients {
+		if cl.Type == 0 {
+			n++
+		}
 	}
+	return n
+}
 
-	info, err := ts6.GetServerInfo(cfg, sshClient)
-	if err != nil {
-		log.Printf("[HTTP] Failed to get server info: %v\n", err)
-		return view.VMTS6Viewer{}, err
+// clientOptions are the group badge and sort settings of v with the
+// groups last fetched from the server.
+func (v *viewer) clientOptions() view.ClientOptions {
+	return v.clientOpts.WithGroups(v.watcher.Model().Groups())
+}
+
+// buildViewerData converts a model snapshot into the page view model.
+func buildViewerData(v *viewer, channels []ts6.Channel, clients []ts6.Client, info *ts6.ServerInfo) view.VMTS6Viewer {
+	opts := v.clientOptions()
+	return view.VMTS6Viewer{
+		VMServer:        view.BuildVMServer(v.cfg, info, clients, v.markup),
+		VMChannels:      view.BuildVMChannels(channels, clients, &opts),
+		Theme:           v.cfg.Theme,
+		RefreshInterval: v.cfg.RefreshInterval,
+		BasePath:        v.basePath,
+		StatsPath:       v.statsPath,
+		Activity:        view.BuildVMActivity(v.activity.Recent(activityPanelSize)),
 	}
+}
 
-	maxWidth := cfg.MaxWidth
-	if maxWidth == "" {
-		maxWidth = "800px"
+// buildIndexData lists all servers with their online counts. Servers whose
+// model has not been synced yet are marked unavailable.
+func buildIndexData(cfg *config.Config, viewers []*viewer) view.VMIndex {
+	index := view.VMIndex{
+		Theme: cfg.Theme,
 	}
 
-	vmTS6Viewer := view.VMTS6Viewer{
-		VMServer:        view.BuildVMServer(cfg, info, clients),
-		VMChannels:      view.BuildVMChannels(channels, clients),
-		Theme:           cfg.Theme,
-		RefreshInterval: cfg.RefreshInterval,
-		MaxWidth:        maxWidth,
+	for _, v := range viewers {
+		_, clients, info, ok := v.watcher.Model().Snapshot()
+		if !ok {
+			index.Servers = append(index.Servers, view.BuildVMIndexServer(v.name, v.basePath, nil, nil))
+			continue
+		}
+		index.Servers = append(index.Servers, view.BuildVMIndexServer(v.name, v.basePath, info, clients))
 	}
 
-	cacheData = vmTS6Viewer
-	cacheTimestamp = time.Now()
-	log.Println("[HTTP] Viewer data updated and cached")
+	return index
+}
+
+func maxWidth(cfg *config.Config) string {
+	if cfg.MaxWidth == "" {
+		return "800px"
+	}
+	if strings.ContainsAny(cfg.MaxWidth, ";{}<>\\\"'") {
+		log.Printf("[HTTP] Ignoring invalid max_width %q\n", cfg.MaxWidth)
+		return "800px"
+	}
+	return cfg.MaxWidth
+}
 
-	return vmTS6Viewer, nil
+// layoutCSS is the stylesheet served as /static/layout.css. It carries the
+// configured max_width so that the pages need no inline styles.
+func layoutCSS(cfg *config.Config) string {
+	return fmt.Sprintf(`@media (min-width: 600px) {
+    #channels, .server-info, .server-status, .activity { max-width: %s !important; }
+}
+`, maxWidth(cfg))
 }
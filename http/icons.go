@@ -0,0 +1,83 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ts6-viewer/internal/ts6"
+)
+
+// serveIcon serves an icon of v's file storage. Only icons shown in the
+// viewer are downloaded, so that the endpoint cannot be used to make the
+// TS6 server look up arbitrary files.
+func serveIcon(files *fileStore, v *viewer, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil || !ts6.RemoteIcon(int64(id)) || !iconReferenced(v, uint32(id)) {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := ts6.IconName(int64(id))
+	e, err := files.get(r.Context(), v, strings.TrimPrefix(name, "/"), 0, name, maxIconSize, nil)
+	switch {
+	case errors.Is(err, ts6.ErrFileNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		writeQueryError(w, err)
+		return
+	}
+
+	// Icons are PNG, GIF or JPEG; anything else, e.g. an SVG with
+	// scripts, is not passed on
+	contentType := http.DetectContentType(e.Data)
+	if !strings.HasPrefix(contentType, "image/") {
+		log.Printf("[HTTP] Icon %d of %q is not an image: %s\n", id, v.name, contentType)
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", iconCacheControl)
+	if e.Hash != "" {
+		h.Set("ETag", `"`+e.Hash+`"`)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(e.Data))
+}
+
+// iconReferenced reports whether a channel, client or group of v uses the
+// icon.
+func iconReferenced(v *viewer, id uint32) bool {
+	model := v.watcher.Model()
+	channels, clients, _, _ := model.Snapshot()
+	serverGroups, channelGroups := model.Groups()
+
+	for _, ch := range channels {
+		if uint32(ch.IconID) == id {
+			return true
+		}
+	}
+	for _, c := range clients {
+		if uint32(c.IconID) == id {
+			return true
+		}
+	}
+	for _, g := range serverGroups {
+		if uint32(g.IconID) == id {
+			return true
+		}
+	}
+	for _, g := range channelGroups {
+		if uint32(g.IconID) == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,265 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// maxProxiedImageSize bounds images fetched for descriptions and
+	// welcome messages.
+	maxProxiedImageSize = 1 << 20
+
+	// maxProxiedImages bounds the images kept in memory; the oldest are
+	// dropped beyond it.
+	maxProxiedImages = 128
+
+	// proxiedImageTTL is how long a fetched image, or the failure to fetch
+	// it, is kept. Browsers keep images as long.
+	proxiedImageTTL = time.Hour
+
+	// imageFetchTimeout bounds fetching one image, redirects included.
+	imageFetchTimeout = 10 * time.Second
+)
+
+var proxiedImageCacheControl = fmt.Sprintf("public, max-age=%d", int(proxiedImageTTL/time.Second))
+
+// errNotAnImage reports a response that is not a PNG, GIF, JPEG or WebP
+// image.
+var errNotAnImage = errors.New("not an image")
+
+// imageProxy fetches the images of descriptions and welcome messages, so
+// that the page loads them from the viewer. Only URLs signed by url can be
+// fetched, and never from loopback or private addresses.
+type imageProxy struct {
+	key    []byte // signs image URLs, new on every start
+	client *http.Client
+	group  singleflight.Group // shares fetches of the same image
+
+	mu     sync.Mutex
+	images map[string]proxiedImage // by source URL
+}
+
+type proxiedImage struct {
+	data        []byte // nil if fetching failed
+	contentType string
+	at          time.Time
+}
+
+func newImageProxy() *imageProxy {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal("[HTTP] Cannot create image proxy key:", err)
+	}
+
+	dialer := &net.Dialer{Timeout: imageFetchTimeout, Control: publicAddressOnly}
+	transport := &http.Transport{
+		Proxy:               nil, // a proxy would dial the image host itself
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: imageFetchTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     time.Minute,
+	}
+
+	return &imageProxy{
+		key:    key,
+		client: &http.Client{Transport: transport, Timeout: imageFetchTimeout},
+		images: make(map[string]proxiedImage),
+	}
+}
+
+// url returns the address of the image src proxied for v.
+func (p *imageProxy) url(v *viewer, src string) string {
+	q := url.Values{"url": {src}, "sig": {p.sign(src)}}
+	return v.basePath + "/image?" + q.Encode()
+}
+
+func (p *imageProxy) sign(src string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(src))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// get returns the image at src, fetching it at most once per
+// proxiedImageTTL.
+func (p *imageProxy) get(ctx context.Context, src string) (proxiedImage, error) {
+	p.mu.Lock()
+	img, ok := p.images[src]
+	p.mu.Unlock()
+	if ok && time.Since(img.at) < proxiedImageTTL {
+		if img.data == nil {
+			return img, errNotAnImage
+		}
+		return img, nil
+	}
+
+	res, err, _ := p.group.Do(src, func() (any, error) {
+		img, err := p.fetch(context.WithoutCancel(ctx), src)
+		if err != nil {
+			log.Printf("[HTTP] Cannot proxy image %s: %v\n", src, err)
+			img = proxiedImage{}
+		}
+		img.at = time.Now()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if len(p.images) >= maxProxiedImages {
+			oldest := ""
+			for k, i := range p.images {
+				if oldest == "" || i.at.Before(p.images[oldest].at) {
+					oldest = k
+				}
+			}
+			delete(p.images, oldest)
+		}
+		p.images[src] = img
+
+		if img.data == nil {
+			return img, errNotAnImage
+		}
+		return img, nil
+	})
+
+	return res.(proxiedImage), err
+}
+
+func (p *imageProxy) fetch(ctx context.Context, src string) (proxiedImage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return proxiedImage{}, fmt.Errorf("status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProxiedImageSize+1))
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	if len(data) > maxProxiedImageSize {
+		return proxiedImage{}, fmt.Errorf("larger than %d bytes", maxProxiedImageSize)
+	}
+
+	// Sniffed rather than taken from the response, so that e.g. an SVG
+	// with scripts is never served from the viewer's origin
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return proxiedImage{}, fmt.Errorf("%w: %s", errNotAnImage, contentType)
+	}
+
+	return proxiedImage{data: data, contentType: contentType}, nil
+}
+
+// serve serves a proxied image. Requests without a valid signature are
+// rejected, so that the viewer cannot be used to fetch arbitrary URLs.
+func (p *imageProxy) serve(w http.ResponseWriter, r *http.Request) {
+	if p == nil {
+		http.Error(w, "Image proxy is disabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	src := q.Get("url")
+	if src == "" || !hmac.Equal([]byte(q.Get("sig")), []byte(p.sign(src))) {
+		http.NotFound(w, r)
+		return
+	}
+
+	img, err := p.get(r.Context(), src)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", img.contentType)
+	h.Set("Cache-Control", proxiedImageCacheControl)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(img.data))
+}
+
+// nonPublicPrefixes are the special-purpose ranges that IsGlobalUnicast
+// and IsPrivate let through, e.g. shared address space of carrier-grade
+// NAT, benchmarking networks and NAT64, which reaches IPv4 addresses.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved
+	netip.MustParsePrefix("::/96"),           // IPv4-compatible
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/32"),       // Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+}
+
+// sixToFour holds 6to4 addresses, which embed an IPv4 address in bits 16
+// to 48.
+var sixToFour = netip.MustParsePrefix("2002::/16")
+
+// publicAddressOnly refuses connections to loopback, private, link-local
+// and other non-public addresses. It runs after name resolution, for every
+// connection including redirects.
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !isPublicAddr(ip) {
+		return fmt.Errorf("refusing to connect to %s", host)
+	}
+	return nil
+}
+
+// isPublicAddr reports whether ip is a public unicast address, looking
+// through IPv4-mapped and 6to4 addresses at the IPv4 address they reach.
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap().WithZone("")
+
+	if sixToFour.Contains(ip) {
+		b := ip.As16()
+		return isPublicAddr(netip.AddrFrom4([4]byte(b[2:6])))
+	}
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,239 @@
+package http
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// pngHeader is enough of a PNG for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"::ffff:93.184.216.34", true},
+		{"2002:5db8:d822::1", true}, // 6to4 of 93.184.216.34
+
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"192.0.0.8", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::1", false},
+		{"::", false},
+		{"fe80::1", false},
+		{"fe80::1%eth0", false},
+		{"fd00::1", false},
+		{"ff02::1", false},
+		{"64:ff9b::7f00:1", false},
+		{"64:ff9b::5db8:d822", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:100.64.0.1", false},
+		{"::127.0.0.1", false},
+		{"2002:7f00:1::1", false},   // 6to4 of 127.0.0.1
+		{"2002:c0a8:101::1", false}, // 6to4 of 192.168.1.1
+		{"2001:db8::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+		err := publicAddressOnly("tcp", net.JoinHostPort(tt.addr, "80"), nil)
+		if (err == nil) != tt.want {
+			t.Errorf("publicAddressOnly(%s) = %v", tt.addr, err)
+		}
+	}
+}
+
+// newTestImageServer serves the given responses by path and counts the
+// requests it receives.
+func newTestImageServer(t *testing.T, files map[string][]byte) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// Claimed to be an image whatever it is
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, &requests
+}
+
+// proxy requests the image at the proxied address u from p.
+func proxy(p *imageProxy, u string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.serve(rec, httptest.NewRequest(http.MethodGet, u, nil))
+	return rec
+}
+
+func TestImageProxySignature(t *testing.T) {
+	ts, requests := newTestImageServer(t, map[string][]byte{"/a.png": pngHeader})
+	p := newImageProxy()
+	p.client = ts.Client()
+	v := &viewer{basePath: "/ts6viewer/default"}
+
+	src := ts.URL + "/a.png"
+	signed, err := url.Parse(p.url(v, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newImageProxy()
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"no signature", url.Values{"url": {src}}},
+		{"bad signature", url.Values{"url": {src}, "sig": {"x"}}},
+		{"signature of another URL", url.Values{"url": {src}, "sig": {p.sign(ts.URL + "/b.png")}}},
+		{"signature of another key", url.Values{"url": {src}, "sig": {other.sign(src)}}},
+		{"no URL", url.Values{"sig": {p.sign("")}}},
+	}
+
+	for _, tt := range tests {
+		rec := proxy(p, "/ts6viewer/default/image?"+tt.query.Encode())
+		if rec.Code != http.StatusNotFound && rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403 or 404", tt.name, rec.Code)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("%d requests for unsigned URLs", n)
+	}
+
+	if rec := proxy(p, signed.String()); rec.Code != http.StatusOK {
+		t.Errorf("signed URL: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestImageProxyRefusesLocalAddresses(t *testing.T) {
+	// Listening on loopback, like services on the viewer's host
+	ts, requests := newTestImageServer(t, map[string][]byte{"/a.png": pngHeader})
+	p := newImageProxy()
+	v := &viewer{basePath: "/ts6viewer/default"}
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"127.0.0.1", "[::ffff:127.0.0.1]", "localhost"} {
+		src := "http://" + host + ":" + u.Port() + "/a.png"
+		if rec := proxy(p, p.url(v, src)); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", src, rec.Code)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("%d requests reached the local server", n)
+	}
+}
+
+func TestImageProxyContent(t *testing.T) {
+	large := append(bytes.Clone(pngHeader), make([]byte, maxProxiedImageSize)...)
+	largest := large[:maxProxiedImageSize]
+
+	ts, _ := newTestImageServer(t, map[string][]byte{
+		"/image.png":   pngHeader,
+		"/page.png":    []byte("<!DOCTYPE html><script>alert(1)</script>"),
+		"/image.svg":   []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`),
+		"/largest.png": largest,
+		"/large.png":   large,
+	})
+	p := newImageProxy()
+	p.client = ts.Client()
+	v := &viewer{basePath: "/ts6viewer/default"}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/image.png", http.StatusOK},
+		{"/page.png", http.StatusNotFound},
+		{"/image.svg", http.StatusNotFound},
+		{"/largest.png", http.StatusOK},
+		{"/large.png", http.StatusNotFound},
+		{"/missing.png", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := proxy(p, p.url(v, ts.URL+tt.path))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.path, rec.Code, tt.want)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("%s: Content-Type = %q, want image/png", tt.path, ct)
+		}
+		if rec.Body.Len() > maxProxiedImageSize {
+			t.Errorf("%s: %d bytes served", tt.path, rec.Body.Len())
+		}
+	}
+}
+
+func TestImageProxyCache(t *testing.T) {
+	ts, requests := newTestImageServer(t, map[string][]byte{"/a.png": pngHeader})
+	p := newImageProxy()
+	p.client = ts.Client()
+	v := &viewer{basePath: "/ts6viewer/default"}
+
+	for range 3 {
+		if rec := proxy(p, p.url(v, ts.URL+"/a.png")); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests for a cached image, want 1", n)
+	}
+
+	// Failures are remembered too
+	for range 3 {
+		proxy(p, p.url(v, ts.URL+"/missing.png"))
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests after a failed one, want 2", n)
+	}
+
+	// Beyond maxProxiedImages, the oldest image is dropped and fetched
+	// again
+	for i := range maxProxiedImages {
+		proxy(p, p.url(v, ts.URL+"/missing.png?"+strconv.Itoa(i)))
+	}
+	if len(p.images) != maxProxiedImages {
+		t.Errorf("%d images kept, want %d", len(p.images), maxProxiedImages)
+	}
+	before := requests.Load()
+	proxy(p, p.url(v, ts.URL+"/a.png"))
+	if n := requests.Load(); n != before+1 {
+		t.Errorf("oldest image not dropped: %d requests, want %d", n, before+1)
+	}
+}
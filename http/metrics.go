@@ -0,0 +1,129 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"ts6-viewer/internal/metrics"
+	"ts6-viewer/internal/ts6"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("ts6viewer_http_requests_total",
+		"HTTP requests by route pattern and status code.", "route", "code")
+	cacheRequests = metrics.NewCounterVec("ts6viewer_cache_requests_total",
+		"Viewer data requests served from the snapshot (hit) or after a resync (miss).", "result")
+)
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the flusher of event streams.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// metricsMiddleware counts requests by the mux pattern they matched, which
+// keeps the number of label values bounded. It must wrap the mux directly
+// so that it sees the pattern the mux records on the request.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.Inc(route, strconv.Itoa(status))
+	})
+}
+
+// collectViewerMetrics reports the state of every server on each scrape
+// until ctx is done: the server info, clients per channel and per server
+// group, and the command connection.
+func collectViewerMetrics(ctx context.Context, viewers []*viewer) {
+	remove := metrics.Default.Collect(func(c *metrics.Collector) {
+		// Servers on one instance share the command connection, so its
+		// reconnects are reported once per instance, under the name of its
+		// first server. The instance key holds the login and stays out of
+		// the unauthenticated metrics.
+		instances := make(map[string]bool)
+
+		for _, v := range viewers {
+			st := v.watcher.Status()
+
+			up := 0.0
+			if st.State == ts6.StateReady {
+				up = 1
+			}
+			c.Gauge("ts6viewer_serverquery_up", "Whether the ServerQuery command connection is ready.", up, "server", v.name)
+
+			if instance := ts6.InstanceKey(v.cfg); !instances[instance] {
+				instances[instance] = true
+				c.Counter("ts6viewer_serverquery_reconnects_total", "Reconnects of the ServerQuery command connection.", float64(st.Reconnects), "server", v.name)
+			}
+
+			channels, clients, info, ok := v.watcher.Model().Snapshot()
+			if !ok {
+				continue
+			}
+
+			c.Gauge("ts6_server_clients_online", "Clients online, without ServerQuery clients.", float64(countOnline(clients)), "server", v.name)
+			c.Gauge("ts6_server_max_clients", "Client slots of the virtual server.", float64(info.MaxClients), "server", v.name)
+			c.Gauge("ts6_server_channels", "Channels of the virtual server.", float64(info.ChannelsOnline), "server", v.name)
+			c.Gauge("ts6_server_uptime_seconds", "Uptime of the virtual server.", info.Uptime.Seconds(), "server", v.name)
+			c.Counter("ts6_server_client_connections_total", "Client connections since the virtual server was created.", float64(info.ClientConnections), "server", v.name)
+
+			for _, ch := range channels {
+				c.Gauge("ts6_channel_clients", "Clients in a channel.", float64(ch.TotalClients),
+					"server", v.name, "cid", strconv.Itoa(ch.CID), "channel", ch.Name)
+			}
+
+			groups := make(map[int]int)
+			for _, cl := range clients {
+				if cl.Type != 0 {
+					continue // ServerQuery client
+				}
+				for _, sgid := range cl.ServerGroups {
+					groups[sgid]++
+				}
+			}
+			sgids := make([]int, 0, len(groups))
+			for sgid := range groups {
+				sgids = append(sgids, sgid)
+			}
+			sort.Ints(sgids)
+			for _, sgid := range sgids {
+				n := groups[sgid]
+				c.Gauge("ts6_server_group_clients", "Online clients per server group.", float64(n),
+					"server", v.name, "sgid", strconv.Itoa(sgid))
+			}
+		}
+	})
+
+	context.AfterFunc(ctx, remove)
+}
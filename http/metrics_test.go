@@ -0,0 +1,51 @@
+package http
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/ts6/ts6test"
+)
+
+// A router stops reporting once its context is done, so that routers built
+// one after another, as by these tests, do not pile up in /metrics.
+func TestMetricsCollectorsRemoved(t *testing.T) {
+	old, err := ts6test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	NewRouter(ctx, config.Config{Teamspeak6: old.Teamspeak6(), RefreshInterval: "60"})
+	cancel()
+
+	srv, h := newTestRouter(t, nil)
+
+	var body string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		body = get(h, "/metrics", "127.0.0.1").Body.String()
+		if strings.Count(body, `ts6viewer_serverquery_up{server="default"}`) == 1 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for _, line := range []string{
+		`ts6viewer_serverquery_up{server="default"}`,
+		`ts6viewer_serverquery_reconnects_total{server="default"}`,
+	} {
+		if n := strings.Count(body, line); n != 1 {
+			t.Errorf("%s reported %d times, want once", line, n)
+		}
+	}
+
+	// The login of the instance is not exposed
+	if strings.Contains(body, srv.User+"@") || strings.Contains(body, srv.Addr()) {
+		t.Errorf("metrics expose the instance:\n%s", body)
+	}
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{"direct", "203.0.113.7:51000", nil, "203.0.113.7"},
+		{"direct ipv6", "[2001:db8::7]:51000", nil, "2001:db8::7"},
+		{"ipv4-mapped", "[::ffff:203.0.113.7]:51000", nil, "203.0.113.7"},
+		{"no port", "203.0.113.7", nil, "203.0.113.7"},
+		{"unparsable remote", "@", nil, "@"},
+
+		// Headers from an untrusted peer are ignored
+		{"spoofed forwarded", "203.0.113.7:51000", map[string][]string{"Forwarded": {"for=198.51.100.1"}}, "203.0.113.7"},
+		{"spoofed x-forwarded-for", "203.0.113.7:51000", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"spoofed x-real-ip", "203.0.113.7:51000", map[string][]string{"X-Real-IP": {"198.51.100.1"}}, "203.0.113.7"},
+
+		{"forwarded", "10.0.0.1:443", map[string][]string{"Forwarded": {"for=198.51.100.1;proto=https"}}, "198.51.100.1"},
+		{"forwarded quoted with port", "10.0.0.1:443", map[string][]string{"Forwarded": {`for="198.51.100.1:4711"`}}, "198.51.100.1"},
+		{"forwarded ipv6", "10.0.0.1:443", map[string][]string{"Forwarded": {`For="[2001:db8::1]"`}}, "2001:db8::1"},
+		{"forwarded ipv6 with port", "10.0.0.1:443", map[string][]string{"Forwarded": {`for="[2001:db8::1]:4711";by=10.0.0.1`}}, "2001:db8::1"},
+		{"forwarded chain", "10.0.0.1:443", map[string][]string{"Forwarded": {`for=192.0.2.66, for=198.51.100.1`, `for=10.0.0.2`}}, "198.51.100.1"},
+		{"forwarded obfuscated", "10.0.0.1:443", map[string][]string{"Forwarded": {`for=unknown`}}, "10.0.0.1"},
+
+		{"x-forwarded-for", "10.0.0.1:443", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		// Walked right to left, past the trusted hops; the spoofed first
+		// entry is never reached
+		{"x-forwarded-for multi-hop", "10.0.0.1:443", map[string][]string{"X-Forwarded-For": {"192.0.2.66, 198.51.100.1, 10.0.0.3", "fd00::2"}}, "198.51.100.1"},
+		{"x-forwarded-for all trusted", "10.0.0.1:443", map[string][]string{"X-Forwarded-For": {"10.0.0.2, 10.0.0.3"}}, "10.0.0.2"},
+		{"x-forwarded-for garbage", "10.0.0.1:443", map[string][]string{"X-Forwarded-For": {"198.51.100.1, bogus"}}, "10.0.0.1"},
+
+		{"x-real-ip", "10.0.0.1:443", map[string][]string{"X-Real-IP": {"198.51.100.1"}}, "198.51.100.1"},
+		{"x-real-ip ipv6", "[fd00::1]:443", map[string][]string{"X-Real-IP": {"2001:db8::1"}}, "2001:db8::1"},
+
+		// Forwarded wins over X-Forwarded-For, which wins over X-Real-IP
+		{"precedence", "10.0.0.1:443", map[string][]string{
+			"Forwarded":       {"for=198.51.100.1"},
+			"X-Forwarded-For": {"198.51.100.2"},
+			"X-Real-IP":       {"198.51.100.3"},
+		}, "198.51.100.1"},
+		{"precedence without forwarded", "10.0.0.1:443", map[string][]string{
+			"X-Forwarded-For": {"198.51.100.2"},
+			"X-Real-IP":       {"198.51.100.3"},
+		}, "198.51.100.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for name, values := range tt.headers {
+				for _, v := range values {
+					r.Header.Add(name, v)
+				}
+			}
+
+			if got := clientIP(r, trusted); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(10, 2)
+
+	for i := range 2 {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	ok, wait := l.allow("a")
+	if ok {
+		t.Fatal("request beyond the burst allowed")
+	}
+	if wait <= 0 || wait > 100*time.Millisecond {
+		t.Errorf("wait = %v, want at most the 100ms of one token", wait)
+	}
+
+	// Buckets are per client
+	if ok, _ := l.allow("b"); !ok {
+		t.Error("other client refused")
+	}
+
+	time.Sleep(wait)
+	if ok, _ := l.allow("a"); !ok {
+		t.Error("refused after refilling")
+	}
+}
+
+func TestRateLimiterEvict(t *testing.T) {
+	l := newRateLimiter(1, 10)
+
+	l.allow("idle")
+	l.allow("busy")
+
+	l.mu.Lock()
+	l.buckets["idle"].last = time.Now().Add(-10 * time.Second)
+	l.mu.Unlock()
+
+	l.evict(time.Now())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("refilled bucket kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket still refilling dropped")
+	}
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"ts6-viewer/internal/ts6"
+	"ts6-viewer/internal/view"
+)
+
+// forcedResyncTimeout bounds a forced resync. It runs detached from the
+// request that started it, because other requests may be waiting for it.
+const forcedResyncTimeout = 30 * time.Second
+
+// viewerSnapshot is the read-only view model served for a server. It is
+// never modified once stored; refreshLoop swaps in a new one instead.
+type viewerSnapshot struct {
+	data   view.VMTS6Viewer
+	synced time.Time // last successful full resync of the model
+}
+
+// refreshLoop owns the snapshot of v. It rebuilds it from the live model
+// whenever the model changes and at least once per resync interval, so
+// requests only ever read the current snapshot and never wait for the TS6
+// server. It returns when ctx is done.
+func (v *viewer) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(v.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		// Taken before the rebuild, so that no change is missed
+		changed := v.watcher.Model().Changed()
+		v.rebuild()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// rebuild replaces the snapshot with one built from the current model,
+// records the activity since the last rebuild and passes it on to the
+// webhooks. It keeps the old snapshot while the model has never been
+// synced.
+func (v *viewer) rebuild() {
+	v.rebuildMu.Lock()
+	defer v.rebuildMu.Unlock()
+
+	model := v.watcher.Model()
+
+	channels, clients, info, ok := model.Snapshot()
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	events, err := v.activity.Observe(clients, view.ChannelNames(channels), now)
+	if err != nil {
+		log.Printf("[HTTP] Error recording activity of %q: %v\n", v.name, err)
+	}
+
+	online := countOnline(clients)
+	if v.online >= 0 {
+		v.webhooks.Observe(v.name, info.Name, events, v.online, online, now)
+	}
+	v.online = online
+
+	v.snapshot.Store(&viewerSnapshot{
+		data:   buildViewerData(v, channels, clients, info),
+		synced: model.LastSync(),
+	})
+
+	v.changedMu.Lock()
+	close(v.changed)
+	v.changed = make(chan struct{})
+	v.changedMu.Unlock()
+}
+
+// snapshotChanged returns a channel that is closed when the snapshot is
+// next replaced.
+func (v *viewer) snapshotChanged() <-chan struct{} {
+	v.changedMu.Lock()
+	defer v.changedMu.Unlock()
+
+	return v.changed
+}
+
+// renderedFragment is the fragment markup of one snapshot and notice.
+type renderedFragment struct {
+	snap   *viewerSnapshot
+	status string
+	html   []byte
+}
+
+// fragment returns the fragment markup of the current snapshot with its
+// notice, as served by the fragment endpoint. It is rendered once and
+// shared by all event streams. ok is false while there is no snapshot.
+func (v *viewer) fragment() (html []byte, ok bool) {
+	snap := v.snapshot.Load()
+	if snap == nil {
+		return nil, false
+	}
+	status := staleNotice(v, snap)
+
+	v.fragmentMu.Lock()
+	defer v.fragmentMu.Unlock()
+
+	if c := v.fragmentCache; c.snap == snap && c.status == status {
+		return c.html, true
+	}
+
+	data := snap.data
+	data.Status = status
+
+	var buf bytes.Buffer
+	if err := v.renderFragment(&buf, data); err != nil {
+		log.Printf("[HTTP] Template execution error: %v\n", err)
+		return nil, false
+	}
+
+	v.fragmentCache = renderedFragment{snap: snap, status: status, html: buf.Bytes()}
+	return buf.Bytes(), true
+}
+
+// forceResync resyncs the model from the TS6 server and rebuilds the
+// snapshot. Concurrent calls share a single resync; ctx only bounds how
+// long the caller waits for it.
+func (v *viewer) forceResync(ctx context.Context) error {
+	ch := v.resync.DoChan("resync", func() (any, error) {
+		rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), forcedResyncTimeout)
+		defer cancel()
+
+		log.Printf("[HTTP] Forcing model resync of %q from TS6 server\n", v.name)
+		if err := v.watcher.Resync(rctx); err != nil {
+			log.Printf("[HTTP] Failed to resync model: %v\n", err)
+			return nil, err
+		}
+
+		v.rebuild()
+		return nil, nil
+	})
+
+	select {
+	case res := <-ch:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// viewerData returns the data to serve for v. A forced request resyncs
+// first; if that fails, the last snapshot is served anyway with a notice
+// of its age. An error is only returned when there is nothing to serve.
+func (v *viewer) viewerData(ctx context.Context, force bool) (view.VMTS6Viewer, error) {
+	snap := v.snapshot.Load()
+
+	if !force && snap != nil {
+		cacheRequests.Inc("hit")
+	} else {
+		cacheRequests.Inc("miss")
+		err := v.forceResync(ctx)
+		if latest := v.snapshot.Load(); latest != nil {
+			snap = latest
+		}
+		if snap == nil {
+			return view.VMTS6Viewer{}, err
+		}
+	}
+
+	data := snap.data
+	data.Status = staleNotice(v, snap)
+	return data, nil
+}
+
+// staleNotice tells users how old the snapshot is once it has missed a
+// resync, for example because the TS6 server is unreachable. It is empty
+// while the snapshot is current.
+func staleNotice(v *viewer, snap *viewerSnapshot) string {
+	st := v.watcher.Status()
+	age := time.Since(snap.synced)
+
+	if st.State == ts6.StateReady && age < 2*v.resyncInterval {
+		return ""
+	}
+
+	msg := fmt.Sprintf("Data is %d seconds old", int(age.Seconds()))
+	if st.State != ts6.StateReady && !st.DownSince.IsZero() {
+		msg = unreachableMessage(st.DownSince) + ". " + msg
+	}
+	return msg
+}
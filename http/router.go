@@ -1,30 +1,60 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"html/template"
+	"io"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/sync/singleflight"
+
+	"ts6-viewer/internal/activity"
 	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/metrics"
+	"ts6-viewer/internal/stats"
+	"ts6-viewer/internal/ts6"
 	"ts6-viewer/internal/view"
+	"ts6-viewer/internal/view/bbcode"
+	"ts6-viewer/internal/web"
+	"ts6-viewer/internal/webhook"
 )
 
-var (
-	cacheData      view.VMTS6Viewer
-	cacheTimestamp time.Time
-	cacheTTL       = 5 * time.Second
+// viewer bundles the state of one configured virtual server.
+type viewer struct {
+	name      string
+	basePath  string
+	statsPath string // empty while statistics are disabled
+	cfg       *config.Config
+	watcher   *ts6.Watcher
+	activity  *activity.Feed
+	webhooks  *webhook.Dispatcher
 
-	lastRequestTime = make(map[string]time.Time)
-	rateLimitWindow = 1 * time.Second
+	clientOpts view.ClientOptions // without groups, see clientOptions
+	markup     *bbcode.Options    // descriptions and welcome message
 
-	mu sync.Mutex
-)
+	resyncInterval time.Duration
+	snapshot       atomic.Pointer[viewerSnapshot] // swapped by refreshLoop
+	rebuildMu      sync.Mutex                     // keeps activity diffs in order
+	online         int                            // clients at the last rebuild, -1 before
+	resync         singleflight.Group             // shares forced resyncs
+
+	changedMu sync.Mutex
+	changed   chan struct{} // closed and replaced with every snapshot
+
+	// renderFragment executes the fragment template; see fragment.
+	renderFragment func(w io.Writer, data view.VMTS6Viewer) error
+	fragmentMu     sync.Mutex
+	fragmentCache  renderedFragment
+}
 
 // recoveryMiddleware catches panics in HTTP handlers and returns a 500 error
 // instead of crashing the process.
@@ -40,14 +70,30 @@ func recoveryMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// NewRouter sets up all HTTP routes and returns the router.
-func NewRouter(cfg config.Config) http.Handler {
-	// parse refresh interval
-	if ttl, err := time.ParseDuration(cfg.RefreshInterval + "s"); err == nil {
-		cacheTTL = ttl
-		log.Printf("[HTTP] Cache TTL set to %v\n", cacheTTL)
-	}
+// contentSecurityPolicy allows the pages to load scripts, styles, icons and
+// data from this server only.
+const contentSecurityPolicy = "default-src 'self'; img-src 'self' data:; object-src 'none'; " +
+	"base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
 
+// securityHeadersMiddleware sets headers that restrict what browsers may do
+// with the responses: no external resources, no framing, no MIME sniffing
+// and no referrer leaking to linked sites.
+func securityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewRouter sets up all HTTP routes and returns the router. Background
+// work for the configured servers stops when ctx is done.
+func NewRouter(ctx context.Context, cfg config.Config) http.Handler {
 	refreshIntervalStr := cfg.RefreshInterval
 	refreshInterval, err := strconv.Atoi(refreshIntervalStr)
 	if err != nil || refreshInterval <= 0 {
@@ -57,47 +103,201 @@ func NewRouter(cfg config.Config) http.Handler {
 
 	log.Printf("[HTTP] Refresh interval: %d seconds\n", refreshInterval)
 
-	mux := http.NewServeMux()
+	// Per-client rate limit of the pages and data endpoints
+	rate, err := strconv.ParseFloat(cfg.RateLimit, 64)
+	if err != nil || rate <= 0 {
+		rate = defaultRateLimit
+	}
+	burst, err := strconv.Atoi(cfg.RateLimitBurst)
+	if err != nil || burst < 1 {
+		burst = defaultRateLimitBurst
+	}
+	limiter := newRateLimiter(rate, burst)
+	go limiter.evictLoop(ctx)
 
-	// Load templates
-	wd, err := os.Getwd()
+	log.Printf("[HTTP] Rate limit: %g requests/s per client, burst %d\n", rate, burst)
+
+	// Already validated by config.Load
+	trusted, err := cfg.TrustedProxyPrefixes()
+	if err != nil {
+		log.Fatal("[HTTP] Invalid trusted_proxies:", err)
+	}
+	if len(trusted) > 0 {
+		log.Printf("[HTTP] Trusting forwarding headers from: %v\n", trusted)
+	}
+
+	// Statistics are recorded while a database is configured
+	statsCfg := parseStatsSettings(&cfg)
+	var store *stats.Store
+	if cfg.StatsDB != "" {
+		store, err = stats.Open(cfg.StatsDB, statsCfg.opts)
+		if err != nil {
+			log.Fatal("[HTTP] Cannot open statistics database:", err)
+		}
+		log.Printf("[HTTP] Recording statistics to %s every %s\n", cfg.StatsDB, statsCfg.interval)
+	}
+
+	// Webhooks notified of activity and outages of all servers
+	hooks, err := webhook.New(cfg.Webhooks, nil)
 	if err != nil {
-		log.Fatal("[HTTP] Cannot get working directory:", err)
+		log.Fatal("[HTTP] Invalid webhook:", err)
 	}
+	hooks.Start(ctx)
+	for _, wh := range cfg.Webhooks {
+		log.Printf("[HTTP] Sending %s webhook %q\n", wh.Format, wh.Name)
+	}
+
+	// Icons and, unless disabled, avatars downloaded from the servers
+	files := newFileStore(&cfg)
+	var avatars *avatarStore
+	if cfg.Avatars == "true" {
+		avatars = newAvatarStore(files)
+		log.Println("[HTTP] Showing client avatars")
+	}
+
+	// Images in descriptions and welcome messages, unless shown as links
+	var images *imageProxy
+	if cfg.ImageProxy == "true" {
+		images = newImageProxy()
+		log.Println("[HTTP] Proxying images of descriptions and welcome messages")
+	}
+
+	// One live model per virtual server, resynced in full once per
+	// refresh interval
+	viewers := make(map[string]*viewer)
+	var ordered []*viewer
+
+	for _, sc := range cfg.ServerConfigs() {
+		v := &viewer{
+			name:     sc.Teamspeak6.Name,
+			basePath: "/ts6viewer/" + sc.Teamspeak6.Name,
+			cfg:      sc,
+			watcher:  ts6.NewWatcher(sc, time.Duration(refreshInterval)*time.Second),
+			activity: openActivityFeed(ctx, sc, sc.Teamspeak6.Name),
+			webhooks: hooks,
+
+			clientOpts: view.NewClientOptions(sc),
+
+			resyncInterval: time.Duration(refreshInterval) * time.Second,
+			online:         -1,
+			changed:        make(chan struct{}),
+		}
+		if store != nil {
+			v.statsPath = v.basePath + "/stats"
+		}
+		v.clientOpts.IconPath = v.basePath + "/icon/"
+		if avatars != nil {
+			v.clientOpts.AvatarURL = func(uid string) string { return avatars.url(v, uid) }
+		}
+		v.markup = &bbcode.Options{}
+		if images != nil {
+			v.markup.ImageURL = func(src string) string { return images.url(v, src) }
+		}
+		v.watcher.Start(ctx)
+		go v.refreshLoop(ctx)
+		go hooks.WatchConnection(ctx, v.name, v.watcher, func() string { return serverName(v) })
+
+		viewers[v.name] = v
+		ordered = append(ordered, v)
+		log.Printf("[HTTP] Serving TS6 server %q at %s\n", v.name, v.basePath)
+	}
+
+	if store != nil {
+		startRecorders(ctx, store, ordered, statsCfg.interval)
+	}
+
+	// Unprefixed routes serve the first configured server
+	defaultViewer := ordered[0]
 
-	tmplPath := filepath.Join(wd, "..", "..", "internal", "web", "templates", "ts6viewer.html")
-	tmpl := template.Must(template.ParseFiles(tmplPath))
-	log.Printf("[HTTP] Loaded template: %s\n", tmplPath)
+	// byName resolves the {name} path segment to a configured server.
+	byName := func(handler func(*viewer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			v, ok := viewers[r.PathValue("name")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			handler(v, w, r)
+		}
+	}
+
+	// fixed binds a handler to a single server.
+	fixed := func(v *viewer, handler func(*viewer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handler(v, w, r)
+		}
+	}
+
+	// limited rejects requests over the client's rate limit with 429.
+	limited := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if ok, wait := limiter.allow(getIP(r)); !ok {
+				log.Printf("[HTTP] Rate limit hit for IP: %s\n", getIP(r))
+				writeRateLimited(w, wait)
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	mux := http.NewServeMux()
+
+	// Templates and static assets are embedded; web_dir may override them
+	assets := web.FS(cfg.WebDir)
+	if cfg.WebDir != "" {
+		log.Printf("[HTTP] Web assets overridden from: %s\n", cfg.WebDir)
+	}
+
+	// Load templates
+	tmpl := template.Must(template.ParseFS(assets, "templates/ts6viewer.html"))
+	indexTmpl := template.Must(template.ParseFS(assets, "templates/index.html"))
+	statsTmpl := template.Must(template.ParseFS(assets, "templates/stats.html"))
+	log.Println("[HTTP] Loaded templates")
+
+	// Event streams push the fragment rendered by the page template
+	for _, v := range ordered {
+		v.renderFragment = func(w io.Writer, data view.VMTS6Viewer) error {
+			return tmpl.ExecuteTemplate(w, "fragment", data)
+		}
+	}
 
 	// Static assets
-	staticPath := filepath.Join(wd, "..", "..", "internal", "web", "static")
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticPath))))
-	log.Printf("[HTTP] Static files served from: %s\n", staticPath)
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		log.Fatal("[HTTP] Cannot open static assets:", err)
+	}
+	mime.AddExtensionType(".woff2", "font/woff2") // missing from Go's built-in table
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServerFS(static)))
+
+	// Layout settings from the config, kept out of the pages for the CSP
+	layout := []byte(layoutCSS(&cfg))
+	mux.HandleFunc("/static/layout.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Write(layout)
+	})
 
 	// -----------------------------
 	// JSON data endpoint
 	// -----------------------------
-	dataHandler := func(w http.ResponseWriter, r *http.Request) {
+	// requestViewerData serves the data and fragment endpoints from the
+	// snapshot. Only a forced request goes to the TS6 server.
+	requestViewerData := func(v *viewer, r *http.Request) (view.VMTS6Viewer, error) {
 		ip := getIP(r)
-		log.Printf("[HTTP] /ts6viewer/data requested from IP: %s\n", ip)
+		log.Printf("[HTTP] %s requested from IP: %s\n", r.URL.Path, ip)
 
-		var data view.VMTS6Viewer
-		var err error
 		force := r.URL.Query().Get("force") == "1"
 		if force {
 			log.Printf("[HTTP] Force refresh requested by IP: %s\n", ip)
 		}
 
-		if allowRequest(ip) {
-			data, err = getViewerData(&cfg, force)
-		} else {
-			log.Printf("[HTTP] Rate limit hit for IP: %s\n", ip)
-			data = cacheData
-		}
+		return v.viewerData(r.Context(), force)
+	}
 
+	dataHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		data, err := requestViewerData(v, r)
 		if err != nil {
 			log.Printf("[HTTP] Error getting viewer data: %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeQueryError(w, err)
 			return
 		}
 
@@ -107,46 +307,291 @@ func NewRouter(cfg config.Config) http.Handler {
 		}
 	}
 
-	mux.HandleFunc("/ts6viewer/data", dataHandler)
-	mux.HandleFunc("/ts6viewer/data/", dataHandler)
+	mux.HandleFunc("/ts6viewer/data", limited(fixed(defaultViewer, dataHandler)))
+	mux.HandleFunc("/ts6viewer/data/{$}", limited(fixed(defaultViewer, dataHandler)))
+	mux.HandleFunc("/ts6viewer/{name}/data", limited(byName(dataHandler)))
+
+	// -----------------------------
+	// HTML fragment endpoint
+	// -----------------------------
+	// Server info and channel tree rendered by the page template, so that
+	// the browser never builds markup from names chosen by clients.
+	fragmentHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		data, err := requestViewerData(v, r)
+		if err != nil {
+			log.Printf("[HTTP] Error getting viewer data: %v\n", err)
+			writeQueryError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.ExecuteTemplate(w, "fragment", data); err != nil {
+			log.Printf("[HTTP] Template execution error: %v\n", err)
+		}
+	}
+
+	mux.HandleFunc("/ts6viewer/fragment", limited(fixed(defaultViewer, fragmentHandler)))
+	mux.HandleFunc("/ts6viewer/fragment/{$}", limited(fixed(defaultViewer, fragmentHandler)))
+	mux.HandleFunc("/ts6viewer/{name}/fragment", limited(byName(fragmentHandler)))
+
+	// -----------------------------
+	// Server-Sent Events stream
+	// -----------------------------
+	eventsHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		ip := getIP(r)
+		log.Printf("[HTTP] %s stream opened by IP: %s\n", r.URL.Path, ip)
+
+		// Not rate limited: a stream is a single long-lived request that
+		// pushes the fragment, so the page does not request it on changes.
+		serveEvents(w, r, v)
+		log.Printf("[HTTP] %s stream closed for IP: %s\n", r.URL.Path, ip)
+	}
+
+	mux.HandleFunc("/ts6viewer/events", fixed(defaultViewer, eventsHandler))
+	mux.HandleFunc("/ts6viewer/{name}/events", byName(eventsHandler))
 
 	// -----------------------------
 	// HTML view endpoint
 	// -----------------------------
-	viewHandler := func(w http.ResponseWriter, r *http.Request) {
+	viewHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
 		ip := getIP(r)
-		log.Printf("[HTTP] /ts6viewer requested from IP: %s\n", ip)
+		log.Printf("[HTTP] %s requested from IP: %s\n", r.URL.Path, ip)
 
-		var data view.VMTS6Viewer
-		var err error
-		if allowRequest(ip) {
-			data, err = getViewerData(&cfg, true)
-		} else {
-			log.Printf("[HTTP] Rate limit hit for IP: %s\n", ip)
-			data = cacheData
-		}
+		data, err := v.viewerData(r.Context(), false)
 
-		if err != nil {
+		// Before the first sync, an unreachable TS6 server gets a page
+		// with a notice instead of an error page
+		status := http.StatusOK
+		var ue *ts6.UnavailableError
+		switch {
+		case errors.As(err, &ue):
+			log.Printf("[HTTP] Error getting viewer data: %v\n", err)
+			data = unavailableViewerData(v, unreachableMessage(ue.Since))
+			status = http.StatusServiceUnavailable
+		case err != nil:
 			log.Printf("[HTTP] Error getting viewer data: %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeQueryError(w, err)
 			return
 		}
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
 		if err := tmpl.Execute(w, data); err != nil {
 			log.Printf("[HTTP] Template execution error: %v\n", err)
 		}
 	}
 
-	mux.HandleFunc("/ts6viewer", viewHandler)
-	mux.HandleFunc("/ts6viewer/", viewHandler)
+	mux.HandleFunc("/ts6viewer/{name}", limited(byName(viewHandler)))
+	mux.HandleFunc("/ts6viewer/{name}/{$}", limited(byName(viewHandler)))
+
+	// -----------------------------
+	// Server index
+	// -----------------------------
+	// With a single server, /ts6viewer shows it directly as before.
+	indexHandler := func(w http.ResponseWriter, r *http.Request) {
+		if len(ordered) == 1 {
+			viewHandler(defaultViewer, w, r)
+			return
+		}
+
+		log.Printf("[HTTP] /ts6viewer index requested from IP: %s\n", getIP(r))
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := indexTmpl.Execute(w, buildIndexData(&cfg, ordered)); err != nil {
+			log.Printf("[HTTP] Template execution error: %v\n", err)
+		}
+	}
+
+	mux.HandleFunc("/ts6viewer", limited(indexHandler))
+	mux.HandleFunc("/ts6viewer/{$}", limited(indexHandler))
+
+	// -----------------------------
+	// Activity feed
+	// -----------------------------
+	// Joins, leaves, moves and away and mute changes as JSON, paged with
+	// ?since= set to the "next" value of the previous page or a time.
+	activityHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		log.Printf("[HTTP] %s requested from IP: %s\n", r.URL.Path, getIP(r))
+
+		page, ok := activitySince(v.activity, r.URL.Query().Get("since"))
+		if !ok {
+			http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			log.Printf("[HTTP] Error encoding JSON response: %v\n", err)
+		}
+	}
+
+	mux.HandleFunc("/ts6viewer/activity", limited(fixed(defaultViewer, activityHandler)))
+	mux.HandleFunc("/ts6viewer/{name}/activity", limited(byName(activityHandler)))
+
+	// -----------------------------
+	// Statistics
+	// -----------------------------
+	// Occupancy of the last ?days=N days as a page with charts and as JSON.
+	// The literal /ts6viewer/stats routes take precedence over {name}.
+	statsReport := func(v *viewer, w http.ResponseWriter, r *http.Request) (*stats.Report, int, bool) {
+		log.Printf("[HTTP] %s requested from IP: %s\n", r.URL.Path, getIP(r))
+
+		if store == nil {
+			http.Error(w, "Statistics are disabled", http.StatusNotFound)
+			return nil, 0, false
+		}
+
+		days := statsDays(r.URL.Query().Get("days"), statsCfg.retentionDays)
+		now := time.Now()
+		report, err := store.Report(v.name, now.AddDate(0, 0, -days), now)
+		if err != nil {
+			log.Printf("[STATS] Error building report: %v\n", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return nil, 0, false
+		}
+		return report, days, true
+	}
+
+	statsHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		report, days, ok := statsReport(v, w, r)
+		if !ok {
+			return
+		}
+
+		data := view.BuildVMStats(report, cfg.Theme, v.basePath, serverName(v), days)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statsTmpl.Execute(w, data); err != nil {
+			log.Printf("[HTTP] Template execution error: %v\n", err)
+		}
+	}
+
+	statsDataHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		report, _, ok := statsReport(v, w, r)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("[HTTP] Error encoding JSON response: %v\n", err)
+		}
+	}
+
+	mux.HandleFunc("/ts6viewer/stats", limited(fixed(defaultViewer, statsHandler)))
+	mux.HandleFunc("/ts6viewer/stats/data", limited(fixed(defaultViewer, statsDataHandler)))
+	mux.HandleFunc("/ts6viewer/{name}/stats", limited(byName(statsHandler)))
+	mux.HandleFunc("/ts6viewer/{name}/stats/data", limited(byName(statsDataHandler)))
+
+	// -----------------------------
+	// Channel descriptions
+	// -----------------------------
+	// Loaded when a channel panel is expanded, converted from BBCode and
+	// rendered by the page template, like the fragment.
+	descriptions := newDescriptionCache()
+
+	channelHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		log.Printf("[HTTP] %s requested from IP: %s\n", r.URL.Path, getIP(r))
+
+		cid, err := strconv.Atoi(r.PathValue("cid"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		description, err := descriptions.get(r.Context(), v, cid)
+		switch {
+		case errors.Is(err, ts6.ErrInvalidChannelID):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			log.Printf("[HTTP] Error getting channel description: %v\n", err)
+			writeQueryError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.ExecuteTemplate(w, "channel-description", bbcode.Render(description, v.markup)); err != nil {
+			log.Printf("[HTTP] Template execution error: %v\n", err)
+		}
+	}
+
+	mux.HandleFunc("/ts6viewer/{name}/channel/{cid}", limited(byName(channelHandler)))
+
+	// -----------------------------
+	// Icons
+	// -----------------------------
+	// Channel, client and group icons from the server's file storage. Not
+	// rate limited: a page shows many icons, which are cached here and by
+	// the browser. A literal /ts6viewer/icon/{id} route would collide with
+	// /ts6viewer/{name}/data, so icons are always served per server.
+	iconHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		serveIcon(files, v, w, r)
+	}
+
+	mux.HandleFunc("/ts6viewer/{name}/icon/{id}", byName(iconHandler))
+
+	// -----------------------------
+	// Avatars
+	// -----------------------------
+	// Thumbnails of the avatars of online clients, by unique identifier.
+	// Like icons, served per server and not rate limited.
+	avatarHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		serveAvatar(avatars, v, w, r)
+	}
+
+	mux.HandleFunc("/ts6viewer/{name}/avatar/{uid}", byName(avatarHandler))
+
+	// -----------------------------
+	// Proxied images
+	// -----------------------------
+	// Images of channel descriptions and welcome messages, by signed URL.
+	// Rate limited, since they are fetched from other hosts.
+	imageHandler := func(v *viewer, w http.ResponseWriter, r *http.Request) {
+		images.serve(w, r)
+	}
+
+	mux.HandleFunc("/ts6viewer/{name}/image", limited(byName(imageHandler)))
 
 	// -----------------------------
 	// Health check
 	// -----------------------------
+	// Reports the connection state of every server; 503 while any of them
+	// is unreachable.
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[HTTP] /health check from IP: %s\n", getIP(r))
-		w.WriteHeader(http.StatusOK)
+
+		health := struct {
+			Status  string         `json:"status"`
+			Servers []serverStatus `json:"servers"`
+		}{Status: "ok"}
+
+		for _, v := range ordered {
+			s, ready := buildServerStatus(v)
+			if !ready {
+				health.Status = "degraded"
+			}
+			health.Servers = append(health.Servers, s)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if health.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(health); err != nil {
+			log.Printf("[HTTP] Error encoding JSON response: %v\n", err)
+		}
+	})
+
+	// -----------------------------
+	// Prometheus metrics
+	// -----------------------------
+	collectViewerMetrics(ctx, ordered)
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := metrics.Default.WriteTo(w); err != nil {
+			log.Printf("[HTTP] Error writing metrics: %v\n", err)
+		}
 	})
 
 	// -----------------------------
@@ -158,5 +603,5 @@ func NewRouter(cfg config.Config) http.Handler {
 		w.Write([]byte("TS6Viewer is running!"))
 	})
 
-	return recoveryMiddleware(mux)
+	return recoveryMiddleware(securityHeadersMiddleware(clientIPMiddleware(trusted, metricsMiddleware(mux))))
 }
@@ -0,0 +1,321 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/ts6/ts6test"
+	"ts6-viewer/internal/view"
+)
+
+// newTestRouter starts a fake ServerQuery server and a router serving it.
+// configure may adjust the configuration before the router is built.
+func newTestRouter(t *testing.T, configure func(*config.Config)) (*ts6test.Server, http.Handler) {
+	t.Helper()
+
+	srv, err := ts6test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	cfg := config.Config{
+		Teamspeak6:      srv.Teamspeak6(),
+		RefreshInterval: "60",
+		Theme:           "dark",
+		RateLimit:       "100",
+		RateLimitBurst:  "100",
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return srv, NewRouter(ctx, cfg)
+}
+
+// get requests path from ip.
+func get(h http.Handler, path, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = net.JoinHostPort(ip, "40000")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouterStatusCodes(t *testing.T) {
+	_, h := newTestRouter(t, nil)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/ts6viewer", http.StatusOK},
+		{"/ts6viewer/default", http.StatusOK},
+		{"/ts6viewer/data", http.StatusOK},
+		{"/ts6viewer/default/data", http.StatusOK},
+		{"/ts6viewer/default/fragment", http.StatusOK},
+		{"/ts6viewer/default/activity", http.StatusOK},
+		{"/ts6viewer/default/channel/1", http.StatusOK},
+		{"/health", http.StatusOK},
+		{"/ts6viewer/unknown/data", http.StatusNotFound},
+		{"/ts6viewer/default/channel/99", http.StatusNotFound},
+		{"/ts6viewer/default/channel/x", http.StatusNotFound},
+		{"/ts6viewer/default/activity?since=yesterday", http.StatusBadRequest},
+		{"/ts6viewer/default/stats", http.StatusNotFound},
+		{"/ts6viewer/default/avatar/YWxpY2UtdWlkLWZvci10ZXN0cz0=", http.StatusNotFound},
+		{"/ts6viewer/default/image?url=http://example.com/a.png&sig=x", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := get(h, tt.path, "192.0.2.1")
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d: %s", tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestRouterPage(t *testing.T) {
+	_, h := newTestRouter(t, nil)
+
+	rec := get(h, "/ts6viewer/default", "192.0.2.1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != contentSecurityPolicy {
+		t.Errorf("Content-Security-Policy = %q", csp)
+	}
+	if got := rec.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("X-Frame-Options = %q", got)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{"Test Server", "Lobby", "Gaming", "Alice", "Welcome to <b>Test Server</b>!"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+	if strings.Contains(body, "serveradmin") {
+		t.Error("page shows the ServerQuery client")
+	}
+}
+
+func TestRouterFragment(t *testing.T) {
+	_, h := newTestRouter(t, nil)
+
+	rec := get(h, "/ts6viewer/default/fragment", "192.0.2.1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{`id="server-status"`, `class="server-info"`, `id="channels"`, `id="activity-list"`, "Alice"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("fragment does not contain %q", want)
+		}
+	}
+	if strings.Contains(body, "<html") {
+		t.Error("fragment contains the page layout")
+	}
+}
+
+func TestRouterFragmentEscapesNames(t *testing.T) {
+	srv, h := newTestRouter(t, nil)
+
+	srv.SetResponse("clientlist", `clid=5 cid=1 client_database_id=3 client_nickname=<img\ssrc=x\sonerror=alert(1)> client_type=0`)
+	srv.SetResponse("channellist", `cid=1 pid=0 channel_order=0 channel_name=<script>alert(2)<\/script> total_clients=1`)
+
+	body := get(h, "/ts6viewer/default/fragment", "192.0.2.1").Body.String()
+
+	if strings.Contains(body, "<img src=x") || strings.Contains(body, "<script>alert") {
+		t.Fatalf("fragment contains unescaped names:\n%s", body)
+	}
+	for _, want := range []string{"&lt;img src=x onerror=alert(1)&gt;", "&lt;script&gt;alert(2)&lt;/script&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("fragment does not contain %q", want)
+		}
+	}
+}
+
+func TestRouterData(t *testing.T) {
+	_, h := newTestRouter(t, nil)
+
+	rec := get(h, "/ts6viewer/default/data", "192.0.2.1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	var data view.VMTS6Viewer
+	if err := json.NewDecoder(rec.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.VMServer == nil || data.VMServer.Name != "Test Server" {
+		t.Fatalf("server = %+v", data.VMServer)
+	}
+	if data.VMServer.ClientsOnline != "1" {
+		t.Errorf("clients online = %q, want 1", data.VMServer.ClientsOnline)
+	}
+	if len(data.VMChannels) == 0 || data.VMChannels[0].Name != "Lobby" {
+		t.Errorf("channels = %+v", data.VMChannels)
+	}
+}
+
+func TestRouterChannelDescription(t *testing.T) {
+	_, h := newTestRouter(t, nil)
+
+	// Panels are opened on a loaded page
+	get(h, "/ts6viewer/default", "192.0.2.1")
+
+	body := get(h, "/ts6viewer/default/channel/1", "192.0.2.1").Body.String()
+
+	want := `<div class="channel-description">Welcome to the <b>Lobby</b>.<br>Please be nice.</div>`
+	if !strings.Contains(body, want) {
+		t.Errorf("description = %q, want %q", body, want)
+	}
+}
+
+func TestRouterRateLimit(t *testing.T) {
+	_, h := newTestRouter(t, func(cfg *config.Config) {
+		cfg.RateLimit = "0.001"
+		cfg.RateLimitBurst = "2"
+	})
+
+	for i := range 2 {
+		if rec := get(h, "/ts6viewer/default/fragment", "192.0.2.1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200", i+1, rec.Code)
+		}
+	}
+
+	rec := get(h, "/ts6viewer/default/fragment", "192.0.2.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+
+	// Other clients and the unlimited routes are not affected
+	if rec := get(h, "/ts6viewer/default/fragment", "192.0.2.2"); rec.Code != http.StatusOK {
+		t.Errorf("other client = %d, want 200", rec.Code)
+	}
+	if rec := get(h, "/health", "192.0.2.1"); rec.Code != http.StatusOK {
+		t.Errorf("/health = %d, want 200", rec.Code)
+	}
+}
+
+func TestRouterUnavailable(t *testing.T) {
+	_, h := newTestRouter(t, func(cfg *config.Config) {
+		// A port nothing listens on
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, cfg.Teamspeak6.Port, _ = net.SplitHostPort(ln.Addr().String())
+		ln.Close()
+	})
+
+	for _, path := range []string{"/ts6viewer/default/data", "/ts6viewer/default/fragment", "/ts6viewer/default", "/health"} {
+		if rec := get(h, path, "192.0.2.1"); rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s = %d, want 503: %s", path, rec.Code, rec.Body)
+		}
+	}
+
+	body := get(h, "/ts6viewer/default", "192.0.2.1").Body.String()
+	if !strings.Contains(body, "unreachable") {
+		t.Errorf("page does not mention the unreachable server:\n%s", body)
+	}
+}
+
+// readEvent reads the next event from an event stream, skipping comments.
+func readEvent(r *bufio.Reader) (name, data string, err error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && name != "":
+			return name, strings.Join(lines, "\n"), nil
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			lines = append(lines, strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+func TestRouterEvents(t *testing.T) {
+	srv, h := newTestRouter(t, func(cfg *config.Config) {
+		// Streams are not limited; the fragment must not be fetched either
+		cfg.RateLimit = "0.001"
+		cfg.RateLimitBurst = "1"
+	})
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// Load the page so that the model is synced
+	if rec := get(h, "/ts6viewer/default", "127.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("page = %d", rec.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/ts6viewer/default/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	name, data, err := readEvent(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "fragment" || !strings.Contains(data, `id="channels"`) || !strings.Contains(data, "Alice") {
+		t.Fatalf("first event %q = %q, want the fragment", name, data)
+	}
+	if strings.Contains(data, "<html") {
+		t.Error("event contains the page layout")
+	}
+
+	// The event connection may register after the stream opened
+	go func() {
+		for ctx.Err() == nil {
+			srv.Notify(`notifycliententerview cfid=0 ctid=1 reasonid=0 clid=9 client_database_id=9 client_nickname=Bob client_type=0`)
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	name, data, err = readEvent(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "fragment" || !strings.Contains(data, "Bob") {
+		t.Fatalf("event %q = %q, want the fragment with Bob", name, data)
+	}
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// sseKeepAliveInterval is how often a comment line is written to idle
+// streams so that proxies do not close them.
+const sseKeepAliveInterval = 25 * time.Second
+
+// sseCoalesceDelay is how long a stream waits after the snapshot changed
+// before sending it, so that a burst of changes becomes a single event.
+const sseCoalesceDelay = 100 * time.Millisecond
+
+// serveEvents streams the server info, channel tree and activity as
+// Server-Sent Events. Each "fragment" event carries the markup of the
+// fragment endpoint, rendered once per snapshot for all streams. It is sent
+// on connect and whenever the snapshot changes, including the resync that
+// ages it, so the browser never has to fetch the fragment itself.
+func serveEvents(w http.ResponseWriter, r *http.Request, v *viewer) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if err := rc.Flush(); err != nil {
+		log.Printf("[HTTP] SSE streaming not supported: %v\n", err)
+		return
+	}
+
+	// Subscribed before the first event, so no change is missed
+	changed := v.snapshotChanged()
+
+	var sent []byte
+	send := func() error {
+		html, ok := v.fragment()
+		if !ok || bytes.Equal(html, sent) {
+			// Not synced yet, or the change did not affect the fragment
+			return nil
+		}
+		sent = html
+
+		if err := writeEvent(w, "fragment", html); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send(); err != nil {
+		log.Printf("[HTTP] SSE write failed: %v\n", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	var pending <-chan time.Time
+
+	for {
+		var err error
+
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-changed:
+			changed = v.snapshotChanged()
+			if pending == nil {
+				pending = time.After(sseCoalesceDelay)
+			}
+
+		case <-pending:
+			pending = nil
+			err = send()
+
+		case <-keepAlive.C:
+			if _, err = fmt.Fprint(w, ": keepalive\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		}
+
+		if err != nil {
+			log.Printf("[HTTP] SSE write failed: %v\n", err)
+			return
+		}
+	}
+}
+
+// writeEvent writes data as one event. Every line of data becomes a data
+// field; the browser joins them with newlines again.
+func writeEvent(w io.Writer, name string, data []byte) error {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "event: %s\n", name)
+	for line := range bytes.Lines(data) {
+		b.WriteString("data: ")
+		b.Write(bytes.TrimSuffix(line, []byte("\n")))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/stats"
+)
+
+// Defaults for the statistics settings.
+const (
+	defaultStatsSampleInterval = 60 * time.Second
+	defaultStatsRawRetention   = 7   // days
+	defaultStatsRetention      = 365 // days
+	defaultStatsDays           = 30
+)
+
+// statsSettings are the parsed statistics settings of the config.
+type statsSettings struct {
+	interval      time.Duration
+	retentionDays int
+	opts          stats.Options
+}
+
+func parseStatsSettings(cfg *config.Config) statsSettings {
+	interval := defaultStatsSampleInterval
+	if secs, err := strconv.Atoi(cfg.StatsSampleInterval); err == nil && secs > 0 {
+		interval = time.Duration(secs) * time.Second
+	}
+
+	raw, err := strconv.Atoi(cfg.StatsRawRetention)
+	if err != nil || raw <= 0 {
+		raw = defaultStatsRawRetention
+	}
+	retention, err := strconv.Atoi(cfg.StatsRetention)
+	if err != nil || retention <= 0 {
+		retention = defaultStatsRetention
+	}
+	raw = min(raw, retention)
+
+	const day = 24 * time.Hour
+	return statsSettings{
+		interval:      interval,
+		retentionDays: retention,
+		opts: stats.Options{
+			RawRetention: time.Duration(raw) * day,
+			Retention:    time.Duration(retention) * day,
+		},
+	}
+}
+
+// startRecorders samples every server into store until ctx is done and
+// closes the store once all recorders have stopped.
+func startRecorders(ctx context.Context, store *stats.Store, viewers []*viewer, interval time.Duration) {
+	var wg sync.WaitGroup
+	for _, v := range viewers {
+		rec := stats.NewRecorder(store, v.name, v.watcher.Model(), interval)
+		wg.Go(func() { rec.Run(ctx) })
+	}
+
+	go func() {
+		<-ctx.Done()
+		wg.Wait()
+		if err := store.Close(); err != nil {
+			log.Printf("[STATS] Closing store failed: %v\n", err)
+		}
+	}()
+}
+
+// statsDays parses the days query parameter, falling back to the default
+// for values that are not offered and capping it at the retention.
+func statsDays(query string, retentionDays int) int {
+	days, err := strconv.Atoi(query)
+	if err != nil || days <= 0 {
+		days = defaultStatsDays
+	}
+	return min(days, retentionDays)
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/config"
+)
+
+func TestStatsDays(t *testing.T) {
+	tests := []struct {
+		query     string
+		retention int
+		want      int
+	}{
+		{"7", 365, 7},
+		{"90", 365, 90},
+		{"", 365, defaultStatsDays},
+		{"0", 365, defaultStatsDays},
+		{"-7", 365, defaultStatsDays},
+		{"7d", 365, defaultStatsDays},
+		{"1e3", 365, defaultStatsDays},
+		{"9999999999999999999999", 365, defaultStatsDays},
+		{"400", 365, 365},
+		{"", 14, 14},
+	}
+
+	for _, tt := range tests {
+		if got := statsDays(tt.query, tt.retention); got != tt.want {
+			t.Errorf("statsDays(%q, %d) = %d, want %d", tt.query, tt.retention, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatsSettings(t *testing.T) {
+	const day = 24 * time.Hour
+
+	tests := []struct {
+		name                     string
+		interval, raw, retention string
+		wantInterval             time.Duration
+		wantRaw, wantRetention   int // days
+	}{
+		{"defaults", "", "", "", defaultStatsSampleInterval, defaultStatsRawRetention, defaultStatsRetention},
+		{"configured", "30", "3", "90", 30 * time.Second, 3, 90},
+		{"invalid", "fast", "-1", "0", defaultStatsSampleInterval, defaultStatsRawRetention, defaultStatsRetention},
+		{"zero interval", "0", "", "", defaultStatsSampleInterval, defaultStatsRawRetention, defaultStatsRetention},
+		// Raw samples are never kept longer than the aggregates
+		{"raw above retention", "", "30", "10", defaultStatsSampleInterval, 10, 10},
+		{"default raw above retention", "", "", "3", defaultStatsSampleInterval, 3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := parseStatsSettings(&config.Config{
+				StatsSampleInterval: tt.interval,
+				StatsRawRetention:   tt.raw,
+				StatsRetention:      tt.retention,
+			})
+
+			if s.interval != tt.wantInterval {
+				t.Errorf("interval = %v, want %v", s.interval, tt.wantInterval)
+			}
+			if s.opts.RawRetention != time.Duration(tt.wantRaw)*day {
+				t.Errorf("raw retention = %v, want %d days", s.opts.RawRetention, tt.wantRaw)
+			}
+			if s.opts.Retention != time.Duration(tt.wantRetention)*day || s.retentionDays != tt.wantRetention {
+				t.Errorf("retention = %v (%d days), want %d days", s.opts.Retention, s.retentionDays, tt.wantRetention)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package activity turns consecutive snapshots of the clients of a server
+// into a feed of join, leave, move, away and mute events.
+package activity
+
+import (
+	"time"
+
+	"ts6-viewer/internal/ts6"
+)
+
+// Kind names what happened to a client.
+type Kind string
+
+const (
+	Joined  Kind = "joined"
+	Left    Kind = "left"
+	Moved   Kind = "moved"
+	Away    Kind = "away"
+	Back    Kind = "back"
+	Muted   Kind = "muted"
+	Unmuted Kind = "unmuted"
+)
+
+// Event is a single change of a client. Channel is the channel the client
+// is in after the event, FromChannel the one it left when it moved.
+type Event struct {
+	ID          uint64    `json:"id"`
+	Time        time.Time `json:"time"`
+	Kind        Kind      `json:"kind"`
+	CLID        int       `json:"clid"`
+	UID         string    `json:"uid,omitempty"`
+	Nickname    string    `json:"nickname"`
+	CID         int       `json:"cid"`
+	Channel     string    `json:"channel,omitempty"`
+	FromCID     int       `json:"from_cid,omitempty"`
+	FromChannel string    `json:"from_channel,omitempty"`
+	Message     string    `json:"message,omitempty"` // away message
+
+	ServerGroups []int `json:"server_groups,omitempty"`
+}
+
+// Diff returns the events that turn prev into next, both sorted by CLID.
+// ServerQuery clients are ignored. channelNames resolves channel IDs to the
+// names stored in the events. Events get their ID when added to a Feed.
+func Diff(prev, next []ts6.Client, channelNames map[int]string, at time.Time) []Event {
+	before := make(map[int]ts6.Client, len(prev))
+	for _, cl := range prev {
+		if cl.Type == 0 {
+			before[cl.CLID] = cl
+		}
+	}
+
+	event := func(kind Kind, cl ts6.Client) Event {
+		return Event{
+			Time:     at,
+			Kind:     kind,
+			CLID:     cl.CLID,
+			UID:      cl.UniqueIdentifier,
+			Nickname: cl.Nickname,
+			CID:      cl.CID,
+			Channel:  channelNames[cl.CID],
+
+			ServerGroups: cl.ServerGroups,
+		}
+	}
+
+	var events []Event
+	after := make(map[int]bool, len(next))
+
+	for _, cl := range next {
+		if cl.Type != 0 {
+			continue
+		}
+		after[cl.CLID] = true
+
+		old, ok := before[cl.CLID]
+		if !ok {
+			events = append(events, event(Joined, cl))
+			continue
+		}
+
+		if old.CID != cl.CID {
+			e := event(Moved, cl)
+			e.FromCID = old.CID
+			e.FromChannel = channelNames[old.CID]
+			events = append(events, e)
+		}
+
+		switch {
+		case cl.Away && !old.Away:
+			e := event(Away, cl)
+			e.Message = cl.AwayMessage
+			events = append(events, e)
+		case !cl.Away && old.Away:
+			events = append(events, event(Back, cl))
+		}
+
+		switch muted := isMuted(cl); {
+		case muted && !isMuted(old):
+			events = append(events, event(Muted, cl))
+		case !muted && isMuted(old):
+			events = append(events, event(Unmuted, cl))
+		}
+	}
+
+	for _, cl := range prev {
+		if _, ok := before[cl.CLID]; ok && !after[cl.CLID] {
+			events = append(events, event(Left, cl))
+		}
+	}
+
+	return events
+}
+
+// isMuted reports whether the microphone or the speakers of cl are muted.
+func isMuted(cl ts6.Client) bool {
+	return cl.InputMuted || cl.OutputMuted
+}
+
+// Detail describes the event as it reads after the nickname of the client,
+// e.g. "moved from Lobby to Gaming".
+func (e Event) Detail() string {
+	switch e.Kind {
+	case Joined:
+		if e.Channel != "" {
+			return "joined " + e.Channel
+		}
+		return "joined"
+	case Left:
+		return "left"
+	case Moved:
+		switch {
+		case e.FromChannel != "" && e.Channel != "":
+			return "moved from " + e.FromChannel + " to " + e.Channel
+		case e.Channel != "":
+			return "moved to " + e.Channel
+		}
+		return "switched channels"
+	case Away:
+		if e.Message != "" {
+			return "went away (" + e.Message + ")"
+		}
+		return "went away"
+	case Back:
+		return "is back"
+	case Muted:
+		return "muted"
+	case Unmuted:
+		return "unmuted"
+	}
+	return string(e.Kind)
+}
@@ -0,0 +1,133 @@
+package activity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/ts6"
+)
+
+func TestDiff(t *testing.T) {
+	names := map[int]string{1: "Lobby", 2: "Gaming"}
+	at := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	alice := ts6.Client{CLID: 5, CID: 1, Nickname: "Alice", UniqueIdentifier: "alice", ServerGroups: []int{6}}
+	bob := ts6.Client{CLID: 6, CID: 2, Nickname: "Bob", UniqueIdentifier: "bob"}
+	query := ts6.Client{CLID: 1, CID: 1, Nickname: "serveradmin", Type: 1}
+
+	with := func(cl ts6.Client, change func(*ts6.Client)) ts6.Client {
+		change(&cl)
+		return cl
+	}
+
+	tests := []struct {
+		name       string
+		prev, next []ts6.Client
+		want       []Event
+	}{
+		{"unchanged", []ts6.Client{alice, bob}, []ts6.Client{alice, bob}, nil},
+		{
+			"join", []ts6.Client{alice}, []ts6.Client{alice, bob},
+			[]Event{{Kind: Joined, CLID: 6, UID: "bob", Nickname: "Bob", CID: 2, Channel: "Gaming"}},
+		},
+		{
+			"leave", []ts6.Client{alice, bob}, []ts6.Client{bob},
+			[]Event{{Kind: Left, CLID: 5, UID: "alice", Nickname: "Alice", CID: 1, Channel: "Lobby", ServerGroups: []int{6}}},
+		},
+		{
+			"move", []ts6.Client{alice}, []ts6.Client{with(alice, func(cl *ts6.Client) { cl.CID = 2 })},
+			[]Event{{Kind: Moved, CLID: 5, UID: "alice", Nickname: "Alice", CID: 2, Channel: "Gaming", FromCID: 1, FromChannel: "Lobby", ServerGroups: []int{6}}},
+		},
+		{
+			"move to unknown channel", []ts6.Client{bob}, []ts6.Client{with(bob, func(cl *ts6.Client) { cl.CID = 9 })},
+			[]Event{{Kind: Moved, CLID: 6, UID: "bob", Nickname: "Bob", CID: 9, FromCID: 2, FromChannel: "Gaming"}},
+		},
+		{
+			"away", []ts6.Client{bob}, []ts6.Client{with(bob, func(cl *ts6.Client) { cl.Away, cl.AwayMessage = true, "brb" })},
+			[]Event{{Kind: Away, CLID: 6, UID: "bob", Nickname: "Bob", CID: 2, Channel: "Gaming", Message: "brb"}},
+		},
+		{
+			"back", []ts6.Client{with(bob, func(cl *ts6.Client) { cl.Away = true })}, []ts6.Client{bob},
+			[]Event{{Kind: Back, CLID: 6, UID: "bob", Nickname: "Bob", CID: 2, Channel: "Gaming"}},
+		},
+		{
+			"mute microphone", []ts6.Client{bob}, []ts6.Client{with(bob, func(cl *ts6.Client) { cl.InputMuted = true })},
+			[]Event{{Kind: Muted, CLID: 6, UID: "bob", Nickname: "Bob", CID: 2, Channel: "Gaming"}},
+		},
+		{
+			"mute speakers", []ts6.Client{bob}, []ts6.Client{with(bob, func(cl *ts6.Client) { cl.OutputMuted = true })},
+			[]Event{{Kind: Muted, CLID: 6, UID: "bob", Nickname: "Bob", CID: 2, Channel: "Gaming"}},
+		},
+		{
+			// Still muted while either is
+			"unmute one of two", []ts6.Client{with(bob, func(cl *ts6.Client) { cl.InputMuted, cl.OutputMuted = true, true })},
+			[]ts6.Client{with(bob, func(cl *ts6.Client) { cl.InputMuted = true })}, nil,
+		},
+		{
+			"unmute", []ts6.Client{with(bob, func(cl *ts6.Client) { cl.InputMuted = true })}, []ts6.Client{bob},
+			[]Event{{Kind: Unmuted, CLID: 6, UID: "bob", Nickname: "Bob", CID: 2, Channel: "Gaming"}},
+		},
+		{
+			"move, away and mute at once", []ts6.Client{bob},
+			[]ts6.Client{with(bob, func(cl *ts6.Client) { cl.CID, cl.Away, cl.InputMuted = 1, true, true })},
+			[]Event{
+				{Kind: Moved, CLID: 6, UID: "bob", Nickname: "Bob", CID: 1, Channel: "Lobby", FromCID: 2, FromChannel: "Gaming"},
+				{Kind: Away, CLID: 6, UID: "bob", Nickname: "Bob", CID: 1, Channel: "Lobby"},
+				{Kind: Muted, CLID: 6, UID: "bob", Nickname: "Bob", CID: 1, Channel: "Lobby"},
+			},
+		},
+		{
+			// A reconnect gets a new CLID
+			"reconnect", []ts6.Client{bob}, []ts6.Client{with(bob, func(cl *ts6.Client) { cl.CLID = 7 })},
+			[]Event{
+				{Kind: Joined, CLID: 7, UID: "bob", Nickname: "Bob", CID: 2, Channel: "Gaming"},
+				{Kind: Left, CLID: 6, UID: "bob", Nickname: "Bob", CID: 2, Channel: "Gaming"},
+			},
+		},
+		{"query client joins", nil, []ts6.Client{query}, nil},
+		{"query client leaves", []ts6.Client{query, alice}, []ts6.Client{alice}, nil},
+		{"query client moves", []ts6.Client{query}, []ts6.Client{with(query, func(cl *ts6.Client) { cl.CID = 2 })}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(tt.prev, tt.next, names, at)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				want.Time = at
+				if !reflect.DeepEqual(got[i], want) {
+					t.Errorf("event %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDetail(t *testing.T) {
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{Event{Kind: Joined, Channel: "Lobby"}, "joined Lobby"},
+		{Event{Kind: Joined}, "joined"},
+		{Event{Kind: Left, Channel: "Lobby"}, "left"},
+		{Event{Kind: Moved, FromChannel: "Lobby", Channel: "Gaming"}, "moved from Lobby to Gaming"},
+		{Event{Kind: Moved, Channel: "Gaming"}, "moved to Gaming"},
+		{Event{Kind: Moved}, "switched channels"},
+		{Event{Kind: Away, Message: "brb"}, "went away (brb)"},
+		{Event{Kind: Away}, "went away"},
+		{Event{Kind: Back}, "is back"},
+		{Event{Kind: Muted}, "muted"},
+		{Event{Kind: Unmuted}, "unmuted"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.e.Detail(); got != tt.want {
+			t.Errorf("%s: Detail() = %q, want %q", tt.e.Kind, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package activity
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ts6-viewer/internal/ts6"
+)
+
+// Feed keeps the most recent events of a server in a ring buffer. If it
+// was opened with a path, events are also appended to that file as JSON
+// lines and read back on the next start.
+type Feed struct {
+	mu   sync.Mutex
+	buf  []Event // ring buffer of capacity size
+	head int     // index of the oldest event
+	n    int
+	next uint64 // ID of the next event
+
+	prev     []ts6.Client // clients of the last observation
+	observed bool
+
+	path  string
+	file  *os.File
+	lines int // lines in file, compacted once it doubles the size
+}
+
+// NewFeed returns an in-memory feed keeping the last size events.
+func NewFeed(size int) *Feed {
+	return &Feed{buf: make([]Event, size), next: 1}
+}
+
+// Open returns a feed keeping the last size events that is persisted to
+// path. Events already stored there are loaded.
+func Open(path string, size int) (*Feed, error) {
+	f := NewFeed(size)
+	f.path = path
+
+	if err := f.load(); err != nil {
+		return nil, fmt.Errorf("load activity from %s: %w", path, err)
+	}
+	if err := f.compact(); err != nil {
+		return nil, fmt.Errorf("write activity to %s: %w", path, err)
+	}
+
+	return f, nil
+}
+
+func (f *Feed) load() error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // torn write at the end of the file
+		}
+		f.push(e)
+		f.next = max(f.next, e.ID+1)
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with the buffered events only and reopens it
+// for appending.
+func (f *Feed) compact() error {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range f.events() {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	f.lines = f.n
+	return err
+}
+
+// Close stops persisting events. The feed stays usable in memory.
+func (f *Feed) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Observe diffs clients against the previous observation, adds the
+// resulting events and returns them. The first observation only records
+// the state. clients must be sorted by CLID, as returned by Model.Snapshot.
+// The events are returned even if persisting them failed.
+func (f *Feed) Observe(clients []ts6.Client, channelNames map[int]string, at time.Time) ([]Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var events []Event
+	if f.observed {
+		events = Diff(f.prev, clients, channelNames, at)
+	}
+	f.prev = clients
+	f.observed = true
+
+	return events, f.add(events)
+}
+
+// add assigns IDs to events, buffers them and appends them to the file.
+// It must be called with f.mu held.
+func (f *Feed) add(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	for i := range events {
+		events[i].ID = f.next
+		f.next++
+		f.push(events[i])
+	}
+
+	if f.file == nil {
+		return nil
+	}
+
+	w := bufio.NewWriter(f.file)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	f.lines += len(events)
+	if f.lines >= 2*len(f.buf) {
+		return f.compact()
+	}
+	return nil
+}
+
+// push appends e to the ring buffer, overwriting the oldest event once it
+// is full.
+func (f *Feed) push(e Event) {
+	if len(f.buf) == 0 {
+		return
+	}
+	if f.n < len(f.buf) {
+		f.buf[(f.head+f.n)%len(f.buf)] = e
+		f.n++
+		return
+	}
+	f.buf[f.head] = e
+	f.head = (f.head + 1) % len(f.buf)
+}
+
+// events returns the buffered events, oldest first. It must be called with
+// f.mu held.
+func (f *Feed) events() []Event {
+	events := make([]Event, f.n)
+	for i := range events {
+		events[i] = f.buf[(f.head+i)%len(f.buf)]
+	}
+	return events
+}
+
+// Since returns up to limit events with an ID greater than id, oldest
+// first.
+func (f *Feed) Since(id uint64, limit int) []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var events []Event
+	for _, e := range f.events() {
+		if e.ID > id && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// SinceTime returns up to limit events that happened after t, oldest first.
+func (f *Feed) SinceTime(t time.Time, limit int) []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var events []Event
+	for _, e := range f.events() {
+		if e.Time.After(t) && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// LastID returns the ID of the newest event, or 0 if there is none yet.
+func (f *Feed) LastID() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.next - 1
+}
+
+// Recent returns the last n events, newest first.
+func (f *Feed) Recent(n int) []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	all := f.events()
+	n = min(n, len(all))
+
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = all[len(all)-1-i]
+	}
+	return events
+}
@@ -0,0 +1,210 @@
+package activity
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/ts6"
+)
+
+var start = time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+
+// addEvents adds n join events to f, one second apart from start on.
+func addEvents(t *testing.T, f *Feed, n int) {
+	t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	first := int(f.next)
+	var events []Event
+	for i := range n {
+		id := first + i
+		events = append(events, Event{Time: start.Add(time.Duration(id-1) * time.Second), Kind: Joined, CLID: id})
+	}
+	if err := f.add(events); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ids(events []Event) []uint64 {
+	var ids []uint64
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+// clients returns clients with the given CLIDs in the lobby.
+func clients(clids ...int) []ts6.Client {
+	var cls []ts6.Client
+	for _, clid := range clids {
+		cls = append(cls, ts6.Client{CLID: clid, CID: 1})
+	}
+	return cls
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestFeedRingBuffer(t *testing.T) {
+	f := NewFeed(3)
+
+	if f.LastID() != 0 || len(f.Since(0, 10)) != 0 {
+		t.Fatal("new feed not empty")
+	}
+
+	addEvents(t, f, 2)
+	if got := ids(f.Since(0, 10)); !slices.Equal(got, []uint64{1, 2}) {
+		t.Errorf("before wrapping: %v", got)
+	}
+
+	addEvents(t, f, 3)
+
+	tests := []struct {
+		name string
+		got  []Event
+		want []uint64
+	}{
+		{"since 0", f.Since(0, 10), []uint64{3, 4, 5}},
+		{"since 3", f.Since(3, 10), []uint64{4, 5}},
+		{"since last", f.Since(5, 10), nil},
+		{"since future", f.Since(9, 10), nil},
+		{"limited", f.Since(0, 2), []uint64{3, 4}},
+		// Event n happened n-1 seconds after start
+		{"since time", f.SinceTime(start.Add(3*time.Second), 10), []uint64{5}},
+		{"since time before all", f.SinceTime(start.Add(-time.Hour), 10), []uint64{3, 4, 5}},
+		{"since time limited", f.SinceTime(start, 1), []uint64{3}},
+		{"since time after all", f.SinceTime(start.Add(time.Hour), 10), nil},
+		{"recent", f.Recent(2), []uint64{5, 4}},
+		{"recent beyond size", f.Recent(10), []uint64{5, 4, 3}},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.got); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if id := f.LastID(); id != 5 {
+		t.Errorf("LastID = %d, want 5", id)
+	}
+}
+
+func TestFeedObserve(t *testing.T) {
+	f := NewFeed(10)
+
+	// The first observation only records the state
+	events, err := f.Observe(clients(1, 2), nil, start)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("first observation: %v, %v", events, err)
+	}
+
+	events, err = f.Observe(clients(2, 3), nil, start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Kind != Joined || events[1].Kind != Left {
+		t.Fatalf("events = %+v", events)
+	}
+	if got := ids(events); !slices.Equal(got, []uint64{1, 2}) {
+		t.Errorf("IDs = %v, want [1 2]", got)
+	}
+	if got := ids(f.Since(0, 10)); !slices.Equal(got, []uint64{1, 2}) {
+		t.Errorf("buffered %v", got)
+	}
+}
+
+func TestFeedOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "default.jsonl")
+
+	// Written by a viewer killed in the middle of the last line
+	torn := `{"id":7,"time":"2026-03-02T10:00:00Z","kind":"joined","clid":5,"nickname":"Alice","cid":1}
+{"id":8,"time":"2026-03-02T10:01:00Z","kind":"left","clid":5,"nickname":"Alice","cid":1}
+{"id":9,"time":"2026-03-02T10:0`
+	if err := os.WriteFile(path, []byte(torn), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if got := ids(f.Since(0, 10)); !slices.Equal(got, []uint64{7, 8}) {
+		t.Fatalf("loaded %v, want [7 8]", got)
+	}
+	if id := f.LastID(); id != 8 {
+		t.Errorf("LastID = %d, want 8", id)
+	}
+	if e := f.Recent(1)[0]; e.Kind != Left || e.Nickname != "Alice" || !e.Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("loaded %+v", e)
+	}
+
+	// Opening rewrites the file without the torn line
+	if n := countLines(t, path); n != 2 {
+		t.Errorf("%d lines after opening, want 2", n)
+	}
+
+	// Appended until the file holds twice the size, then compacted
+	addEvents(t, f, 1)
+	if n := countLines(t, path); n != 3 {
+		t.Errorf("%d lines, want 3", n)
+	}
+	addEvents(t, f, 1)
+	if n := countLines(t, path); n != 2 {
+		t.Errorf("%d lines after reaching twice the size, want 2", n)
+	}
+	addEvents(t, f, 1)
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened with a larger size, the feed continues where it stopped
+	f, err = Open(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if got := ids(f.Since(0, 10)); !slices.Equal(got, []uint64{9, 10, 11}) {
+		t.Errorf("reloaded %v, want [9 10 11]", got)
+	}
+	addEvents(t, f, 1)
+	if id := f.LastID(); id != 12 {
+		t.Errorf("LastID = %d, want 12", id)
+	}
+}
+
+func TestFeedOpenMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "default.jsonl")
+
+	f, err := Open(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if f.LastID() != 0 {
+		t.Errorf("LastID = %d, want 0", f.LastID())
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("file not created: %v", err)
+	}
+
+	if _, err := Open(filepath.Join(path, "not-a-dir", "x.jsonl"), 5); err == nil {
+		t.Error("opened a file below a file")
+	}
+}
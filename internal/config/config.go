@@ -2,25 +2,149 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 )
 
+// DefaultServerName is the name given to the legacy single "teamspeak6"
+// block when no "servers" list is configured.
+const DefaultServerName = "default"
+
+// reservedServerNames cannot be used as server names because they collide
+// with routes below /ts6viewer/.
+var reservedServerNames = map[string]bool{
+	"activity": true,
+	"data":     true,
+	"events":   true,
+	"fragment": true,
+	"stats":    true,
+}
+
+var reServerName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Teamspeak6 describes one virtual server and the ServerQuery login of the
+// TS6 instance it runs on.
+type Teamspeak6 struct {
+	Name               string `json:"name"`
+	HostConnectionLink string `json:"host_connection_link"`
+
+	Host              string `json:"host"`
+	Port              string `json:"port"`
+	User              string `json:"user"`
+	Password          string `json:"password"`
+	EnableVoiceStatus string `json:"enable_voice_status"`
+	ServerID          string `json:"server_id"`
+
+	HostKeyFingerprint string `json:"host_key_fingerprint"`
+	KnownHostsFile     string `json:"known_hosts_file"`
+	HostKeyTOFU        string `json:"host_key_tofu"`
+
+	// FileTransferHost and FileTransferPort override the address icons
+	// are downloaded from, e.g. when the server is behind NAT. By default
+	// the address announced by the server is used.
+	FileTransferHost string `json:"file_transfer_host"`
+	FileTransferPort string `json:"file_transfer_port"`
+}
+
+// Webhook is an HTTP endpoint notified of server events. Empty filters
+// match everything; Channels lists channel IDs or names, ServerGroups
+// server group IDs.
+type Webhook struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Format string `json:"format"` // discord, slack, json or template
+
+	// Template is a Go text/template executed with the event when Format
+	// is "template", sent with ContentType (default application/json).
+	Template    string `json:"template"`
+	ContentType string `json:"content_type"`
+
+	Events       []string `json:"events"`
+	Servers      []string `json:"servers"`
+	Channels     []string `json:"channels"`
+	ServerGroups []string `json:"server_groups"`
+
+	// Threshold is the online count whose crossing triggers the
+	// online_above and online_below events.
+	Threshold string `json:"threshold"`
+	// DedupWindow is how many seconds an identical event is suppressed
+	// after it was sent, e.g. for clients reconnecting in a loop.
+	DedupWindow string `json:"dedup_window"`
+}
+
 type Config struct {
 	ServerPort         string `json:"server_port"`
 	HostConnectionLink string `json:"host_connection_link"`
 
-	Teamspeak6 struct {
-		Host              string `json:"host"`
-		Port              string `json:"port"`
-		User              string `json:"user"`
-		Password          string `json:"password"`
-		EnableVoiceStatus string `json:"enable_voice_status"`
-		ServerID          string `json:"server_id"`
-	} `json:"teamspeak6"`
+	Teamspeak6 Teamspeak6   `json:"teamspeak6"`
+	Servers    []Teamspeak6 `json:"servers"`
 
 	Theme           string `json:"theme"`
 	RefreshInterval string `json:"refresh_interval"`
 	MaxWidth        string `json:"max_width"`
+
+	// WebDir optionally overrides the embedded templates and static
+	// assets with files from a directory of the same layout.
+	WebDir string `json:"web_dir"`
+
+	// RateLimit is the sustained number of requests per second allowed
+	// per client, RateLimitBurst how many it may make at once.
+	RateLimit      string `json:"rate_limit"`
+	RateLimitBurst string `json:"rate_limit_burst"`
+
+	// TrustedProxies is a comma-separated list of CIDRs or addresses of
+	// reverse proxies whose forwarding headers name the client.
+	TrustedProxies string `json:"trusted_proxies"`
+
+	// StatsDB is the path of the statistics database; statistics are
+	// disabled while it is empty. Samples are taken every
+	// StatsSampleInterval seconds, kept for StatsRawRetention days and
+	// then downsampled into hourly aggregates kept for StatsRetention days.
+	StatsDB             string `json:"stats_db"`
+	StatsSampleInterval string `json:"stats_sample_interval"`
+	StatsRawRetention   string `json:"stats_raw_retention"`
+	StatsRetention      string `json:"stats_retention"`
+
+	// ActivitySize is the number of join, leave and other client events
+	// kept per server. With ActivityDir set, they are also stored there
+	// as one file per server and survive restarts.
+	ActivitySize string `json:"activity_size"`
+	ActivityDir  string `json:"activity_dir"`
+
+	Webhooks []Webhook `json:"webhooks"`
+
+	// GroupBadges shows the server and channel groups of clients next to
+	// their names unless "false". ShowGroups and HideGroups are
+	// comma-separated group names or IDs; with ShowGroups set, only those
+	// groups are shown.
+	GroupBadges string `json:"group_badges"`
+	ShowGroups  string `json:"show_groups"`
+	HideGroups  string `json:"hide_groups"`
+
+	// SortClients is "name" (default) or "talk_power", which sorts the
+	// clients of a channel by talk power and group like the TeamSpeak
+	// client.
+	SortClients string `json:"sort_clients"`
+
+	// CacheDir is where files downloaded from the TS6 servers, such as
+	// icons, are cached. They are kept in memory only while it is empty.
+	// CacheSize is how many MB they may take up before the least recently
+	// used are evicted.
+	CacheDir  string `json:"cache_dir"`
+	CacheSize string `json:"cache_size"`
+
+	// Avatars shows the avatars of clients if "true". Otherwise they are
+	// neither looked up nor downloaded.
+	Avatars string `json:"avatars"`
+
+	// ImageProxy shows the images of channel descriptions and welcome
+	// messages, fetched through the viewer, if "true". Otherwise they are
+	// shown as links.
+	ImageProxy string `json:"image_proxy"`
 }
 
 func Load(path string) (*Config, error) {
@@ -35,5 +159,167 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := cfg.validateServers(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateHostKeys(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.TrustedProxyPrefixes(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateWebhooks(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
+
+// ServerConfigs returns one configuration per virtual server, in the order
+// they are configured. Each copy has Teamspeak6 set to that server's block,
+// so code below the HTTP layer only ever sees a single server. Without a
+// "servers" list, the legacy "teamspeak6" block is returned under
+// DefaultServerName.
+func (c *Config) ServerConfigs() []*Config {
+	servers := c.Servers
+	if len(servers) == 0 {
+		legacy := c.Teamspeak6
+		if legacy.Name == "" {
+			legacy.Name = DefaultServerName
+		}
+		servers = []Teamspeak6{legacy}
+	}
+
+	cfgs := make([]*Config, 0, len(servers))
+	for _, s := range servers {
+		sc := *c
+		sc.Servers = nil
+		sc.Teamspeak6 = s
+		if s.HostConnectionLink != "" {
+			sc.HostConnectionLink = s.HostConnectionLink
+		}
+		cfgs = append(cfgs, &sc)
+	}
+
+	return cfgs
+}
+
+// validateServers fills in missing server names and rejects names that
+// are duplicated or cannot be used in a URL. Without a "servers" list, the
+// name of the legacy "teamspeak6" block is checked the same way.
+func (c *Config) validateServers() error {
+	if len(c.Servers) == 0 {
+		if c.Teamspeak6.Name == "" {
+			return nil
+		}
+		return checkServerName(c.Teamspeak6.Name)
+	}
+
+	seen := make(map[string]bool, len(c.Servers))
+
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		if s.Name == "" {
+			s.Name = "server" + s.ServerID
+		}
+
+		if err := checkServerName(s.Name); err != nil {
+			return err
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("duplicate server name %q", s.Name)
+		}
+		seen[s.Name] = true
+	}
+
+	return nil
+}
+
+// checkServerName rejects names that cannot be used as a path segment
+// below /ts6viewer/.
+func checkServerName(name string) error {
+	switch {
+	case !reServerName.MatchString(name):
+		return fmt.Errorf("invalid server name %q: only letters, digits, '-' and '_' are allowed", name)
+	case reservedServerNames[name]:
+		return fmt.Errorf("invalid server name %q: name is reserved", name)
+	}
+	return nil
+}
+
+// validateHostKeys rejects trust-on-first-use without a known_hosts file:
+// a key kept only relative to the working directory is lost with the
+// container, and whatever key the server presents next is trusted.
+func (c *Config) validateHostKeys() error {
+	for _, sc := range c.ServerConfigs() {
+		if sc.Teamspeak6.HostKeyTOFU == "true" && sc.Teamspeak6.KnownHostsFile == "" {
+			return fmt.Errorf("server %q: host_key_tofu needs known_hosts_file, e.g. in a persisted data directory", sc.Teamspeak6.Name)
+		}
+	}
+
+	return nil
+}
+
+// TrustedProxyPrefixes parses TrustedProxies. Single addresses are turned
+// into prefixes covering just that address.
+func (c *Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, entry := range strings.Split(c.TrustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// webhookFormats are the payload formats a webhook may use.
+var webhookFormats = map[string]bool{
+	"discord":  true,
+	"slack":    true,
+	"json":     true,
+	"template": true,
+}
+
+// validateWebhooks fills in missing webhook names and formats and rejects
+// webhooks that could never be delivered.
+func (c *Config) validateWebhooks() error {
+	for i := range c.Webhooks {
+		wh := &c.Webhooks[i]
+		if wh.Name == "" {
+			wh.Name = fmt.Sprintf("webhook%d", i+1)
+		}
+		if wh.Format == "" {
+			wh.Format = "json"
+		}
+
+		u, err := url.Parse(wh.URL)
+		switch {
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+			return fmt.Errorf("webhook %q: invalid url", wh.Name)
+		case !webhookFormats[wh.Format]:
+			return fmt.Errorf("webhook %q: unknown format %q", wh.Name, wh.Format)
+		case wh.Format == "template" && wh.Template == "":
+			return fmt.Errorf("webhook %q: format \"template\" needs a template", wh.Name)
+		}
+	}
+
+	return nil
+}
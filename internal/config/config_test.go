@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// load writes data to a config file and loads it.
+func load(t *testing.T, data string) (*Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestValidateHostKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"tofu with file", `{"teamspeak6": {"host_key_tofu": "true", "known_hosts_file": "/data/known_hosts"}}`, ""},
+		{"tofu without file", `{"teamspeak6": {"host_key_tofu": "true"}}`, `server "default": host_key_tofu needs known_hosts_file`},
+		{"tofu disabled", `{"teamspeak6": {"host_key_tofu": "false"}}`, ""},
+		{"second server without file", `{"servers": [
+			{"name": "a", "host_key_tofu": "true", "known_hosts_file": "/data/known_hosts"},
+			{"name": "b", "host_key_tofu": "true"}
+		]}`, `server "b": host_key_tofu needs known_hosts_file`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.data)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("err = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServerNames(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+		err  string
+	}{
+		{"legacy default", `{"teamspeak6": {"host": "ts.example.com"}}`, []string{"default"}, ""},
+		{"legacy named", `{"teamspeak6": {"name": "main"}}`, []string{"main"}, ""},
+		{"legacy reserved", `{"teamspeak6": {"name": "stats"}}`, nil, `invalid server name "stats": name is reserved`},
+		{"legacy invalid", `{"teamspeak6": {"name": "a/b"}}`, nil, `invalid server name "a/b"`},
+		{"defaulted from server id", `{"servers": [{"server_id": "1"}, {"name": "music", "server_id": "2"}, {"server_id": "3"}]}`, []string{"server1", "music", "server3"}, ""},
+		{"reserved", `{"servers": [{"name": "events"}]}`, nil, `invalid server name "events": name is reserved`},
+		{"invalid", `{"servers": [{"name": "a b"}]}`, nil, `invalid server name "a b"`},
+		{"duplicate", `{"servers": [{"name": "a"}, {"name": "a"}]}`, nil, `duplicate server name "a"`},
+		{"duplicate default", `{"servers": [{"server_id": "1"}, {"name": "server1"}]}`, nil, `duplicate server name "server1"`},
+		// The legacy block is ignored once servers are listed
+		{"servers win", `{"teamspeak6": {"name": "stats"}, "servers": [{"name": "a"}]}`, []string{"a"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(t, tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, sc := range cfg.ServerConfigs() {
+				got = append(got, sc.Teamspeak6.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerConfigs(t *testing.T) {
+	cfg, err := load(t, `{
+		"host_connection_link": "ts3server://ts.example.com",
+		"theme": "dark",
+		"servers": [
+			{"name": "main", "server_id": "1"},
+			{"name": "music", "server_id": "2", "host_connection_link": "ts3server://ts.example.com?port=9988"}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfgs := cfg.ServerConfigs()
+	if len(cfgs) != 2 {
+		t.Fatalf("got %d configs, want 2", len(cfgs))
+	}
+
+	tests := []struct {
+		name, serverID, link string
+	}{
+		{"main", "1", "ts3server://ts.example.com"},
+		{"music", "2", "ts3server://ts.example.com?port=9988"},
+	}
+	for i, tt := range tests {
+		sc := cfgs[i]
+		if sc.Teamspeak6.Name != tt.name || sc.Teamspeak6.ServerID != tt.serverID {
+			t.Errorf("config %d is %q on server %q", i, sc.Teamspeak6.Name, sc.Teamspeak6.ServerID)
+		}
+		if sc.HostConnectionLink != tt.link {
+			t.Errorf("%s: link = %q, want %q", tt.name, sc.HostConnectionLink, tt.link)
+		}
+		if sc.Servers != nil || sc.Theme != "dark" {
+			t.Errorf("%s: servers = %v, theme = %q", tt.name, sc.Servers, sc.Theme)
+		}
+	}
+
+	// The copies are independent of the original
+	if cfg.HostConnectionLink != "ts3server://ts.example.com" || len(cfg.Servers) != 2 {
+		t.Errorf("original changed: %+v", cfg)
+	}
+}
@@ -0,0 +1,361 @@
+// Package filecache stores files downloaded from the TS6 servers, such as
+// icons, addressed by the SHA-256 of their content.
+package filecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a cached file.
+type Entry struct {
+	Hash string // hex SHA-256 of Data
+	Data []byte
+}
+
+// Cache maps keys, e.g. "default/icon_123", to files. Identical files are
+// stored once. If it was opened with a directory, files are kept there as
+// objects/<hh>/<hash> and keys as refs/<key> files holding the hash, so
+// that the cache survives restarts.
+//
+// A file is removed once no key refers to it any more. Once the files take
+// up more than the maximum size, the least recently used ones are evicted
+// together with the keys referring to them.
+type Cache struct {
+	dir     string
+	maxSize int64 // in bytes, unlimited if not positive
+
+	mu      sync.Mutex
+	refs    map[string]string  // key to hash
+	objects map[string]*object // by hash
+	size    int64              // of all objects
+	clock   uint64             // advanced on every use of an object
+}
+
+// object is a stored file.
+type object struct {
+	size int64
+	refs int    // keys referring to it
+	used uint64 // clock of the last use
+	data []byte // in-memory caches only
+}
+
+// New returns a cache that keeps up to maxSize bytes of files in memory.
+func New(maxSize int64) *Cache {
+	return &Cache{
+		maxSize: maxSize,
+		refs:    make(map[string]string),
+		objects: make(map[string]*object),
+	}
+}
+
+// Open returns a cache that keeps up to maxSize bytes of files in dir,
+// creating it if needed. Files stored there before are loaded; the most
+// recently written count as the most recently used, and those no key
+// refers to are removed.
+func Open(dir string, maxSize int64) (*Cache, error) {
+	for _, sub := range []string{"objects", "refs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("create cache directory: %w", err)
+		}
+	}
+
+	c := New(maxSize)
+	c.dir = dir
+
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("load cache: %w", err)
+	}
+	c.evict("")
+
+	return c, nil
+}
+
+// load indexes the objects and refs in c.dir. Refs to missing objects are
+// removed, and so are objects no ref points to.
+func (c *Cache) load() error {
+	type stored struct {
+		hash    string
+		size    int64
+		modTime time.Time
+	}
+	var found []stored
+
+	err := filepath.WalkDir(filepath.Join(c.dir, "objects"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !validHash(d.Name()) {
+			return nil // leftover temporary file
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		found = append(found, stored{hash: d.Name(), size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].modTime.Before(found[j].modTime) })
+	for _, s := range found {
+		c.clock++
+		c.objects[s.hash] = &object{size: s.size, used: c.clock}
+		c.size += s.size
+	}
+
+	entries, err := os.ReadDir(filepath.Join(c.dir, "refs"))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		path := filepath.Join(c.dir, "refs", e.Name())
+
+		key, err := url.PathUnescape(e.Name())
+		if err != nil || e.IsDir() {
+			continue // leftover temporary file
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		hash := strings.TrimSpace(string(raw))
+		if _, ok := c.objects[hash]; !ok {
+			os.Remove(path)
+			continue
+		}
+		c.refs[key] = hash
+		c.objects[hash].refs++
+	}
+
+	for hash, obj := range c.objects {
+		if obj.refs == 0 {
+			c.drop(hash)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the file stored under key. Files that went missing or were
+// damaged on disk are dropped and reported as not cached.
+func (c *Cache) Get(key string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash, ok := c.refs[key]
+	if !ok {
+		return Entry{}, false
+	}
+
+	data, ok := c.read(hash)
+	if !ok {
+		c.drop(hash)
+		return Entry{}, false
+	}
+	c.touch(hash)
+
+	return Entry{Hash: hash, Data: data}, true
+}
+
+// Put stores data under key, replacing what was stored there before, and
+// evicts other files if the cache grew too large.
+func (c *Cache) Put(key string, data []byte) (Entry, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.objects[hash]; !ok {
+		obj := &object{size: int64(len(data))}
+		if c.dir == "" {
+			obj.data = data
+		} else if err := writeFile(c.objectPath(hash), data); err != nil {
+			return Entry{}, fmt.Errorf("cache %s: %w", key, err)
+		}
+		c.objects[hash] = obj
+		c.size += obj.size
+	}
+	c.touch(hash)
+
+	if old, ok := c.refs[key]; !ok || old != hash {
+		if c.dir != "" {
+			if err := writeFile(c.refPath(key), []byte(hash+"\n")); err != nil {
+				if c.objects[hash].refs == 0 {
+					c.drop(hash)
+				}
+				return Entry{}, fmt.Errorf("cache %s: %w", key, err)
+			}
+		}
+		c.refs[key] = hash
+		c.objects[hash].refs++
+		if ok {
+			c.release(old)
+		}
+	}
+
+	c.evict(hash)
+
+	return Entry{Hash: hash, Data: data}, nil
+}
+
+// Delete forgets key, and the file stored under it unless other keys refer
+// to it.
+func (c *Cache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash, ok := c.refs[key]
+	if !ok {
+		return nil
+	}
+
+	if c.dir != "" {
+		if err := os.Remove(c.refPath(key)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("uncache %s: %w", key, err)
+		}
+	}
+	delete(c.refs, key)
+	c.release(hash)
+
+	return nil
+}
+
+// Size returns the number of bytes the stored files take up.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// read returns the content of hash if it is intact. The caller must hold
+// c.mu.
+func (c *Cache) read(hash string) ([]byte, bool) {
+	obj, ok := c.objects[hash]
+	if !ok {
+		return nil, false
+	}
+	if c.dir == "" {
+		return obj.data, true
+	}
+
+	data, err := os.ReadFile(c.objectPath(hash))
+	if err != nil {
+		return nil, false
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		return nil, false
+	}
+	return data, true
+}
+
+// touch marks hash as used. The caller must hold c.mu.
+func (c *Cache) touch(hash string) {
+	c.clock++
+	c.objects[hash].used = c.clock
+}
+
+// release drops hash once no key refers to it any more. The caller must
+// hold c.mu.
+func (c *Cache) release(hash string) {
+	if obj, ok := c.objects[hash]; ok {
+		if obj.refs--; obj.refs <= 0 {
+			c.drop(hash)
+		}
+	}
+}
+
+// evict drops the least recently used objects other than keep until the
+// cache fits its maximum size. The caller must hold c.mu.
+func (c *Cache) evict(keep string) {
+	for c.maxSize > 0 && c.size > c.maxSize {
+		var oldest string
+		for hash, obj := range c.objects {
+			if hash != keep && (oldest == "" || obj.used < c.objects[oldest].used) {
+				oldest = hash
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		c.drop(oldest)
+	}
+}
+
+// drop removes the object hash and every key referring to it. The caller
+// must hold c.mu.
+func (c *Cache) drop(hash string) {
+	for key, h := range c.refs {
+		if h == hash {
+			delete(c.refs, key)
+			if c.dir != "" {
+				os.Remove(c.refPath(key))
+			}
+		}
+	}
+
+	if obj, ok := c.objects[hash]; ok {
+		c.size -= obj.size
+		delete(c.objects, hash)
+	}
+	if c.dir != "" {
+		os.Remove(c.objectPath(hash))
+	}
+}
+
+func (c *Cache) objectPath(hash string) string {
+	return filepath.Join(c.dir, "objects", hash[:2], hash)
+}
+
+// refPath escapes key so that it names a single file below refs.
+func (c *Cache) refPath(key string) string {
+	return filepath.Join(c.dir, "refs", url.PathEscape(key))
+}
+
+// validHash reports whether name is a hex SHA-256, as opposed to a
+// temporary file.
+func validHash(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// writeFile replaces path atomically, so that readers and a crash never
+// leave a partial file behind.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
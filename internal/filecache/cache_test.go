@@ -0,0 +1,296 @@
+package filecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openCache(t *testing.T, dir string, maxSize int64) *Cache {
+	t.Helper()
+
+	c, err := Open(dir, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func put(t *testing.T, c *Cache, key, data string) Entry {
+	t.Helper()
+
+	e, err := c.Put(key, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// cached returns the content stored under key, or "" if there is none.
+func cached(c *Cache, key string) string {
+	e, ok := c.Get(key)
+	if !ok {
+		return ""
+	}
+	return string(e.Data)
+}
+
+func TestPutGetDelete(t *testing.T) {
+	for name, c := range map[string]*Cache{
+		"memory": New(0),
+		"disk":   openCache(t, t.TempDir(), 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := put(t, c, "default/icon_1", "icon")
+			if sum := sha256.Sum256([]byte("icon")); e.Hash != hex.EncodeToString(sum[:]) {
+				t.Errorf("hash = %q, want the SHA-256 of the content", e.Hash)
+			}
+
+			if got := cached(c, "default/icon_1"); got != "icon" {
+				t.Errorf("get = %q, want icon", got)
+			}
+			if _, ok := c.Get("default/icon_2"); ok {
+				t.Error("unknown key cached")
+			}
+
+			// Replacing the content of a key
+			put(t, c, "default/icon_1", "new icon")
+			if got := cached(c, "default/icon_1"); got != "new icon" {
+				t.Errorf("get after replacing = %q, want new icon", got)
+			}
+
+			if err := c.Delete("default/icon_1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := c.Get("default/icon_1"); ok {
+				t.Error("deleted key still cached")
+			}
+			if err := c.Delete("default/icon_1"); err != nil {
+				t.Errorf("deleting twice: %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryOnly(t *testing.T) {
+	c := New(0)
+
+	put(t, c, "default/icon_1", "icon")
+	put(t, c, "other/icon_1", "icon")
+
+	// Identical files are stored once
+	if c.Size() != 4 {
+		t.Errorf("size = %d, want 4", c.Size())
+	}
+	if got := cached(c, "other/icon_1"); got != "icon" {
+		t.Errorf("get = %q, want icon", got)
+	}
+
+	// Nothing outlives the cache
+	if cached(New(0), "default/icon_1") != "" {
+		t.Error("new cache not empty")
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openCache(t, dir, 0)
+	e := put(t, c, "default/icon_1", "icon")
+	put(t, c, "default/avatar_a/b", "avatar")
+	put(t, c, "other/icon_1", "icon")
+
+	c = openCache(t, dir, 0)
+
+	for key, want := range map[string]string{
+		"default/icon_1":     "icon",
+		"default/avatar_a/b": "avatar",
+		"other/icon_1":       "icon",
+	} {
+		if got := cached(c, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if got, _ := c.Get("default/icon_1"); got.Hash != e.Hash {
+		t.Errorf("hash = %q, want %q", got.Hash, e.Hash)
+	}
+	if c.Size() != int64(len("icon")+len("avatar")) {
+		t.Errorf("size = %d, want %d", c.Size(), len("icon")+len("avatar"))
+	}
+}
+
+func TestCorruptedObject(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openCache(t, dir, 0)
+	e := put(t, c, "default/icon_1", "icon")
+	put(t, c, "other/icon_1", "icon")
+	put(t, c, "default/icon_2", "other")
+
+	path := filepath.Join(dir, "objects", e.Hash[:2], e.Hash)
+	if err := os.WriteFile(path, []byte("damaged"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Get("default/icon_1"); ok {
+		t.Fatal("corrupted object returned")
+	}
+
+	// The object is gone along with every key referring to it, so that it
+	// is downloaded again
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("corrupted object kept: %v", err)
+	}
+	if _, ok := c.Get("other/icon_1"); ok {
+		t.Error("other key to the corrupted object still cached")
+	}
+	if c.Size() != int64(len("other")) {
+		t.Errorf("size = %d, want %d", c.Size(), len("other"))
+	}
+
+	c = openCache(t, dir, 0)
+	if _, ok := c.Get("default/icon_1"); ok {
+		t.Error("dropped key loaded again")
+	}
+	if got := cached(c, "default/icon_2"); got != "other" {
+		t.Errorf("intact key = %q, want other", got)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	for name, open := range map[string]func() *Cache{
+		"memory": func() *Cache { return New(10) },
+		"disk":   func() *Cache { return openCache(t, t.TempDir(), 10) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := open()
+
+			put(t, c, "a", "aaaa")
+			put(t, c, "b", "bbbb")
+			// Shared content counts once
+			put(t, c, "b2", "bbbb")
+			if c.Size() != 8 {
+				t.Fatalf("size = %d, want 8", c.Size())
+			}
+
+			// Using a makes b the least recently used
+			cached(c, "a")
+			put(t, c, "c", "cccc")
+
+			if c.Size() != 8 {
+				t.Errorf("size = %d, want 8", c.Size())
+			}
+			for key, want := range map[string]string{"a": "aaaa", "b": "", "b2": "", "c": "cccc"} {
+				if got := cached(c, key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+
+			// A file larger than the cache is kept until the next one
+			put(t, c, "big", "0123456789ab")
+			if got := cached(c, "big"); got != "0123456789ab" {
+				t.Errorf("big = %q", got)
+			}
+			if cached(c, "a") != "" || cached(c, "c") != "" {
+				t.Error("smaller files kept next to one filling the cache")
+			}
+			put(t, c, "d", "dddd")
+			if cached(c, "big") != "" || c.Size() != 4 {
+				t.Errorf("big kept; size = %d, want 4", c.Size())
+			}
+		})
+	}
+}
+
+func TestEvictionOnOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openCache(t, dir, 0)
+	put(t, c, "a", "aaaa")
+	put(t, c, "b", "bbbb")
+	put(t, c, "c", "cccc")
+
+	// The oldest files are evicted if the cache shrank
+	c = openCache(t, dir, 8)
+	if c.Size() != 8 {
+		t.Errorf("size = %d, want 8", c.Size())
+	}
+	if n := len(c.refs); n != 2 {
+		t.Errorf("%d keys left, want 2", n)
+	}
+
+	c = openCache(t, dir, 8)
+	if c.Size() != 8 || len(c.refs) != 2 {
+		t.Errorf("after reopening: size %d and %d keys, want 8 and 2", c.Size(), len(c.refs))
+	}
+}
+
+func TestUnreferencedObjectsRemoved(t *testing.T) {
+	dir := t.TempDir()
+	c := openCache(t, dir, 0)
+
+	shared := put(t, c, "default/icon_1", "icon")
+	put(t, c, "other/icon_1", "icon")
+	replaced := put(t, c, "default/avatar_a", "old avatar")
+
+	exists := func(e Entry) bool {
+		_, err := os.Stat(filepath.Join(dir, "objects", e.Hash[:2], e.Hash))
+		return err == nil
+	}
+
+	// Another key still refers to the icon
+	if err := c.Delete("default/icon_1"); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(shared) || cached(c, "other/icon_1") != "icon" {
+		t.Error("shared object removed with one of its keys")
+	}
+	if err := c.Delete("other/icon_1"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(shared) {
+		t.Error("object kept after deleting its last key")
+	}
+
+	// A replaced file is no longer referred to either
+	put(t, c, "default/avatar_a", "new avatar")
+	if exists(replaced) {
+		t.Error("replaced object kept")
+	}
+	if c.Size() != int64(len("new avatar")) {
+		t.Errorf("size = %d, want %d", c.Size(), len("new avatar"))
+	}
+}
+
+func TestOpenRemovesUnreferencedObjects(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openCache(t, dir, 0)
+	kept := put(t, c, "default/icon_1", "icon")
+	orphan := put(t, c, "default/icon_2", "orphan")
+
+	// A ref lost in a crash, and one to an object that is gone
+	if err := os.Remove(c.refPath("default/icon_2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.refPath("default/icon_3"), []byte(orphan.Hash[:10]+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c = openCache(t, dir, 0)
+
+	if _, err := os.Stat(c.objectPath(orphan.Hash)); !os.IsNotExist(err) {
+		t.Errorf("unreferenced object kept: %v", err)
+	}
+	if _, err := os.Stat(c.refPath("default/icon_3")); !os.IsNotExist(err) {
+		t.Errorf("dangling ref kept: %v", err)
+	}
+	if e, ok := c.Get("default/icon_1"); !ok || e.Hash != kept.Hash {
+		t.Error("referenced object removed")
+	}
+	if c.Size() != int64(len("icon")) {
+		t.Errorf("size = %d, want %d", c.Size(), len("icon"))
+	}
+}
@@ -0,0 +1,333 @@
+// Package metrics implements the few Prometheus metric types the viewer
+// exports and writes them in the text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry served at /metrics.
+var Default = NewRegistry()
+
+// Registry holds metrics that are updated as things happen and collect
+// functions that report the current state when scraped.
+type Registry struct {
+	mu       sync.Mutex
+	metrics  []metric
+	collects []*collectFunc
+}
+
+// collectFunc is a registered collect function. It is kept by pointer so
+// that it can be removed again.
+type collectFunc struct {
+	fn func(*Collector)
+}
+
+type metric interface {
+	collect(*Collector)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Collect registers fn to be called on every scrape. The returned function
+// removes it again, e.g. when what it reports on is shut down.
+func (r *Registry) Collect(fn func(*Collector)) (remove func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cf := &collectFunc{fn: fn}
+	r.collects = append(r.collects, cf)
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.collects = slices.DeleteFunc(r.collects, func(other *collectFunc) bool {
+			return other == cf
+		})
+	}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all metrics in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	collects := slices.Clone(r.collects)
+	r.mu.Unlock()
+
+	c := &Collector{families: make(map[string]*family)}
+	for _, m := range metrics {
+		m.collect(c)
+	}
+	for _, cf := range collects {
+		cf.fn(c)
+	}
+
+	var b strings.Builder
+	for _, name := range c.order {
+		c.families[name].write(&b)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Collector gathers the samples of one scrape. Samples of the same metric
+// are grouped under one HELP and TYPE line regardless of the order in
+// which they are added.
+type Collector struct {
+	families map[string]*family
+	order    []string
+}
+
+// Gauge adds a gauge sample. labels are name/value pairs.
+func (c *Collector) Gauge(name, help string, value float64, labels ...string) {
+	c.family(name, help, "gauge").add(name, labels, value)
+}
+
+// Counter adds a counter sample for a value counted elsewhere. labels are
+// name/value pairs.
+func (c *Collector) Counter(name, help string, value float64, labels ...string) {
+	c.family(name, help, "counter").add(name, labels, value)
+}
+
+func (c *Collector) family(name, help, typ string) *family {
+	f, ok := c.families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ}
+		c.families[name] = f
+		c.order = append(c.order, name)
+	}
+	return f
+}
+
+type family struct {
+	name, help, typ string
+	samples         []sample
+}
+
+type sample struct {
+	name   string
+	labels []string
+	value  float64
+}
+
+func (f *family) add(name string, labels []string, value float64) {
+	f.samples = append(f.samples, sample{name: name, labels: labels, value: value})
+}
+
+func (f *family) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.typ)
+
+	for _, s := range f.samples {
+		b.WriteString(s.name)
+		if len(s.labels) > 0 {
+			b.WriteByte('{')
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(b, "%s=\"%s\"", s.labels[i], escapeLabel(s.labels[i+1]))
+			}
+			b.WriteByte('}')
+		}
+		b.WriteByte(' ')
+		b.WriteString(formatValue(s.value))
+		b.WriteByte('\n')
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// pairs zips label names and values into name/value pairs.
+func pairs(names, values []string) []string {
+	out := make([]string, 0, 2*len(names))
+	for i, name := range names {
+		out = append(out, name, values[i])
+	}
+	return out
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec creates a counter in the Default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := newCounterVec(name, help, labels...)
+	Default.register(c)
+	return c
+}
+
+func newCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	if len(labels) == 0 {
+		// Export the counter before it is first incremented
+		c.values[""] = &counterValue{}
+	}
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta to the counter with the given label values.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := labelKey(labelValues)
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[key] = v
+	}
+	v.value += delta
+}
+
+func (c *CounterVec) collect(col *Collector) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := col.family(c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		v := c.values[key]
+		f.add(c.name, pairs(c.labels, v.labels), v.value)
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// DefaultBuckets suit latencies in seconds from milliseconds to tens of
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// NewHistogramVec creates a histogram in the Default registry. buckets are
+// the upper bounds in increasing order.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := newHistogramVec(name, help, buckets, labels...)
+	Default.register(h)
+	return h
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+}
+
+// Observe records v for the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := labelKey(labelValues)
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = hv
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *HistogramVec) collect(col *Collector) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f := col.family(h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.values) {
+		hv := h.values[key]
+		labels := pairs(h.labels, hv.labels)
+
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hv.counts[i]
+			f.add(h.name+"_bucket", append(labels[:len(labels):len(labels)], "le", formatValue(le)), float64(cumulative))
+		}
+		f.add(h.name+"_bucket", append(labels[:len(labels):len(labels)], "le", "+Inf"), float64(hv.count))
+		f.add(h.name+"_sum", labels, hv.sum)
+		f.add(h.name+"_count", labels, float64(hv.count))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
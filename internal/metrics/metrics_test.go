@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != b.Len() {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, b.Len())
+	}
+	return b.String()
+}
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	requests := newCounterVec("requests_total", "Requests by route\nand code.", "route", "code")
+	r.register(requests)
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "404")
+	requests.Inc("/a", "404")
+
+	floods := newCounterVec("floods_total", `Flood bans, see C:\logs.`)
+	r.register(floods)
+
+	r.Collect(func(c *Collector) {
+		c.Gauge("clients", "Clients per channel.", 3, "channel", `Say "hi"`+"\n"+`C:\`)
+		c.Counter("connections_total", "Connections.", 42)
+		// Joins the family above despite the sample in between
+		c.Gauge("clients", "Clients per channel.", math.Inf(1), "channel", "Lobby")
+	})
+
+	want := `# HELP requests_total Requests by route\nand code.
+# TYPE requests_total counter
+requests_total{route="/a",code="404"} 3
+requests_total{route="/b",code="200"} 1
+# HELP floods_total Flood bans, see C:\\logs.
+# TYPE floods_total counter
+floods_total 0
+# HELP clients Clients per channel.
+# TYPE clients gauge
+clients{channel="Say \"hi\"\nC:\\"} 3
+clients{channel="Lobby"} +Inf
+# HELP connections_total Connections.
+# TYPE connections_total counter
+connections_total 42
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+
+	h := newHistogramVec("duration_seconds", "Command duration.", []float64{0.1, 0.5, 1}, "command")
+	r.register(h)
+
+	for _, v := range []float64{0.05, 0.1, 0.3, 2} {
+		h.Observe(v, "version")
+	}
+	h.Observe(0.7, "clientlist")
+
+	want := `# HELP duration_seconds Command duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{command="clientlist",le="0.1"} 0
+duration_seconds_bucket{command="clientlist",le="0.5"} 0
+duration_seconds_bucket{command="clientlist",le="1"} 1
+duration_seconds_bucket{command="clientlist",le="+Inf"} 1
+duration_seconds_sum{command="clientlist"} 0.7
+duration_seconds_count{command="clientlist"} 1
+duration_seconds_bucket{command="version",le="0.1"} 2
+duration_seconds_bucket{command="version",le="0.5"} 3
+duration_seconds_bucket{command="version",le="1"} 3
+duration_seconds_bucket{command="version",le="+Inf"} 4
+duration_seconds_sum{command="version"} 2.45
+duration_seconds_count{command="version"} 4
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCollectRemove(t *testing.T) {
+	r := NewRegistry()
+
+	collect := func(server string) func() {
+		return r.Collect(func(c *Collector) {
+			c.Gauge("up", "Up.", 1, "server", server)
+		})
+	}
+
+	removeA := collect("a")
+	removeB := collect("b")
+
+	if got := strings.Count(scrape(t, r), "up{"); got != 2 {
+		t.Fatalf("%d samples, want 2", got)
+	}
+
+	removeA()
+	removeA() // removing twice is harmless
+
+	got := scrape(t, r)
+	if strings.Contains(got, `server="a"`) || !strings.Contains(got, `up{server="b"} 1`) {
+		t.Errorf("after removing a:\n%s", got)
+	}
+
+	removeB()
+	if got := scrape(t, r); got != "" {
+		t.Errorf("after removing all:\n%s", got)
+	}
+}
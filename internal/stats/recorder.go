@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ts6-viewer/internal/ts6"
+)
+
+// compactInterval is how often the store is downsampled and pruned.
+const compactInterval = time.Hour
+
+// Recorder samples the model of one server into a Store and tracks the
+// sessions of its clients.
+type Recorder struct {
+	store    *Store
+	server   string
+	model    *ts6.Model
+	interval time.Duration
+
+	open map[string]Session // sessions of online clients by unique ID
+}
+
+// NewRecorder creates a recorder that samples model every interval.
+func NewRecorder(store *Store, server string, model *ts6.Model, interval time.Duration) *Recorder {
+	return &Recorder{
+		store:    store,
+		server:   server,
+		model:    model,
+		interval: interval,
+		open:     make(map[string]Session),
+	}
+}
+
+// Run samples the model until ctx is done. Sessions left open by a previous
+// run are closed first; sessions still open when ctx is done are ended.
+func (r *Recorder) Run(ctx context.Context) {
+	if err := r.store.CloseOpenSessions(r.server); err != nil {
+		log.Printf("[STATS] Closing sessions of %q failed: %v\n", r.server, err)
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	var lastCompact time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.endSessions(time.Now())
+			return
+		case now := <-ticker.C:
+			r.sample(now)
+
+			if now.Sub(lastCompact) >= compactInterval {
+				if err := r.store.Compact(r.server, now); err != nil {
+					log.Printf("[STATS] Compacting %q failed: %v\n", r.server, err)
+				}
+				lastCompact = now
+			}
+		}
+	}
+}
+
+// sample records the occupancy and starts and ends sessions. Nothing is
+// recorded while the model has not been synced.
+func (r *Recorder) sample(now time.Time) {
+	channels, clients, _, ok := r.model.Snapshot()
+	if !ok {
+		return
+	}
+
+	sample := Sample{Time: now, Channels: make(map[int]int)}
+	online := make(map[string]ts6.Client)
+
+	for _, cl := range clients {
+		if cl.Type != 0 {
+			continue // ServerQuery client
+		}
+		sample.Online++
+		sample.Channels[cl.CID]++
+		online[cl.UniqueIdentifier] = cl
+	}
+
+	names := make(map[int]string, len(channels))
+	for _, ch := range channels {
+		names[ch.CID] = ch.Name
+	}
+
+	if err := r.store.Record(r.server, sample, names); err != nil {
+		log.Printf("[STATS] Recording sample of %q failed: %v\n", r.server, err)
+	}
+
+	for uid, session := range r.open {
+		if _, ok := online[uid]; !ok {
+			r.endSession(session, now)
+		}
+	}
+	for uid, cl := range online {
+		if _, ok := r.open[uid]; ok {
+			continue
+		}
+		session := Session{UID: uid, Nickname: cl.Nickname, Start: now}
+		if err := r.store.PutSession(r.server, session); err != nil {
+			log.Printf("[STATS] Recording session of %q failed: %v\n", r.server, err)
+			continue
+		}
+		r.open[uid] = session
+	}
+}
+
+func (r *Recorder) endSession(session Session, at time.Time) {
+	session.End = at
+	if err := r.store.PutSession(r.server, session); err != nil {
+		log.Printf("[STATS] Recording session of %q failed: %v\n", r.server, err)
+	}
+	delete(r.open, session.UID)
+}
+
+func (r *Recorder) endSessions(at time.Time) {
+	for _, session := range r.open {
+		r.endSession(session, at)
+	}
+}
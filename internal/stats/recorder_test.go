@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/ts6"
+)
+
+func TestRecorderSessions(t *testing.T) {
+	s := openStore(t, Options{RawRetention: 24 * time.Hour, Retention: 30 * 24 * time.Hour})
+
+	alice := ts6.Client{CLID: 1, CID: 1, Nickname: "Alice", UniqueIdentifier: "alice"}
+	bob := ts6.Client{CLID: 2, CID: 2, Nickname: "Bob", UniqueIdentifier: "bob"}
+	query := ts6.Client{CLID: 3, CID: 1, Nickname: "serveradmin", UniqueIdentifier: "serveradmin", Type: 1}
+	channels := []ts6.Channel{{CID: 1, Name: "Lobby"}, {CID: 2, Name: "Gaming"}}
+
+	m := ts6.NewModel(false)
+	r := NewRecorder(s, "default", m, time.Minute)
+
+	// Nothing is recorded before the first sync
+	r.sample(monday.Add(-time.Minute))
+
+	online := func(at time.Duration, clients ...ts6.Client) {
+		m.Replace(channels, clients, &ts6.ServerInfo{})
+		r.sample(monday.Add(at))
+	}
+	online(0, alice, bob, query)
+	online(10*time.Minute, alice, query)
+	// Bob reconnects under another nickname
+	bob.CLID, bob.Nickname = 4, "Bobby"
+	online(20*time.Minute, alice, bob)
+	r.endSessions(monday.Add(30 * time.Minute))
+
+	rep := report(t, s, monday.Add(-time.Hour), monday.Add(time.Hour))
+
+	if rep.Sessions != 3 || rep.UniqueClients != 2 {
+		t.Errorf("%d sessions of %d clients, want 3 of 2", rep.Sessions, rep.UniqueClients)
+	}
+	// 30 minutes of Alice and twice 10 of Bob
+	if want := 50 * time.Minute / 3; rep.AverageSession != want.Truncate(time.Second) {
+		t.Errorf("average session = %v, want %v", rep.AverageSession, want.Truncate(time.Second))
+	}
+	if rep.Peak != 2 || rep.Average != 5.0/3 {
+		t.Errorf("peak %d, average %v: ServerQuery clients counted or samples missing", rep.Peak, rep.Average)
+	}
+	if rep.Channels[0].Name != "Lobby" || rep.Channels[0].Average != 1 {
+		t.Errorf("channels = %+v", rep.Channels)
+	}
+}
+
+func TestCloseOpenSessions(t *testing.T) {
+	s := openStore(t, Options{RawRetention: 24 * time.Hour, Retention: 30 * 24 * time.Hour})
+
+	// A previous run stopped without ending the sessions; its last sample
+	// was taken at 10:50
+	for _, session := range []Session{
+		{UID: "alice", Start: monday},
+		{UID: "bob", Start: monday.Add(10 * time.Minute), End: monday.Add(20 * time.Minute)},
+		{UID: "carol", Start: monday.Add(55 * time.Minute)},
+	} {
+		if err := s.PutSession("default", session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(t, s, monday.Add(50*time.Minute), map[int]int{1: 1})
+
+	if err := s.CloseOpenSessions("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 50 minutes of Alice, 10 of Bob and none of Carol, who started after
+	// the last sample
+	rep := report(t, s, monday, monday.Add(2*time.Hour))
+	if rep.Sessions != 3 || rep.AverageSession != 20*time.Minute {
+		t.Errorf("%d sessions averaging %v, want 3 averaging 20m", rep.Sessions, rep.AverageSession)
+	}
+
+	// Sessions still open count until the end of the report
+	if err := s.PutSession("default", Session{UID: "dave", Start: monday.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	rep = report(t, s, monday, monday.Add(2*time.Hour))
+	if rep.Sessions != 4 || rep.AverageSession != 30*time.Minute {
+		t.Errorf("%d sessions averaging %v, want 4 averaging 30m", rep.Sessions, rep.AverageSession)
+	}
+}
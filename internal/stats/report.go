@@ -0,0 +1,232 @@
+package stats
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// busiestChannels is the number of channels listed in a report.
+const busiestChannels = 10
+
+// Report summarizes the occupancy of a server over a period. Times are in
+// the local time zone of the viewer.
+type Report struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Average is the mean number of clients online over all samples.
+	Average float64   `json:"average"`
+	Peak    int       `json:"peak"`
+	PeakAt  time.Time `json:"peak_at,omitzero"` // hour of the peak
+
+	DailyPeaks  []Peak `json:"daily_peaks"`
+	WeeklyPeaks []Peak `json:"weekly_peaks"` // weeks start on Monday
+
+	// Heatmap is the average number of clients online by weekday
+	// (0 is Sunday) and hour of the day.
+	Heatmap [7][24]float64 `json:"heatmap"`
+
+	// Channels are the busiest channels by average occupancy.
+	Channels []ChannelOccupancy `json:"channels"`
+
+	Sessions       int           `json:"sessions"`
+	UniqueClients  int           `json:"unique_clients"`
+	AverageSession time.Duration `json:"average_session"`
+}
+
+// Peak is the highest number of clients online within a day or week.
+type Peak struct {
+	Start time.Time `json:"start"`
+	Max   int       `json:"max"`
+}
+
+// ChannelOccupancy is the average number of clients in a channel.
+type ChannelOccupancy struct {
+	CID     int     `json:"cid"`
+	Name    string  `json:"name"`
+	Average float64 `json:"average"`
+}
+
+// Report summarizes the samples and sessions of server between from and
+// to. Samples that are not downsampled yet are aggregated on the fly.
+func (s *Store) Report(server string, from, to time.Time) (*Report, error) {
+	report := &Report{From: from, To: to}
+
+	hours := make(map[int64]*Hour)
+	names := make(map[int]string)
+	var sessions []Session
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := serverBucket(tx, server)
+		if err != nil || b == nil {
+			return err
+		}
+
+		stored := b.Bucket(bucketHours).Cursor()
+		for k, v := stored.Seek(timeKey(from.Truncate(time.Hour))); k != nil && keyTime(k).Before(to); k, v = stored.Next() {
+			var h Hour
+			if err := json.Unmarshal(v, &h); err != nil {
+				return err
+			}
+			start := keyTime(k).Unix()
+			if existing, ok := hours[start]; ok {
+				existing.merge(&h)
+			} else {
+				hours[start] = &h
+			}
+		}
+
+		samples := b.Bucket(bucketSamples).Cursor()
+		for k, v := samples.Seek(timeKey(from)); k != nil && keyTime(k).Before(to); k, v = samples.Next() {
+			var sample Sample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return err
+			}
+			start := keyTime(k).Truncate(time.Hour).Unix()
+			h, ok := hours[start]
+			if !ok {
+				h = &Hour{}
+				hours[start] = h
+			}
+			h.add(sample)
+		}
+
+		err = b.Bucket(bucketChannels).ForEach(func(k, v []byte) error {
+			names[int(keyTime(k).Unix())] = string(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		c := b.Bucket(bucketSessions).Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && keyTime(k).Before(to); k, v = c.Next() {
+			var session Session
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			sessions = append(sessions, session)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.summarizeHours(hours, names)
+	report.summarizeSessions(sessions, to)
+
+	return report, nil
+}
+
+func (r *Report) summarizeHours(hours map[int64]*Hour, names map[int]string) {
+	starts := make([]int64, 0, len(hours))
+	for start := range hours {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var (
+		samples, sum int
+		channels     = make(map[int]int)
+		heatSum      [7][24]int
+		heatSamples  [7][24]int
+	)
+
+	for _, start := range starts {
+		h := hours[start]
+		t := time.Unix(start, 0)
+
+		samples += h.Samples
+		sum += h.Sum
+		for cid, n := range h.Channels {
+			channels[cid] += n
+		}
+
+		if h.Max > r.Peak {
+			r.Peak, r.PeakAt = h.Max, t
+		}
+
+		heatSum[t.Weekday()][t.Hour()] += h.Sum
+		heatSamples[t.Weekday()][t.Hour()] += h.Samples
+
+		r.DailyPeaks = addPeak(r.DailyPeaks, startOfDay(t), h.Max)
+		r.WeeklyPeaks = addPeak(r.WeeklyPeaks, startOfWeek(t), h.Max)
+	}
+
+	if samples == 0 {
+		return
+	}
+	r.Average = float64(sum) / float64(samples)
+
+	for day := range heatSum {
+		for hour := range heatSum[day] {
+			if heatSamples[day][hour] > 0 {
+				r.Heatmap[day][hour] = float64(heatSum[day][hour]) / float64(heatSamples[day][hour])
+			}
+		}
+	}
+
+	for cid, n := range channels {
+		if n == 0 {
+			continue
+		}
+		r.Channels = append(r.Channels, ChannelOccupancy{
+			CID:     cid,
+			Name:    names[cid],
+			Average: float64(n) / float64(samples),
+		})
+	}
+	sort.Slice(r.Channels, func(i, j int) bool {
+		if r.Channels[i].Average != r.Channels[j].Average {
+			return r.Channels[i].Average > r.Channels[j].Average
+		}
+		return r.Channels[i].CID < r.Channels[j].CID
+	})
+	if len(r.Channels) > busiestChannels {
+		r.Channels = r.Channels[:busiestChannels]
+	}
+}
+
+func (r *Report) summarizeSessions(sessions []Session, to time.Time) {
+	uids := make(map[string]bool)
+	var total time.Duration
+
+	for _, session := range sessions {
+		end := session.End
+		if end.IsZero() || end.After(to) {
+			end = to
+		}
+		total += end.Sub(session.Start)
+		uids[session.UID] = true
+	}
+
+	r.Sessions = len(sessions)
+	r.UniqueClients = len(uids)
+	if len(sessions) > 0 {
+		r.AverageSession = (total / time.Duration(len(sessions))).Truncate(time.Second)
+	}
+}
+
+// addPeak raises the peak starting at start, appending it if it is new.
+// Hours are visited in order, so a new period is always the last one.
+func addPeak(peaks []Peak, start time.Time, n int) []Peak {
+	if len(peaks) > 0 && peaks[len(peaks)-1].Start.Equal(start) {
+		peaks[len(peaks)-1].Max = max(peaks[len(peaks)-1].Max, n)
+		return peaks
+	}
+	return append(peaks, Peak{Start: start, Max: n})
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+	return startOfDay(t).AddDate(0, 0, -offset)
+}
@@ -0,0 +1,299 @@
+// Package stats records the occupancy of virtual servers over time and
+// summarizes it into peaks, averages and busiest hours and channels.
+package stats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets below the per-server bucket.
+var (
+	bucketSamples  = []byte("samples")  // unix second → Sample
+	bucketHours    = []byte("hours")    // unix second of the hour → Hour
+	bucketSessions = []byte("sessions") // unix second of the start + uid → Session
+	bucketChannels = []byte("channels") // cid → last known name
+)
+
+// Options bound the size of the store.
+type Options struct {
+	// RawRetention is how long individual samples are kept before they
+	// are downsampled into hourly aggregates.
+	RawRetention time.Duration
+	// Retention is how long hourly aggregates and sessions are kept.
+	Retention time.Duration
+}
+
+// Store keeps samples, hourly aggregates and client sessions of any number
+// of servers in a bbolt database file.
+type Store struct {
+	db   *bolt.DB
+	opts Options
+}
+
+// Sample is the occupancy of a server at one point in time.
+type Sample struct {
+	Time     time.Time   `json:"-"`
+	Online   int         `json:"online"`
+	Channels map[int]int `json:"channels"` // clients per channel ID
+}
+
+// Hour aggregates the samples taken within one hour.
+type Hour struct {
+	Start    time.Time   `json:"-"`
+	Samples  int         `json:"samples"`
+	Sum      int         `json:"sum"` // online clients summed over samples
+	Max      int         `json:"max"`
+	Channels map[int]int `json:"channels"` // clients per channel summed over samples
+}
+
+func (h *Hour) add(s Sample) {
+	h.Samples++
+	h.Sum += s.Online
+	h.Max = max(h.Max, s.Online)
+
+	if h.Channels == nil {
+		h.Channels = make(map[int]int, len(s.Channels))
+	}
+	for cid, n := range s.Channels {
+		h.Channels[cid] += n
+	}
+}
+
+func (h *Hour) merge(o *Hour) {
+	h.Samples += o.Samples
+	h.Sum += o.Sum
+	h.Max = max(h.Max, o.Max)
+
+	if h.Channels == nil {
+		h.Channels = make(map[int]int, len(o.Channels))
+	}
+	for cid, n := range o.Channels {
+		h.Channels[cid] += n
+	}
+}
+
+// Session is the time a client was connected, keyed by its unique
+// identifier. End is zero while the client is still online.
+type Session struct {
+	UID      string    `json:"uid"`
+	Nickname string    `json:"nickname"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end,omitzero"`
+}
+
+// Open opens or creates the store at path.
+func Open(path string, opts Options) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open stats store: %w", err)
+	}
+
+	return &Store{db: db, opts: opts}, nil
+}
+
+// Close closes the database file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.Unix()))
+	return key
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(key[:8])), 0)
+}
+
+func sessionKey(start time.Time, uid string) []byte {
+	return append(timeKey(start), uid...)
+}
+
+func intKey(n int) []byte {
+	return timeKey(time.Unix(int64(n), 0))
+}
+
+// serverBucket returns the bucket of server within tx, creating it and its
+// sub-buckets on first use if tx is writable.
+func serverBucket(tx *bolt.Tx, server string) (*bolt.Bucket, error) {
+	if !tx.Writable() {
+		return tx.Bucket([]byte(server)), nil
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte(server))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range [][]byte{bucketSamples, bucketHours, bucketSessions, bucketChannels} {
+		if _, err := b.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func put(b *bolt.Bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// Record stores a sample of server and the current channel names.
+func (s *Store) Record(server string, sample Sample, channelNames map[int]string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := serverBucket(tx, server)
+		if err != nil {
+			return err
+		}
+
+		if err := put(b.Bucket(bucketSamples), timeKey(sample.Time), sample); err != nil {
+			return err
+		}
+
+		names := b.Bucket(bucketChannels)
+		for cid, name := range channelNames {
+			if !bytes.Equal(names.Get(intKey(cid)), []byte(name)) {
+				if err := names.Put(intKey(cid), []byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// PutSession stores a session that started or ended.
+func (s *Store) PutSession(server string, session Session) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := serverBucket(tx, server)
+		if err != nil {
+			return err
+		}
+		return put(b.Bucket(bucketSessions), sessionKey(session.Start, session.UID), session)
+	})
+}
+
+// CloseOpenSessions ends the sessions of server that are still open, for
+// example because the viewer was stopped, at the time of the last sample.
+func (s *Store) CloseOpenSessions(server string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := serverBucket(tx, server)
+		if err != nil {
+			return err
+		}
+
+		last, _ := b.Bucket(bucketSamples).Cursor().Last()
+		if last == nil {
+			return nil
+		}
+		end := keyTime(last)
+
+		sessions := b.Bucket(bucketSessions)
+		var open []Session
+		err = sessions.ForEach(func(_, v []byte) error {
+			var session Session
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			if session.End.IsZero() {
+				open = append(open, session)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, session := range open {
+			session.End = end
+			if session.End.Before(session.Start) {
+				session.End = session.Start
+			}
+			if err := put(sessions, sessionKey(session.Start, session.UID), session); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Compact downsamples the samples of server that are older than the raw
+// retention into hourly aggregates and deletes aggregates and sessions
+// older than the retention.
+func (s *Store) Compact(server string, now time.Time) error {
+	rawCutoff := now.Add(-s.opts.RawRetention).Truncate(time.Hour)
+	cutoff := now.Add(-s.opts.Retention)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := serverBucket(tx, server)
+		if err != nil {
+			return err
+		}
+
+		// Downsample whole hours only, so that every hour is aggregated
+		// exactly once
+		hours := make(map[int64]*Hour)
+		samples := b.Bucket(bucketSamples)
+		c := samples.Cursor()
+		for k, v := c.First(); k != nil && keyTime(k).Before(rawCutoff); k, v = c.First() {
+			var sample Sample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return err
+			}
+			sample.Time = keyTime(k)
+
+			start := sample.Time.Truncate(time.Hour).Unix()
+			h, ok := hours[start]
+			if !ok {
+				h = &Hour{}
+				hours[start] = h
+			}
+			h.add(sample)
+
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+
+		stored := b.Bucket(bucketHours)
+		for start, h := range hours {
+			key := timeKey(time.Unix(start, 0))
+			if v := stored.Get(key); v != nil {
+				var existing Hour
+				if err := json.Unmarshal(v, &existing); err != nil {
+					return err
+				}
+				h.merge(&existing)
+			}
+			if err := put(stored, key, h); err != nil {
+				return err
+			}
+		}
+
+		if err := deleteBefore(stored, cutoff); err != nil {
+			return err
+		}
+		return deleteBefore(b.Bucket(bucketSessions), cutoff)
+	})
+}
+
+// deleteBefore deletes the entries of a bucket keyed by time that are
+// older than cutoff.
+func deleteBefore(b *bolt.Bucket, cutoff time.Time) error {
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && keyTime(k).Before(cutoff); k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package stats
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// monday is 10:00 on a Monday in the local time zone, which reports use.
+var monday = time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+
+func openStore(t *testing.T, opts Options) *Store {
+	t.Helper()
+
+	s, err := Open(filepath.Join(t.TempDir(), "stats.db"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func record(t *testing.T, s *Store, at time.Time, channels map[int]int) {
+	t.Helper()
+
+	sample := Sample{Time: at, Channels: channels}
+	for _, n := range channels {
+		sample.Online += n
+	}
+	if err := s.Record("default", sample, map[int]string{1: "Lobby", 2: "Gaming"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func report(t *testing.T, s *Store, from, to time.Time) *Report {
+	t.Helper()
+
+	r, err := s.Report("default", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// keys returns the times of the entries in a bucket of the default server.
+func keys(t *testing.T, s *Store, bucket []byte) []time.Time {
+	t.Helper()
+
+	var times []time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("default")).Bucket(bucket).ForEach(func(k, _ []byte) error {
+			times = append(times, keyTime(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return times
+}
+
+func TestReport(t *testing.T) {
+	s := openStore(t, Options{RawRetention: 24 * time.Hour, Retention: 30 * 24 * time.Hour})
+
+	tuesday := monday.AddDate(0, 0, 1).Add(2 * time.Hour)
+	record(t, s, monday, map[int]int{1: 2})
+	record(t, s, monday.Add(30*time.Minute), map[int]int{1: 1, 2: 3})
+	record(t, s, monday.Add(75*time.Minute), map[int]int{2: 1})
+	record(t, s, tuesday, map[int]int{1: 6})
+
+	r := report(t, s, monday.Add(-time.Hour), tuesday.Add(time.Hour))
+
+	if r.Average != 3.25 {
+		t.Errorf("average = %v, want 3.25", r.Average)
+	}
+	if r.Peak != 6 || !r.PeakAt.Equal(tuesday) {
+		t.Errorf("peak = %d at %v, want 6 at %v", r.Peak, r.PeakAt, tuesday)
+	}
+
+	wantDaily := []Peak{{startOfDay(monday), 4}, {startOfDay(tuesday), 6}}
+	if !reflect.DeepEqual(r.DailyPeaks, wantDaily) {
+		t.Errorf("daily peaks = %v, want %v", r.DailyPeaks, wantDaily)
+	}
+	wantWeekly := []Peak{{startOfDay(monday), 6}}
+	if !reflect.DeepEqual(r.WeeklyPeaks, wantWeekly) {
+		t.Errorf("weekly peaks = %v, want %v", r.WeeklyPeaks, wantWeekly)
+	}
+
+	var want [7][24]float64
+	want[time.Monday][10] = 3
+	want[time.Monday][11] = 1
+	want[time.Tuesday][12] = 6
+	if r.Heatmap != want {
+		t.Errorf("heatmap = %v, want %v", r.Heatmap, want)
+	}
+
+	wantChannels := []ChannelOccupancy{{1, "Lobby", 2.25}, {2, "Gaming", 1}}
+	if !reflect.DeepEqual(r.Channels, wantChannels) {
+		t.Errorf("channels = %+v, want %+v", r.Channels, wantChannels)
+	}
+
+	// Only the sample within the period counts
+	r = report(t, s, monday.Add(20*time.Minute), monday.Add(time.Hour))
+	if r.Peak != 4 || r.Average != 4 || len(r.DailyPeaks) != 1 {
+		t.Errorf("partial hour: %+v", r)
+	}
+
+	// A server without samples has an empty report
+	r, err := s.Report("other", monday, tuesday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Peak != 0 || r.Average != 0 || r.DailyPeaks != nil || r.Sessions != 0 {
+		t.Errorf("unknown server: %+v", r)
+	}
+}
+
+func TestWeeklyPeaks(t *testing.T) {
+	s := openStore(t, Options{RawRetention: 24 * time.Hour, Retention: 30 * 24 * time.Hour})
+
+	sunday := monday.AddDate(0, 0, 6)
+	nextMonday := monday.AddDate(0, 0, 7)
+	record(t, s, monday, map[int]int{1: 2})
+	record(t, s, sunday, map[int]int{1: 5})
+	record(t, s, nextMonday, map[int]int{1: 3})
+
+	r := report(t, s, monday, nextMonday.Add(time.Hour))
+
+	want := []Peak{{startOfDay(monday), 5}, {startOfDay(nextMonday), 3}}
+	if !reflect.DeepEqual(r.WeeklyPeaks, want) {
+		t.Errorf("weekly peaks = %v, want %v", r.WeeklyPeaks, want)
+	}
+	if r.Heatmap[time.Sunday][10] != 5 || r.Heatmap[time.Monday][10] != 2.5 {
+		t.Errorf("heatmap at 10:00: Sunday %v, Monday %v", r.Heatmap[time.Sunday][10], r.Heatmap[time.Monday][10])
+	}
+}
+
+func TestCompact(t *testing.T) {
+	s := openStore(t, Options{RawRetention: 2 * time.Hour, Retention: 24 * time.Hour})
+
+	for _, at := range []time.Duration{0, 30 * time.Minute, 2*time.Hour + 59*time.Minute, 3 * time.Hour, 4 * time.Hour} {
+		record(t, s, monday.Add(at), map[int]int{1: 1 + int(at/time.Hour)})
+	}
+	from, to := monday.Add(-time.Hour), monday.Add(6*time.Hour)
+	before := report(t, s, from, to)
+
+	// At 15:10 the samples before 13:00 are older than two hours, counted
+	// in whole hours
+	now := monday.Add(5*time.Hour + 10*time.Minute)
+	if err := s.Compact("default", now); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSamples := []time.Time{monday.Add(3 * time.Hour), monday.Add(4 * time.Hour)}
+	if got := keys(t, s, bucketSamples); !reflect.DeepEqual(got, wantSamples) {
+		t.Errorf("samples = %v, want %v", got, wantSamples)
+	}
+	wantHours := []time.Time{monday, monday.Add(2 * time.Hour)}
+	if got := keys(t, s, bucketHours); !reflect.DeepEqual(got, wantHours) {
+		t.Errorf("hours = %v, want %v", got, wantHours)
+	}
+
+	if after := report(t, s, from, to); !reflect.DeepEqual(after, before) {
+		t.Errorf("report changed by compacting:\n%+v\nwant\n%+v", after, before)
+	}
+
+	// Compacting again aggregates nothing twice; a late sample is merged
+	// into its hour
+	if err := s.Compact("default", now); err != nil {
+		t.Fatal(err)
+	}
+	record(t, s, monday.Add(45*time.Minute), map[int]int{1: 7})
+	if err := s.Compact("default", now); err != nil {
+		t.Fatal(err)
+	}
+
+	r := report(t, s, monday, monday.Add(time.Hour))
+	if r.Average != 3 || r.Peak != 7 {
+		t.Errorf("hour after merging: average %v, peak %d, want 3 and 7", r.Average, r.Peak)
+	}
+
+	// A day later, everything before 12:30 is pruned
+	if err := s.PutSession("default", Session{UID: "a", Start: monday, End: monday.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutSession("default", Session{UID: "b", Start: monday.Add(4 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Compact("default", monday.Add(26*time.Hour+30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := keys(t, s, bucketSamples); len(got) != 0 {
+		t.Errorf("samples = %v, want all downsampled", got)
+	}
+	wantHours = []time.Time{monday.Add(3 * time.Hour), monday.Add(4 * time.Hour)}
+	if got := keys(t, s, bucketHours); !reflect.DeepEqual(got, wantHours) {
+		t.Errorf("hours = %v, want %v", got, wantHours)
+	}
+	if got := keys(t, s, bucketSessions); !reflect.DeepEqual(got, []time.Time{monday.Add(4 * time.Hour)}) {
+		t.Errorf("sessions = %v, want the one of 14:00", got)
+	}
+}
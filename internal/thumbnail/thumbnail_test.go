@@ -0,0 +1,179 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encode returns a w×h image as PNG, red on its left half and blue on its
+// right half.
+func encode(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("thumbnail is not a PNG: %v", err)
+	}
+	return img
+}
+
+func TestMakeSize(t *testing.T) {
+	tests := []struct {
+		name string
+		w, h int
+		want int
+	}{
+		{"square", 256, 256, 64},
+		{"landscape", 300, 100, 64},
+		{"portrait", 100, 300, 64},
+		{"uneven", 129, 97, 64},
+		{"smaller", 40, 50, 40},
+		{"exact", 64, 64, 64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Make(encode(t, tt.w, tt.h), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := decode(t, out).Bounds(); b.Dx() != tt.want || b.Dy() != tt.want {
+				t.Errorf("size = %v, want %d×%d", b.Size(), tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeCropsCenter(t *testing.T) {
+	// The centered square of a 400×100 image is its middle, half red and
+	// half blue, without being squeezed
+	out, err := Make(encode(t, 400, 100), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := decode(t, out)
+
+	for _, tt := range []struct {
+		x    int
+		want color.RGBA
+	}{
+		{0, color.RGBA{R: 255, A: 255}},
+		{4, color.RGBA{R: 255, A: 255}},
+		{5, color.RGBA{B: 255, A: 255}},
+		{9, color.RGBA{B: 255, A: 255}},
+	} {
+		if got := color.RGBAModel.Convert(img.At(tt.x, 5)); got != tt.want {
+			t.Errorf("pixel %d = %v, want %v", tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestMakeAverages(t *testing.T) {
+	// Each thumbnail pixel covers two source columns, one red and one blue
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := range 4 {
+		for x := range 4 {
+			if x%2 == 0 {
+				img.SetRGBA(x, y, color.RGBA{R: 200, A: 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{B: 100, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Make(buf.Bytes(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := color.RGBA{R: 100, B: 50, A: 255}
+	if got := color.RGBAModel.Convert(decode(t, out).At(1, 1)); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
+
+func TestMakeFormats(t *testing.T) {
+	src := image.NewPaletted(image.Rect(0, 0, 80, 80), color.Palette{color.Black, color.White})
+
+	var gifData, jpegData bytes.Buffer
+	if err := gif.Encode(&gifData, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"GIF": gifData.Bytes(), "JPEG": jpegData.Bytes()} {
+		out, err := Make(data, 32)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if b := decode(t, out).Bounds(); b.Dx() != 32 {
+			t.Errorf("%s: size = %v, want 32×32", name, b.Size())
+		}
+	}
+}
+
+func TestMakeRejects(t *testing.T) {
+	// A PNG header declaring more than maxPixels; the image data is never
+	// read
+	huge := encode(t, 1, 1)
+	copy(huge[16:24], []byte{0, 0, 0x20, 0, 0, 0, 0x20, 0}) // 8192×8192
+	binary.BigEndian.PutUint32(huge[29:33], crc32.ChecksumIEEE(huge[12:29]))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		tooBig bool
+	}{
+		{"too many pixels", huge, true},
+		{"text", []byte("<html>not an image</html>"), false},
+		{"empty", nil, false},
+		{"truncated", encode(t, 100, 100)[:60], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Make(tt.data, 64)
+			if err == nil {
+				t.Fatalf("made a %d byte thumbnail, want an error", len(out))
+			}
+			if errors.Is(err, ErrTooLarge) != tt.tooBig {
+				t.Errorf("err = %v, ErrTooLarge %v", err, tt.tooBig)
+			}
+		})
+	}
+}
@@ -1,109 +1,81 @@
 package ts6
 
 import (
+	"context"
 	"fmt"
-	"strings"
+	"strconv"
 	"ts6-viewer/internal/config"
 )
 
 type Channel struct {
-	CID                           string
-	PID                           string
-	ChannelOrder                  string
-	Name                          string
-	Topic                         string
-	FlagPermanent                 string
-	FlagSemiPermanent             string
-	FlagDefault                   string
-	FlagPassword                  string
-	FlagMaxClientsUnlimited       string
-	FlagMaxFamilyClientsUnlimited string
-	MaxClients                    string
-	MaxFamilyClients              string
-	NeededTalkPower               string
-	Codec                         string
-	CodecQuality                  string
-	TotalClients                  string
-	IconID                        string
-	SecondsEmpty                  string
+	CID                           int    `ts6:"cid"`
+	PID                           int    `ts6:"pid"`
+	ChannelOrder                  int    `ts6:"channel_order"`
+	Name                          string `ts6:"channel_name"`
+	Topic                         string `ts6:"channel_topic"`
+	FlagPermanent                 bool   `ts6:"channel_flag_permanent"`
+	FlagSemiPermanent             bool   `ts6:"channel_flag_semi_permanent"`
+	FlagDefault                   bool   `ts6:"channel_flag_default"`
+	FlagPassword                  bool   `ts6:"channel_flag_password"`
+	FlagMaxClientsUnlimited       bool   `ts6:"channel_flag_maxclients_unlimited"`
+	FlagMaxFamilyClientsUnlimited bool   `ts6:"channel_flag_maxfamilyclients_unlimited"`
+	MaxClients                    int    `ts6:"channel_maxclients"`
+	MaxFamilyClients              int    `ts6:"channel_maxfamilyclients"`
+	NeededTalkPower               int    `ts6:"channel_needed_talk_power"`
+	Codec                         int    `ts6:"channel_codec"`
+	CodecQuality                  int    `ts6:"channel_codec_quality"`
+	TotalClients                  int    `ts6:"total_clients"`
+	IconID                        int64  `ts6:"channel_icon_id"`
+	SecondsEmpty                  int    `ts6:"seconds_empty"`
 }
 
 // GetChannelList retrieves all channels using ServerQuery (SSH)
-func GetChannelList(cfg *config.Config, ssh *SSHClient) ([]Channel, error) {
+func GetChannelList(ctx context.Context, cfg *config.Config, ssh *SSHClient) ([]Channel, error) {
 
-	raw, err := ssh.exec("channellist -topic -flags -limits -voice -icon -secondsempty")
+	raw, err := ssh.ExecContext(ctx, command("channellist", nil, "-topic", "-flags", "-limits", "-voice", "-icon", "-secondsempty"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute channellist: %w", err)
 	}
 
-	parts := strings.Split(strings.TrimSpace(raw), "|")
-	channels := make([]Channel, 0, len(parts))
-
-	for _, p := range parts {
-
-		fields := strings.Fields(p)
-		ch := Channel{}
-
-		for _, f := range fields {
-			if !strings.Contains(f, "=") {
-				continue
-			}
-
-			kv := strings.SplitN(f, "=", 2)
-			key := kv[0]
-			val := UnescapeTS6(kv[1])
-
-			switch key {
-
-			case "cid":
-				ch.CID = val
-			case "pid":
-				ch.PID = val
-			case "channel_order":
-				ch.ChannelOrder = val
-			case "channel_name":
-				ch.Name = val
-			case "channel_topic":
-				ch.Topic = val
+	var channels []Channel
+	if err := Unmarshal(raw, &channels); err != nil {
+		return nil, fmt.Errorf("failed to parse channellist: %w", err)
+	}
 
-			case "channel_flag_permanent":
-				ch.FlagPermanent = val
-			case "channel_flag_semi_permanent":
-				ch.FlagSemiPermanent = val
-			case "channel_flag_default":
-				ch.FlagDefault = val
-			case "channel_flag_password":
-				ch.FlagPassword = val
-			case "channel_flag_maxclients_unlimited":
-				ch.FlagMaxClientsUnlimited = val
-			case "channel_flag_maxfamilyclients_unlimited":
-				ch.FlagMaxFamilyClientsUnlimited = val
+	return channels, nil
+}
 
-			case "channel_maxclients":
-				ch.MaxClients = val
-			case "channel_maxfamilyclients":
-				ch.MaxFamilyClients = val
+// ChannelInfo holds the channelinfo properties channellist does not
+// return.
+type ChannelInfo struct {
+	Description string `ts6:"channel_description"`
+}
 
-			case "channel_needed_talk_power":
-				ch.NeededTalkPower = val
+// GetChannelInfo retrieves the properties of one channel with channelinfo.
+func GetChannelInfo(ctx context.Context, cfg *config.Config, ssh *SSHClient, cid int) (*ChannelInfo, error) {
+	raw, err := ssh.ExecContext(ctx, command("channelinfo", map[string]string{"cid": strconv.Itoa(cid)}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute channelinfo: %w", err)
+	}
 
-			case "channel_codec":
-				ch.Codec = val
-			case "channel_codec_quality":
-				ch.CodecQuality = val
-			case "total_clients":
-				ch.TotalClients = val
+	info := &ChannelInfo{}
+	if err := Unmarshal(raw, info); err != nil {
+		return nil, fmt.Errorf("failed to parse channelinfo: %w", err)
+	}
 
-			case "channel_icon_id":
-				ch.IconID = val
+	return info, nil
+}
 
-			case "seconds_empty":
-				ch.SecondsEmpty = val
-			}
-		}
+// ChannelInfo fetches the properties of a channel over the supervised
+// command connection.
+func (w *Watcher) ChannelInfo(ctx context.Context, cid int) (*ChannelInfo, error) {
+	var info *ChannelInfo
 
-		channels = append(channels, ch)
-	}
+	err := w.supervisor.Do(ctx, w.cfg.Teamspeak6.ServerID, func(sshClient *SSHClient) error {
+		var err error
+		info, err = GetChannelInfo(ctx, w.cfg, sshClient, cid)
+		return err
+	})
 
-	return channels, nil
+	return info, err
 }
@@ -1,136 +1,141 @@
 package ts6
 
 import (
+	"context"
 	"fmt"
-	"strings"
+	"strconv"
+	"time"
 	"ts6-viewer/internal/config"
 )
 
 type Client struct {
-	CLID             string
-	CID              string
-	DatabaseID       string
-	Nickname         string
-	Type             string
-	UniqueIdentifier string
-
-	Away        string
-	AwayMessage string
-
-	InputMuted      string
-	OutputMuted     string
-	OutputOnlyMuted string
-	InputHardware   string
-	OutputHardware  string
-	TalkPower       string
-	IsTalking       string
-
-	ServerGroups   string
-	ChannelGroupID string
-
-	IdleTime       string
-	ConnectionTime string
-
-	Country string
-	IconID  string
-
-	Version  string
-	Platform string
+	CLID             int    `ts6:"clid"`
+	CID              int    `ts6:"cid"`
+	DatabaseID       int    `ts6:"client_database_id"`
+	Nickname         string `ts6:"client_nickname"`
+	Type             int    `ts6:"client_type"`
+	UniqueIdentifier string `ts6:"client_unique_identifier"`
+
+	Away        bool   `ts6:"client_away"`
+	AwayMessage string `ts6:"client_away_message"`
+
+	InputMuted      bool `ts6:"client_input_muted"`
+	OutputMuted     bool `ts6:"client_output_muted"`
+	OutputOnlyMuted bool `ts6:"client_outputonly_muted"`
+	InputHardware   bool `ts6:"client_input_hardware"`
+	OutputHardware  bool `ts6:"client_output_hardware"`
+	TalkPower       int  `ts6:"client_talk_power"`
+	IsTalking       bool `ts6:"client_is_talking"`
+
+	ServerGroups   []int `ts6:"client_servergroups"`
+	ChannelGroupID int   `ts6:"client_channel_group_id"`
+
+	IdleTime       time.Duration `ts6:"client_idle_time,ms"`
+	ConnectionTime time.Duration `ts6:"client_connection_connected_time,ms"`
+
+	Country string `ts6:"client_country"`
+	IconID  int64  `ts6:"client_icon_id"`
+
+	Version  string `ts6:"client_version"`
+	Platform string `ts6:"client_platform"`
 }
 
-func GetClientList(cfg *config.Config, ssh *SSHClient) ([]Client, error) {
+// clientTypeQuery is the client_type of ServerQuery clients.
+const clientTypeQuery = 1
 
-	voiceCmd := ""
-	if cfg.Teamspeak6.EnableVoiceStatus == "true" {
-		voiceCmd = "-voice"
-	}
+func GetClientList(ctx context.Context, cfg *config.Config, ssh *SSHClient) ([]Client, error) {
+
+	voice := cfg.Teamspeak6.EnableVoiceStatus == "true"
 
-	raw, err := ssh.exec("clientlist -uid -away -groups -times -info -country -icon " + voiceCmd)
+	// -voice is also where the talk power comes from, which sorting needs
+	// with or without voice status
+	flags := []string{"-uid", "-away", "-groups", "-times", "-info", "-country", "-icon", "-voice"}
+
+	raw, err := ssh.ExecContext(ctx, command("clientlist", nil, flags...))
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute clientlist: %w", err)
 	}
 
-	parts := strings.Split(strings.TrimSpace(raw), "|")
-	clients := make([]Client, 0, len(parts))
-
-	for _, p := range parts {
-
-		fields := strings.Fields(p)
-		cl := Client{}
-
-		for _, f := range fields {
-			if !strings.Contains(f, "=") {
-				continue
-			}
-
-			kv := strings.SplitN(f, "=", 2)
-			key := kv[0]
-			val := UnescapeTS6(kv[1])
-
-			switch key {
-
-			case "clid":
-				cl.CLID = val
-			case "cid":
-				cl.CID = val
-			case "client_database_id":
-				cl.DatabaseID = val
-			case "client_nickname":
-				cl.Nickname = val
-			case "client_type":
-				cl.Type = val
-			case "client_unique_identifier":
-				cl.UniqueIdentifier = val
-
-			case "client_away":
-				cl.Away = val
-			case "client_away_message":
-				cl.AwayMessage = val
-
-			case "client_input_muted":
-				cl.InputMuted = val
-			case "client_output_muted":
-				cl.OutputMuted = val
-			case "client_outputonly_muted":
-				cl.OutputOnlyMuted = val
-			case "client_input_hardware":
-				cl.InputHardware = val
-			case "client_output_hardware":
-				cl.OutputHardware = val
-			case "client_talk_power":
-				cl.TalkPower = val
-			case "client_is_talking":
-				cl.IsTalking = val
-
-			case "client_servergroups":
-				cl.ServerGroups = val
-			case "client_channel_group_id":
-				cl.ChannelGroupID = val
-
-			case "client_idle_time":
-				cl.IdleTime = val
-			case "client_connection_connected_time":
-				cl.ConnectionTime = val
-
-			case "client_country":
-				cl.Country = val
-			case "client_icon_id":
-				cl.IconID = val
-
-			case "client_version":
-				cl.Version = val
-			case "client_platform":
-				cl.Platform = val
-			}
-		}
+	var all []Client
+	if err := Unmarshal(raw, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse clientlist: %w", err)
+	}
 
+	clients := make([]Client, 0, len(all))
+	for _, cl := range all {
 		// Query Clients rausfiltern
-		if cl.Type == "1" {
+		if cl.Type == clientTypeQuery {
 			continue
 		}
 
+		if !voice {
+			clearVoiceStatus(&cl)
+		}
+
 		clients = append(clients, cl)
 	}
 
 	return clients, nil
 }
+
+// clearVoiceStatus resets the -voice properties to "not muted, hardware
+// present", which is what a client looks like when voice status is
+// disabled. The talk power is kept: it is not shown, but clients may be
+// sorted by it, and notifications carry it either way.
+func clearVoiceStatus(cl *Client) {
+	cl.InputMuted = false
+	cl.OutputMuted = false
+	cl.OutputOnlyMuted = false
+	cl.InputHardware = true
+	cl.OutputHardware = true
+	cl.IsTalking = false
+}
+
+// ClientAvatar holds the clientinfo properties that locate the avatar of a
+// client in the file storage.
+type ClientAvatar struct {
+	UniqueIdentifier string `ts6:"client_unique_identifier"`
+
+	// Flag is the MD5 of the avatar, empty if the client has none, so it
+	// changes whenever the avatar does.
+	Flag string `ts6:"client_flag_avatar"`
+
+	// UIDHash names the avatar file, see AvatarName.
+	UIDHash string `ts6:"client_base64HashClientUID"`
+}
+
+// AvatarName returns the file name of the avatar with the given
+// client_base64HashClientUID. Avatars are stored in channel 0.
+func AvatarName(uidHash string) string {
+	return "/avatar_" + uidHash
+}
+
+// GetClientAvatar retrieves the avatar properties of a client with
+// clientinfo.
+func GetClientAvatar(ctx context.Context, cfg *config.Config, ssh *SSHClient, clid int) (*ClientAvatar, error) {
+	raw, err := ssh.ExecContext(ctx, command("clientinfo", map[string]string{"clid": strconv.Itoa(clid)}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute clientinfo: %w", err)
+	}
+
+	avatar := &ClientAvatar{}
+	if err := Unmarshal(raw, avatar); err != nil {
+		return nil, fmt.Errorf("failed to parse clientinfo: %w", err)
+	}
+
+	return avatar, nil
+}
+
+// ClientAvatar fetches the avatar properties of a client over the
+// supervised command connection.
+func (w *Watcher) ClientAvatar(ctx context.Context, clid int) (*ClientAvatar, error) {
+	var avatar *ClientAvatar
+
+	err := w.supervisor.Do(ctx, w.cfg.Teamspeak6.ServerID, func(sshClient *SSHClient) error {
+		var err error
+		avatar, err = GetClientAvatar(ctx, w.cfg, sshClient, clid)
+		return err
+	})
+
+	return avatar, err
+}
@@ -0,0 +1,58 @@
+package ts6
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Talk power must be the same after a resync as after a notification, with
+// and without voice status, or sorting by it changes between the two.
+func TestClientTalkPowerWithoutVoiceStatus(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	cfg.Teamspeak6.EnableVoiceStatus = "false"
+	c := newTestClient(t, cfg)
+
+	clients, err := GetClientList(context.Background(), cfg, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || clients[0].Nickname != "Alice" {
+		t.Fatalf("clients = %+v", clients)
+	}
+	if clients[0].TalkPower != 75 {
+		t.Errorf("talk power after resync = %d, want 75", clients[0].TalkPower)
+	}
+	if !clients[0].InputHardware || clients[0].IsTalking {
+		t.Errorf("voice status not cleared: %+v", clients[0])
+	}
+
+	for _, cmd := range srv.Commands() {
+		if strings.HasPrefix(cmd, "clientlist") && !strings.Contains(cmd, "-voice") {
+			t.Errorf("%q does not fetch the talk power", cmd)
+		}
+	}
+
+	m := NewModel(false)
+	m.Replace(nil, clients, &ServerInfo{})
+
+	n, ok := ParseNotification(`notifycliententerview cfid=0 ctid=1 reasonid=0 clid=9 client_nickname=Bob client_type=0 client_talk_power=50 client_input_muted=1`)
+	if !ok {
+		t.Fatal("not a notification")
+	}
+	m.Apply(n)
+
+	_, clients, _, _ = m.Snapshot()
+	for _, cl := range clients {
+		if cl.Nickname == "Bob" {
+			if cl.TalkPower != 50 {
+				t.Errorf("talk power after notification = %d, want 50", cl.TalkPower)
+			}
+			if cl.InputMuted {
+				t.Error("voice status not cleared after notification")
+			}
+			return
+		}
+	}
+	t.Fatalf("Bob missing from %+v", clients)
+}
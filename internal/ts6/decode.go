@@ -0,0 +1,189 @@
+package ts6
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Unmarshal decodes a ServerQuery response into v, which must be a pointer
+// to a struct or to a slice of structs. A struct receives the first entry of
+// the response; a slice receives one element per "|"-separated entry, and
+// stays empty for an empty response.
+//
+// Struct fields are mapped with the "ts6" tag, e.g.
+//
+//	Nickname     string        `ts6:"client_nickname"`
+//	ServerGroups []int         `ts6:"client_servergroups"`
+//	IdleTime     time.Duration `ts6:"client_idle_time,ms"`
+//
+// Supported field types are string, int, int64, bool, time.Duration and
+// []int (comma separated). Durations are read as seconds unless the tag has
+// the "ms" option. Empty numbers, booleans and durations are zero. Keys
+// without a matching field are ignored and fields without a matching key
+// are left untouched.
+func Unmarshal(raw string, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("ts6: Unmarshal needs a non-nil pointer, got %T", v)
+	}
+
+	raw = strings.TrimSpace(raw)
+	entries := strings.Split(raw, "|")
+
+	switch elem := rv.Elem(); {
+	case elem.Kind() == reflect.Struct:
+		return decodeFields(parseFields(entries[0]), elem)
+
+	case elem.Kind() == reflect.Slice && elem.Type().Elem().Kind() == reflect.Struct:
+		out := reflect.MakeSlice(elem.Type(), 0, len(entries))
+		if raw == "" {
+			elem.Set(out)
+			return nil
+		}
+		for _, entry := range entries {
+			item := reflect.New(elem.Type().Elem()).Elem()
+			if err := decodeFields(parseFields(entry), item); err != nil {
+				return err
+			}
+			out = reflect.Append(out, item)
+		}
+		elem.Set(out)
+		return nil
+
+	default:
+		return fmt.Errorf("ts6: Unmarshal needs a pointer to a struct or slice of structs, got %T", v)
+	}
+}
+
+// UnmarshalFields decodes already split and unescaped key/value pairs, such
+// as a notification entry, into the struct pointed to by v.
+func UnmarshalFields(fields map[string]string, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ts6: UnmarshalFields needs a non-nil struct pointer, got %T", v)
+	}
+
+	return decodeFields(fields, rv.Elem())
+}
+
+// fieldInfo describes how a ServerQuery key maps onto a struct field.
+type fieldInfo struct {
+	index  int
+	millis bool
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	fieldCache sync.Map // reflect.Type → map[string]fieldInfo
+)
+
+// fieldsOf returns the ServerQuery key mapping of struct type t.
+func fieldsOf(t reflect.Type) map[string]fieldInfo {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.(map[string]fieldInfo)
+	}
+
+	fields := make(map[string]fieldInfo)
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("ts6")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		key, opts, _ := strings.Cut(tag, ",")
+		fields[key] = fieldInfo{index: i, millis: opts == "ms"}
+	}
+
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func decodeFields(kv map[string]string, rv reflect.Value) error {
+	fields := fieldsOf(rv.Type())
+
+	for key, val := range kv {
+		fi, ok := fields[key]
+		if !ok {
+			continue
+		}
+
+		if err := setValue(rv.Field(fi.index), val, fi.millis); err != nil {
+			return fmt.Errorf("ts6: cannot decode %s=%q: %w", key, val, err)
+		}
+	}
+
+	return nil
+}
+
+func setValue(f reflect.Value, val string, millis bool) error {
+	if f.Type() == durationType {
+		if val == "" {
+			f.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		unit := time.Second
+		if millis {
+			unit = time.Millisecond
+		}
+		f.SetInt(n * int64(unit))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+
+	case reflect.Int, reflect.Int64:
+		if val == "" {
+			f.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+
+	case reflect.Bool:
+		if val == "" {
+			f.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.Int {
+			return fmt.Errorf("unsupported slice type %s", f.Type())
+		}
+		var ints []int
+		for _, part := range strings.Split(val, ",") {
+			if part == "" {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return err
+			}
+			ints = append(ints, n)
+		}
+		f.Set(reflect.ValueOf(ints))
+
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package ts6
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type decodeTest struct {
+	Name     string        `ts6:"name"`
+	Count    int           `ts6:"count"`
+	Bytes    int64         `ts6:"bytes"`
+	Flag     bool          `ts6:"flag"`
+	Uptime   time.Duration `ts6:"uptime"`
+	Idle     time.Duration `ts6:"idle,ms"`
+	Groups   []int         `ts6:"groups"`
+	Skipped  string        `ts6:"-"`
+	Untagged string
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want decodeTest
+	}{
+		{"string", `name=Lobby\s\p\sAFK`, decodeTest{Name: "Lobby | AFK"}},
+		{"int", `count=-3`, decodeTest{Count: -3}},
+		{"int64", `bytes=9007199254740993`, decodeTest{Bytes: 9007199254740993}},
+		{"bool", `flag=1`, decodeTest{Flag: true}},
+		{"seconds", `uptime=90`, decodeTest{Uptime: 90 * time.Second}},
+		{"milliseconds", `idle=1500`, decodeTest{Idle: 1500 * time.Millisecond}},
+		{"int list", `groups=6,9,`, decodeTest{Groups: []int{6, 9}}},
+		{"empty values", `name count= bytes= flag= uptime= idle= groups=`, decodeTest{}},
+		{"unknown keys", `cid=1 name=Lobby Skipped=x Untagged=y`, decodeTest{Name: "Lobby"}},
+		{"first entry only", `name=a|name=b`, decodeTest{Name: "a"}},
+		{"trailing newline", "count=2\n", decodeTest{Count: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got decodeTest
+			if err := Unmarshal(tt.raw, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalList(t *testing.T) {
+	var got []decodeTest
+	if err := Unmarshal(`name=a count=1|name=b|count=3`, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []decodeTest{{Name: "a", Count: 1}, {Name: "b"}, {Count: 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// An empty list is a non-nil empty slice, not one zero entry
+	for _, raw := range []string{"", "\n\r"} {
+		got = nil
+		if err := Unmarshal(raw, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || len(got) != 0 {
+			t.Errorf("Unmarshal(%q) = %#v, want an empty slice", raw, got)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		raw string
+		err string
+	}{
+		{`count=12a`, `count="12a"`},
+		{`bytes=1.5`, `bytes="1.5"`},
+		{`flag=yes`, `flag="yes"`},
+		{`uptime=-`, `uptime="-"`},
+		{`groups=1,x`, `groups="1,x"`},
+	}
+
+	for _, tt := range tests {
+		var got decodeTest
+		err := Unmarshal(tt.raw, &got)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Unmarshal(%q): err = %v, want one naming %s", tt.raw, err, tt.err)
+		}
+
+		var list []decodeTest
+		if err := Unmarshal("name=ok|"+tt.raw, &list); err == nil {
+			t.Errorf("Unmarshal(%q) into a slice: no error", tt.raw)
+		}
+	}
+
+	var s decodeTest
+	for _, v := range []any{s, (*decodeTest)(nil), new(int), new([]int)} {
+		if err := Unmarshal("name=x", v); err == nil {
+			t.Errorf("Unmarshal into %T: no error", v)
+		}
+	}
+}
+
+func TestUnmarshalFields(t *testing.T) {
+	got := decodeTest{Name: "kept", Count: 7}
+	if err := UnmarshalFields(map[string]string{"count": "8", "idle": "250"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (decodeTest{Name: "kept", Count: 8, Idle: 250 * time.Millisecond}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if err := UnmarshalFields(map[string]string{"flag": "2"}, &got); err == nil {
+		t.Error("malformed bool: no error")
+	}
+}
@@ -0,0 +1,140 @@
+package ts6
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerQuery error IDs with a sentinel error.
+const (
+	errIDOK                      = 0
+	errIDInvalidServerID         = 1024
+	errIDInvalidLogin            = 520
+	errIDFlood                   = 524
+	errIDInvalidClientID         = 512
+	errIDInvalidChannelID        = 768
+	errIDFileNotFound            = 2051
+	errIDInsufficientPermissions = 2568
+)
+
+var (
+	// ErrFlood reports that the server rejected a command because the query
+	// client sent too many commands (flood protection).
+	ErrFlood = errors.New("ts6: flood protection triggered")
+
+	// ErrInsufficientPermissions reports that the ServerQuery login lacks a
+	// permission the command needs.
+	ErrInsufficientPermissions = errors.New("ts6: insufficient permissions")
+
+	// ErrInvalidServerID reports that the selected virtual server does not
+	// exist.
+	ErrInvalidServerID = errors.New("ts6: invalid server id")
+
+	// ErrInvalidLogin reports that the ServerQuery user or password was
+	// rejected.
+	ErrInvalidLogin = errors.New("ts6: invalid login")
+
+	// ErrInvalidClientID reports that no client with the given ID is
+	// connected, e.g. because it left in the meantime.
+	ErrInvalidClientID = errors.New("ts6: invalid client id")
+
+	// ErrInvalidChannelID reports that no channel with the given ID
+	// exists, e.g. because it was deleted in the meantime.
+	ErrInvalidChannelID = errors.New("ts6: invalid channel id")
+
+	// ErrFileNotFound reports that the requested file does not exist in
+	// the file storage of the virtual server.
+	ErrFileNotFound = errors.New("ts6: file not found")
+
+	// ErrConnectionLost reports that the ServerQuery connection failed or
+	// could not be established.
+	ErrConnectionLost = errors.New("ts6: connection lost")
+)
+
+// sentinels maps error IDs to the sentinel a QueryError matches.
+var sentinels = map[int]error{
+	errIDInvalidServerID:         ErrInvalidServerID,
+	errIDInvalidLogin:            ErrInvalidLogin,
+	errIDFlood:                   ErrFlood,
+	errIDInvalidClientID:         ErrInvalidClientID,
+	errIDInvalidChannelID:        ErrInvalidChannelID,
+	errIDFileNotFound:            ErrFileNotFound,
+	errIDInsufficientPermissions: ErrInsufficientPermissions,
+}
+
+// QueryError is a non-zero "error id=..." status line returned by the
+// server. Use errors.Is with the sentinel errors above to check for well
+// known IDs.
+type QueryError struct {
+	ID           int    `ts6:"id"`
+	Msg          string `ts6:"msg"`
+	ExtraMsg     string `ts6:"extra_msg"`
+	FailedPermID int    `ts6:"failed_permid"`
+}
+
+func (e *QueryError) Error() string {
+	msg := fmt.Sprintf("ts6: error id=%d msg=%s", e.ID, e.Msg)
+	if e.ExtraMsg != "" {
+		msg += " extra_msg=" + e.ExtraMsg
+	}
+	if e.FailedPermID != 0 {
+		msg += " failed_permid=" + strconv.Itoa(e.FailedPermID)
+	}
+	return msg
+}
+
+// Is reports whether the error ID corresponds to target.
+func (e *QueryError) Is(target error) bool {
+	return sentinels[e.ID] == target
+}
+
+var floodWaitRegex = regexp.MustCompile(`wait\s+(\d+)\s*(ms|milliseconds?|s|seconds?)\b`)
+
+// RetryAfter returns how long the server asks to wait before the next
+// command, as announced in the message of a flood error. It is zero if the
+// server does not say.
+func (e *QueryError) RetryAfter() time.Duration {
+	m := floodWaitRegex.FindStringSubmatch(e.ExtraMsg + " " + e.Msg)
+	if len(m) != 3 {
+		return 0
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+
+	if strings.HasPrefix(m[2], "s") {
+		return time.Duration(n) * time.Second
+	}
+	return time.Duration(n) * time.Millisecond
+}
+
+// isStatusLine reports whether line terminates a command response.
+func isStatusLine(line string) bool {
+	return strings.HasPrefix(line, "error id=")
+}
+
+// parseStatus converts a status line into an error. It returns nil for
+// "error id=0".
+func parseStatus(line string) error {
+	e := &QueryError{}
+	if err := UnmarshalFields(parseFields(strings.TrimPrefix(line, "error ")), e); err != nil {
+		return fmt.Errorf("malformed status line %q: %w", line, err)
+	}
+
+	if e.ID == errIDOK {
+		return nil
+	}
+	return e
+}
+
+// connectionLost wraps a transport error so that it matches
+// ErrConnectionLost.
+func connectionLost(err error) error {
+	return fmt.Errorf("%w: %w", ErrConnectionLost, err)
+}
@@ -0,0 +1,175 @@
+package ts6
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ts6-viewer/internal/config"
+)
+
+const (
+	// defaultFileTransferPort is used when ftinitdownload names no port.
+	defaultFileTransferPort = "30033"
+
+	// transferTimeout bounds connecting to the file transfer port and
+	// reading a file.
+	transferTimeout = 30 * time.Second
+
+	// minRemoteIconID is the lowest icon ID stored on the server. Lower IDs
+	// are built into the TeamSpeak client.
+	minRemoteIconID = 1000
+)
+
+// clientFTID numbers the transfers started by this process.
+var clientFTID atomic.Int64
+
+// FileTransfer is the answer to ftinitdownload: the key to present on the
+// file transfer port and the size of the file.
+type FileTransfer struct {
+	ClientFTID int    `ts6:"clientftfid"`
+	ServerFTID int    `ts6:"serverftfid"`
+	Key        string `ts6:"ftkey"`
+	Port       string `ts6:"port"`
+	Size       int64  `ts6:"size"`
+	IP         string `ts6:"ip"`
+
+	// Status and Msg are set instead of the key when the server refuses
+	// the transfer.
+	Status int    `ts6:"status"`
+	Msg    string `ts6:"msg"`
+}
+
+// IconName returns the file name of an icon ID. The server reports icon
+// IDs as signed or unsigned 32-bit values; both name the same file.
+func IconName(id int64) string {
+	return "/icon_" + strconv.FormatUint(uint64(uint32(id)), 10)
+}
+
+// RemoteIcon reports whether the icon ID refers to a file on the server
+// rather than to no icon or an icon built into the client.
+func RemoteIcon(id int64) bool {
+	return uint32(id) >= minRemoteIconID
+}
+
+// InitDownload asks the server to provide the file name of channel cid,
+// 0 for icons and avatars, for download.
+func InitDownload(ctx context.Context, cfg *config.Config, ssh *SSHClient, cid int, name string) (*FileTransfer, error) {
+	raw, err := ssh.ExecContext(ctx, command("ftinitdownload", map[string]string{
+		"clientftfid": strconv.FormatInt(clientFTID.Add(1), 10),
+		"name":        name,
+		"cid":         strconv.Itoa(cid),
+		"cpw":         "",
+		"seekpos":     "0",
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute ftinitdownload: %w", err)
+	}
+
+	ft := &FileTransfer{}
+	if err := Unmarshal(raw, ft); err != nil {
+		return nil, fmt.Errorf("failed to parse ftinitdownload: %w", err)
+	}
+
+	if ft.Status != errIDOK {
+		return nil, fmt.Errorf("ftinitdownload %s: %w", name, &QueryError{ID: ft.Status, Msg: ft.Msg})
+	}
+	if ft.Key == "" {
+		return nil, fmt.Errorf("ftinitdownload %s: no transfer key", name)
+	}
+
+	return ft, nil
+}
+
+// Download reads the file of a transfer started with InitDownload. The
+// transfer port is taken from cfg if set, otherwise from ft; the host
+// likewise, falling back to the ServerQuery host when the server answers
+// with a wildcard address. Files larger than maxSize are refused, as are
+// negative sizes.
+func Download(ctx context.Context, cfg *config.Config, ft *FileTransfer, maxSize int64) ([]byte, error) {
+	if ft.Size < 0 {
+		return nil, fmt.Errorf("file transfer: invalid size %d", ft.Size)
+	}
+	if ft.Size > maxSize {
+		return nil, fmt.Errorf("file transfer: file of %d bytes exceeds %d", ft.Size, maxSize)
+	}
+
+	addr := net.JoinHostPort(transferHost(cfg, ft), transferPort(cfg, ft))
+
+	ctx, cancel := context.WithTimeout(ctx, transferTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("file transfer: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := io.WriteString(conn, ft.Key); err != nil {
+		return nil, fmt.Errorf("file transfer: send key: %w", err)
+	}
+
+	data := make([]byte, ft.Size)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, fmt.Errorf("file transfer: read %d bytes: %w", ft.Size, err)
+	}
+
+	return data, nil
+}
+
+// transferHost is the host to fetch a transfer from.
+func transferHost(cfg *config.Config, ft *FileTransfer) string {
+	if cfg.Teamspeak6.FileTransferHost != "" {
+		return cfg.Teamspeak6.FileTransferHost
+	}
+
+	// TS6 may list several addresses, e.g. "0.0.0.0,::"
+	for _, ip := range strings.Split(ft.IP, ",") {
+		addr := net.ParseIP(strings.TrimSpace(ip))
+		if addr != nil && !addr.IsUnspecified() {
+			return addr.String()
+		}
+	}
+
+	return cfg.Teamspeak6.Host
+}
+
+// transferPort is the port to fetch a transfer from.
+func transferPort(cfg *config.Config, ft *FileTransfer) string {
+	switch {
+	case cfg.Teamspeak6.FileTransferPort != "":
+		return cfg.Teamspeak6.FileTransferPort
+	case ft.Port != "" && ft.Port != "0":
+		return ft.Port
+	default:
+		return defaultFileTransferPort
+	}
+}
+
+// DownloadFile fetches a file of the virtual server. Only ftinitdownload
+// runs on the shared command connection; the transfer itself does not
+// hold it.
+func (w *Watcher) DownloadFile(ctx context.Context, cid int, name string, maxSize int64) ([]byte, error) {
+	var ft *FileTransfer
+
+	err := w.supervisor.Do(ctx, w.cfg.Teamspeak6.ServerID, func(sshClient *SSHClient) error {
+		var err error
+		ft, err = InitDownload(ctx, w.cfg, sshClient, cid, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return Download(ctx, w.cfg, ft, maxSize)
+}
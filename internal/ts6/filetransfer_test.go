@@ -0,0 +1,73 @@
+package ts6
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDownload(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	icon := bytes.Repeat([]byte("\x89PNG"), 256)
+	srv.SetFile(IconName(3000), icon)
+
+	tests := []struct {
+		name    string
+		file    string
+		maxSize int64
+		wantErr string
+		wantIs  error
+	}{
+		{name: "happy path", file: IconName(3000), maxSize: 1 << 20},
+		{name: "exactly the limit", file: IconName(3000), maxSize: int64(len(icon))},
+		{name: "over the limit", file: IconName(3000), maxSize: int64(len(icon)) - 1, wantErr: "exceeds"},
+		{name: "refused", file: IconName(4000), maxSize: 1 << 20, wantIs: ErrFileNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := srv.Downloads()
+
+			ft, err := InitDownload(context.Background(), cfg, c, 0, tt.file)
+			if tt.wantIs != nil {
+				if !errors.Is(err, tt.wantIs) {
+					t.Fatalf("InitDownload: err = %v, want %v", err, tt.wantIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InitDownload: %v", err)
+			}
+
+			data, err := Download(context.Background(), cfg, ft, tt.maxSize)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Download: err = %v, want %q", err, tt.wantErr)
+				}
+				if n := srv.Downloads() - before; n != 0 {
+					t.Errorf("transferred %d files for a refused download", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if !bytes.Equal(data, icon) {
+				t.Errorf("downloaded %d bytes, want the %d of the icon", len(data), len(icon))
+			}
+		})
+	}
+}
+
+func TestDownloadRejectsNegativeSize(t *testing.T) {
+	_, cfg := newTestServer(t)
+
+	_, err := Download(context.Background(), cfg, &FileTransfer{Key: "key", Size: -1}, 1<<20)
+	if err == nil || !strings.Contains(err.Error(), "invalid size") {
+		t.Fatalf("err = %v, want invalid size", err)
+	}
+}
@@ -0,0 +1,64 @@
+package ts6
+
+import (
+	"context"
+	"fmt"
+	"ts6-viewer/internal/config"
+)
+
+// Group types as returned by servergrouplist and channelgrouplist.
+const (
+	GroupTypeTemplate = 0
+	GroupTypeRegular  = 1
+	GroupTypeQuery    = 2
+)
+
+type ServerGroup struct {
+	SGID     int    `ts6:"sgid"`
+	Name     string `ts6:"name"`
+	Type     int    `ts6:"type"`
+	IconID   int64  `ts6:"iconid"`
+	SortID   int    `ts6:"sortid"`
+	NameMode int    `ts6:"namemode"`
+}
+
+type ChannelGroup struct {
+	CGID     int    `ts6:"cgid"`
+	Name     string `ts6:"name"`
+	Type     int    `ts6:"type"`
+	IconID   int64  `ts6:"iconid"`
+	SortID   int    `ts6:"sortid"`
+	NameMode int    `ts6:"namemode"`
+}
+
+// GetServerGroupList retrieves the server groups of the selected virtual
+// server.
+func GetServerGroupList(ctx context.Context, cfg *config.Config, ssh *SSHClient) ([]ServerGroup, error) {
+	raw, err := ssh.ExecContext(ctx, command("servergrouplist", nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute servergrouplist: %w", err)
+	}
+
+	var groups []ServerGroup
+	if err := Unmarshal(raw, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse servergrouplist: %w", err)
+	}
+
+	return groups, nil
+}
+
+// GetChannelGroupList retrieves the channel groups of the selected virtual
+// server.
+func GetChannelGroupList(ctx context.Context, cfg *config.Config, ssh *SSHClient) ([]ChannelGroup, error) {
+	raw, err := ssh.ExecContext(ctx, command("channelgrouplist", nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute channelgrouplist: %w", err)
+	}
+
+	var groups []ChannelGroup
+	if err := Unmarshal(raw, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse channelgrouplist: %w", err)
+	}
+
+	return groups, nil
+}
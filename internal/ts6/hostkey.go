@@ -0,0 +1,120 @@
+package ts6
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"ts6-viewer/internal/config"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsMu serializes known_hosts reads and writes so that concurrent
+// first connections do not record the same host twice.
+var knownHostsMu sync.Mutex
+
+// hostKeyCallback returns the host key check configured for cfg. A pinned
+// fingerprint takes precedence over a known_hosts file. Without either,
+// verification is disabled and a warning is logged. Trust-on-first-use
+// without a known_hosts file refuses every key, since a key it could not
+// store would be trusted anew on the next start.
+func hostKeyCallback(cfg *config.Config) ssh.HostKeyCallback {
+	if fp := strings.TrimSpace(cfg.Teamspeak6.HostKeyFingerprint); fp != "" {
+		return pinnedHostKey(fp)
+	}
+
+	path := cfg.Teamspeak6.KnownHostsFile
+	tofu := cfg.Teamspeak6.HostKeyTOFU == "true"
+
+	if path == "" && tofu {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return errors.New("host_key_tofu needs known_hosts_file")
+		}
+	}
+
+	if path == "" {
+		log.Println("[SSH] WARNING: host key verification is disabled. Set host_key_fingerprint, known_hosts_file or host_key_tofu")
+		return ssh.InsecureIgnoreHostKey()
+	}
+
+	return knownHostsCallback(path, tofu)
+}
+
+// pinnedHostKey accepts only a host key whose SHA256 fingerprint matches
+// fp. The "SHA256:" prefix is optional.
+func pinnedHostKey(fp string) ssh.HostKeyCallback {
+	want := "SHA256:" + strings.TrimPrefix(fp, "SHA256:")
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		got := ssh.FingerprintSHA256(key)
+		if got != want {
+			return fmt.Errorf("host key mismatch for %s: server presented %s %s, expected %s", hostname, key.Type(), got, want)
+		}
+		return nil
+	}
+}
+
+// knownHostsCallback checks host keys against an OpenSSH known_hosts file.
+// With tofu enabled, the key of a host that is not in the file yet is
+// appended and accepted; a host whose recorded key differs is always
+// refused.
+func knownHostsCallback(path string, tofu bool) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		if tofu {
+			f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o600)
+			if err != nil {
+				return fmt.Errorf("failed to create known_hosts file %s: %w", path, err)
+			}
+			f.Close()
+		}
+
+		check, err := knownhosts.New(path)
+		if err != nil {
+			return fmt.Errorf("failed to load known_hosts file %s: %w", path, err)
+		}
+
+		err = check(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("host key mismatch for %s: server presented %s %s, which differs from the key recorded in %s. Refusing to connect; remove the old entry if the server key was changed on purpose", hostname, key.Type(), ssh.FingerprintSHA256(key), path)
+		}
+
+		if !tofu {
+			return fmt.Errorf("unknown host key for %s (%s %s): not found in %s", hostname, key.Type(), ssh.FingerprintSHA256(key), path)
+		}
+
+		return trustOnFirstUse(path, hostname, key)
+	}
+}
+
+// trustOnFirstUse records key for hostname in the known_hosts file.
+func trustOnFirstUse(path, hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to write known_hosts file %s: %w", path, err)
+	}
+
+	log.Printf("[SSH] Trusted new host key for %s on first use: %s %s\n", hostname, key.Type(), ssh.FingerprintSHA256(key))
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package ts6
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ts6-viewer/internal/config"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const testHost = "ts.example.com:10022"
+
+var testRemote = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 10022}
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeKnownHosts writes a known_hosts file recording key for testHost.
+func writeKnownHosts(t *testing.T, key ssh.PublicKey) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(testHost)}, key) + "\n"
+	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func hostKeyConfig(knownHosts, tofu string) *config.Config {
+	return &config.Config{Teamspeak6: config.Teamspeak6{KnownHostsFile: knownHosts, HostKeyTOFU: tofu}}
+}
+
+func TestKnownHosts(t *testing.T) {
+	key := newHostKey(t)
+	check := hostKeyCallback(hostKeyConfig(writeKnownHosts(t, key), "false"))
+
+	if err := check(testHost, testRemote, key); err != nil {
+		t.Errorf("recorded key refused: %v", err)
+	}
+
+	if err := check(testHost, testRemote, newHostKey(t)); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("changed key: err = %v, want a mismatch", err)
+	}
+
+	// Without tofu, unknown hosts are not recorded
+	if err := check("other.example.com:10022", testRemote, key); err == nil || !strings.Contains(err.Error(), "unknown host key") {
+		t.Errorf("unknown host: err = %v", err)
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	check := hostKeyCallback(hostKeyConfig(path, "true"))
+
+	key := newHostKey(t)
+	if err := check(testHost, testRemote, key); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := knownhosts.Line([]string{knownhosts.Normalize(testHost)}, key) + "\n"; string(data) != want {
+		t.Errorf("known_hosts = %q, want %q", data, want)
+	}
+
+	if err := check(testHost, testRemote, key); err != nil {
+		t.Errorf("second use: %v", err)
+	}
+
+	if err := check(testHost, testRemote, newHostKey(t)); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("changed key: err = %v, want a mismatch", err)
+	}
+
+	// The changed key must not have been recorded
+	if after, _ := os.ReadFile(path); string(after) != string(data) {
+		t.Errorf("known_hosts changed to %q", after)
+	}
+}
+
+func TestTrustOnFirstUseWithoutFile(t *testing.T) {
+	check := hostKeyCallback(hostKeyConfig("", "true"))
+
+	if err := check(testHost, testRemote, newHostKey(t)); err == nil {
+		t.Error("key accepted without a known_hosts file")
+	}
+}
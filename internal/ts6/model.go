@@ -0,0 +1,403 @@
+package ts6
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Model is an in-memory copy of a virtual server. It is seeded by a full
+// resync and then kept current by ServerQuery notifications.
+//
+// The channel slice keeps the order returned by channellist; inserts and
+// moves only need to preserve the relative order of siblings because the
+// view builder groups children by parent.
+type Model struct {
+	mu       sync.RWMutex
+	channels []Channel
+	clients  map[int]Client
+	info     ServerInfo
+	synced   time.Time
+
+	serverGroups  []ServerGroup
+	channelGroups []ChannelGroup
+
+	voiceStatus bool // keep -voice properties of joining clients
+
+	changed chan struct{} // closed and replaced on every update
+}
+
+// NewModel returns an empty model. Snapshot reports false until the first
+// Replace. Without voiceStatus, joining clients are stored with their voice
+// properties cleared, matching a clientlist without -voice.
+func NewModel(voiceStatus bool) *Model {
+	return &Model{
+		voiceStatus: voiceStatus,
+		clients:     make(map[int]Client),
+		changed:     make(chan struct{}),
+	}
+}
+
+// Changed returns a channel that is closed on the next update of the
+// model. Callers take it before reading a Snapshot, so that no update
+// between the two is missed, and take a new one after it was closed.
+func (m *Model) Changed() <-chan struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.changed
+}
+
+// notifyChanged wakes up everyone waiting on Changed. It must be called
+// with m.mu held.
+func (m *Model) notifyChanged() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Replace overwrites the model with the result of a full resync.
+func (m *Model) Replace(channels []Channel, clients []Client, info *ServerInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.channels = append([]Channel(nil), channels...)
+	m.clients = make(map[int]Client, len(clients))
+	for _, cl := range clients {
+		m.clients[cl.CLID] = cl
+	}
+	m.info = *info
+	m.synced = time.Now()
+
+	m.notifyChanged()
+}
+
+// Snapshot returns a copy of the current state. The uptime is advanced by
+// the time passed since the last resync. ok is false if the model has never
+// been synced.
+func (m *Model) Snapshot() (channels []Channel, clients []Client, info *ServerInfo, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.synced.IsZero() {
+		return nil, nil, nil, false
+	}
+
+	channels = append([]Channel(nil), m.channels...)
+
+	clients = make([]Client, 0, len(m.clients))
+	for _, cl := range m.clients {
+		clients = append(clients, cl)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].CLID < clients[j].CLID
+	})
+
+	copied := m.info
+	copied.Uptime += time.Since(m.synced).Truncate(time.Second)
+
+	return channels, clients, &copied, true
+}
+
+// SetGroups replaces the server and channel groups. They change rarely and
+// are not part of every resync, so they are kept apart from Replace.
+func (m *Model) SetGroups(serverGroups []ServerGroup, channelGroups []ChannelGroup) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.serverGroups = append([]ServerGroup(nil), serverGroups...)
+	m.channelGroups = append([]ChannelGroup(nil), channelGroups...)
+}
+
+// Groups returns copies of the server and channel groups, which are empty
+// until they have been fetched.
+func (m *Model) Groups() ([]ServerGroup, []ChannelGroup) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]ServerGroup(nil), m.serverGroups...), append([]ChannelGroup(nil), m.channelGroups...)
+}
+
+// LastSync returns the time of the last full resync.
+func (m *Model) LastSync() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.synced
+}
+
+// Apply updates the model from a single notification. Notifications that do
+// not affect the channel tree or the server info are ignored, as are all
+// notifications received before the first resync.
+func (m *Model) Apply(n Notification) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.synced.IsZero() {
+		return
+	}
+
+	for _, e := range n.Entries {
+		var err error
+
+		switch n.Name {
+		case "notifycliententerview":
+			err = m.clientEntered(e)
+		case "notifyclientleftview":
+			err = m.clientLeft(e)
+		case "notifyclientmoved":
+			err = m.clientMoved(e)
+		case "notifychannelcreated":
+			err = m.channelCreated(e)
+		case "notifychanneledited":
+			err = m.channelEdited(e)
+		case "notifychannelmoved":
+			err = m.channelMoved(e)
+		case "notifychanneldeleted":
+			err = m.channelDeleted(e)
+		case "notifyserveredited":
+			err = m.serverEdited(e)
+		}
+
+		if err != nil {
+			log.Printf("[SSH] Ignoring malformed %s: %v\n", n.Name, err)
+		}
+	}
+}
+
+// clientEvent holds the notification properties that identify a client
+// and the channels it moves between.
+type clientEvent struct {
+	CLID int `ts6:"clid"`
+	CFID int `ts6:"cfid"`
+	CTID int `ts6:"ctid"`
+}
+
+// channelEvent holds the properties of notifychannelmoved and the parent
+// of notifychannelcreated, which use different keys than channellist.
+type channelEvent struct {
+	CID   int `ts6:"cid"`
+	CPID  int `ts6:"cpid"`
+	Order int `ts6:"order"`
+}
+
+func (m *Model) clientEntered(e map[string]string) error {
+	var cl Client
+	var ev clientEvent
+	if err := UnmarshalFields(e, &cl); err != nil {
+		return err
+	}
+	if err := UnmarshalFields(e, &ev); err != nil {
+		return err
+	}
+	cl.CID = ev.CTID
+
+	// Query clients are not part of the tree
+	if cl.CLID == 0 || cl.Type == clientTypeQuery {
+		return nil
+	}
+
+	if !m.voiceStatus {
+		clearVoiceStatus(&cl)
+	}
+
+	m.clients[cl.CLID] = cl
+	m.adjustTotalClients(cl.CID, 1)
+
+	m.notifyChanged()
+	return nil
+}
+
+func (m *Model) clientLeft(e map[string]string) error {
+	var ev clientEvent
+	if err := UnmarshalFields(e, &ev); err != nil {
+		return err
+	}
+
+	cl, ok := m.clients[ev.CLID]
+	if !ok {
+		return nil
+	}
+
+	delete(m.clients, cl.CLID)
+	m.adjustTotalClients(cl.CID, -1)
+
+	m.notifyChanged()
+	return nil
+}
+
+func (m *Model) clientMoved(e map[string]string) error {
+	var ev clientEvent
+	if err := UnmarshalFields(e, &ev); err != nil {
+		return err
+	}
+
+	cl, ok := m.clients[ev.CLID]
+	if !ok {
+		return nil
+	}
+
+	m.adjustTotalClients(cl.CID, -1)
+	cl.CID = ev.CTID
+	m.adjustTotalClients(cl.CID, 1)
+	m.clients[cl.CLID] = cl
+
+	m.notifyChanged()
+	return nil
+}
+
+func (m *Model) channelCreated(e map[string]string) error {
+	var ch Channel
+	var ev channelEvent
+	if err := UnmarshalFields(e, &ch); err != nil {
+		return err
+	}
+	if err := UnmarshalFields(e, &ev); err != nil {
+		return err
+	}
+	ch.PID = ev.CPID
+
+	if ch.CID == 0 || m.channelIndex(ch.CID) >= 0 {
+		return nil
+	}
+
+	m.insertChannel(ch)
+
+	m.notifyChanged()
+	return nil
+}
+
+func (m *Model) channelEdited(e map[string]string) error {
+	var ev channelEvent
+	if err := UnmarshalFields(e, &ev); err != nil {
+		return err
+	}
+
+	i := m.channelIndex(ev.CID)
+	if i < 0 {
+		return nil
+	}
+
+	ch := m.channels[i]
+	if err := UnmarshalFields(e, &ch); err != nil {
+		return err
+	}
+
+	if _, reordered := e["channel_order"]; reordered {
+		m.removeChannel(i)
+		m.insertChannel(ch)
+	} else {
+		m.channels[i] = ch
+	}
+
+	m.notifyChanged()
+	return nil
+}
+
+func (m *Model) channelMoved(e map[string]string) error {
+	var ev channelEvent
+	if err := UnmarshalFields(e, &ev); err != nil {
+		return err
+	}
+
+	i := m.channelIndex(ev.CID)
+	if i < 0 {
+		return nil
+	}
+
+	ch := m.channels[i]
+	ch.PID = ev.CPID
+	ch.ChannelOrder = ev.Order
+
+	m.removeChannel(i)
+	m.insertChannel(ch)
+
+	m.notifyChanged()
+	return nil
+}
+
+func (m *Model) channelDeleted(e map[string]string) error {
+	var ev channelEvent
+	if err := UnmarshalFields(e, &ev); err != nil {
+		return err
+	}
+
+	deleted := map[int]bool{ev.CID: true}
+
+	// Subchannels are deleted together with their parent. channellist order
+	// is not guaranteed to list parents first, so iterate until stable.
+	for changed := true; changed; {
+		changed = false
+		for _, ch := range m.channels {
+			if !deleted[ch.CID] && deleted[ch.PID] {
+				deleted[ch.CID] = true
+				changed = true
+			}
+		}
+	}
+
+	kept := m.channels[:0]
+	for _, ch := range m.channels {
+		if !deleted[ch.CID] {
+			kept = append(kept, ch)
+		}
+	}
+	m.channels = kept
+
+	for clid, cl := range m.clients {
+		if deleted[cl.CID] {
+			delete(m.clients, clid)
+		}
+	}
+
+	m.notifyChanged()
+	return nil
+}
+
+func (m *Model) serverEdited(e map[string]string) error {
+	info := m.info
+	if err := UnmarshalFields(e, &info); err != nil {
+		return err
+	}
+	m.info = info
+
+	m.notifyChanged()
+	return nil
+}
+
+// insertChannel places ch directly after the sibling it is ordered after,
+// or directly after its parent if it is the first sibling.
+func (m *Model) insertChannel(ch Channel) {
+	pos := 0
+	if i := m.channelIndex(ch.ChannelOrder); ch.ChannelOrder != 0 && i >= 0 {
+		pos = i + 1
+	} else if i := m.channelIndex(ch.PID); i >= 0 {
+		pos = i + 1
+	}
+
+	m.channels = append(m.channels, Channel{})
+	copy(m.channels[pos+1:], m.channels[pos:])
+	m.channels[pos] = ch
+}
+
+func (m *Model) removeChannel(i int) {
+	m.channels = append(m.channels[:i], m.channels[i+1:]...)
+}
+
+func (m *Model) channelIndex(cid int) int {
+	for i, ch := range m.channels {
+		if ch.CID == cid {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Model) adjustTotalClients(cid, delta int) {
+	i := m.channelIndex(cid)
+	if i < 0 {
+		return
+	}
+
+	m.channels[i].TotalClients = max(m.channels[i].TotalClients+delta, 0)
+}
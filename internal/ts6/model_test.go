@@ -0,0 +1,328 @@
+package ts6
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/ts6/ts6test"
+)
+
+// testChannels is a channellist with a lobby, a sub-channel that has a
+// sub-channel of its own, and a spacer.
+const testChannels = `cid=1 pid=0 channel_order=0 channel_name=Lobby total_clients=1` +
+	`|cid=2 pid=1 channel_order=0 channel_name=Gaming total_clients=1` +
+	`|cid=3 pid=2 channel_order=0 channel_name=Room total_clients=0` +
+	`|cid=4 pid=0 channel_order=1 channel_name=AFK total_clients=0`
+
+// testClients is a clientlist with Alice in the lobby and Bob in Gaming.
+const testClients = `clid=5 cid=1 client_nickname=Alice client_type=0` +
+	`|clid=6 cid=2 client_nickname=Bob client_type=0`
+
+// newTestModel returns a model synced with the given channellist and
+// clientlist responses.
+func newTestModel(t *testing.T, channelList, clientList string) *Model {
+	t.Helper()
+
+	var channels []Channel
+	if err := Unmarshal(channelList, &channels); err != nil {
+		t.Fatal(err)
+	}
+	var clients []Client
+	if err := Unmarshal(clientList, &clients); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(false)
+	m.Replace(channels, clients, &ServerInfo{Name: "Test Server", MaxClients: 32})
+	return m
+}
+
+// apply parses line and applies it to m.
+func apply(t *testing.T, m *Model, line string) {
+	t.Helper()
+
+	n, ok := ParseNotification(line)
+	if !ok {
+		t.Fatalf("%q is not a notification", line)
+	}
+	m.Apply(n)
+}
+
+// snapshot returns the channels of m in order, the channels by CID and the
+// clients by CLID.
+func snapshot(t *testing.T, m *Model) (order []int, channels map[int]Channel, clients map[int]Client) {
+	t.Helper()
+
+	chs, cls, _, ok := m.Snapshot()
+	if !ok {
+		t.Fatal("model not synced")
+	}
+
+	channels = make(map[int]Channel)
+	for _, ch := range chs {
+		order = append(order, ch.CID)
+		channels[ch.CID] = ch
+	}
+	clients = make(map[int]Client)
+	for _, cl := range cls {
+		clients[cl.CLID] = cl
+	}
+	return order, channels, clients
+}
+
+// totals returns the total_clients of the given channels.
+func totals(channels map[int]Channel, cids ...int) []int {
+	var n []int
+	for _, cid := range cids {
+		n = append(n, channels[cid].TotalClients)
+	}
+	return n
+}
+
+func TestModelClients(t *testing.T) {
+	m := newTestModel(t, testChannels, testClients)
+
+	apply(t, m, `notifycliententerview cfid=0 ctid=4 reasonid=0 clid=7 client_nickname=Carol client_type=0`)
+	_, channels, clients := snapshot(t, m)
+	if cl, ok := clients[7]; !ok || cl.Nickname != "Carol" || cl.CID != 4 {
+		t.Fatalf("after enter: clients = %+v", clients)
+	}
+	if got := totals(channels, 1, 2, 4); !slices.Equal(got, []int{1, 1, 1}) {
+		t.Errorf("after enter: totals = %v, want [1 1 1]", got)
+	}
+
+	// Query clients are not part of the tree
+	apply(t, m, `notifycliententerview cfid=0 ctid=1 reasonid=0 clid=8 client_nickname=bot client_type=1`)
+	if _, channels, clients = snapshot(t, m); len(clients) != 3 || channels[1].TotalClients != 1 {
+		t.Errorf("query client stored: clients = %+v", clients)
+	}
+
+	apply(t, m, `notifyclientmoved ctid=4 reasonid=0 clid=5`)
+	_, channels, clients = snapshot(t, m)
+	if clients[5].CID != 4 {
+		t.Errorf("after move: Alice in %d, want 4", clients[5].CID)
+	}
+	if got := totals(channels, 1, 2, 4); !slices.Equal(got, []int{0, 1, 2}) {
+		t.Errorf("after move: totals = %v, want [0 1 2]", got)
+	}
+
+	apply(t, m, `notifyclientleftview cfid=2 ctid=0 reasonid=8 reasonmsg=bye clid=6`)
+	_, channels, clients = snapshot(t, m)
+	if _, ok := clients[6]; ok {
+		t.Error("Bob still online after leaving")
+	}
+	if got := totals(channels, 1, 2, 4); !slices.Equal(got, []int{0, 0, 2}) {
+		t.Errorf("after leave: totals = %v, want [0 0 2]", got)
+	}
+
+	// Unknown clients change nothing
+	apply(t, m, `notifyclientleftview cfid=1 ctid=0 reasonid=8 clid=99`)
+	apply(t, m, `notifyclientmoved ctid=1 reasonid=0 clid=99`)
+	if _, channels, clients = snapshot(t, m); len(clients) != 2 || channels[1].TotalClients != 0 {
+		t.Errorf("unknown client applied: clients = %+v", clients)
+	}
+}
+
+func TestModelChannels(t *testing.T) {
+	m := newTestModel(t, testChannels, testClients)
+
+	tests := []struct {
+		line  string
+		order []int
+		check func(t *testing.T, channels map[int]Channel)
+	}{
+		{
+			// The first sibling goes directly after its parent
+			`notifychannelcreated cid=5 cpid=1 channel_order=0 channel_name=New invokerid=5`,
+			[]int{1, 5, 2, 3, 4},
+			func(t *testing.T, channels map[int]Channel) {
+				if ch := channels[5]; ch.PID != 1 || ch.Name != "New" {
+					t.Errorf("created %+v", ch)
+				}
+			},
+		},
+		{
+			// Already known; a duplicate must not add it twice
+			`notifychannelcreated cid=5 cpid=1 channel_order=0 channel_name=New invokerid=5`,
+			[]int{1, 5, 2, 3, 4},
+			nil,
+		},
+		{
+			`notifychanneledited cid=2 reasonid=10 channel_name=Games channel_topic=Play`,
+			[]int{1, 5, 2, 3, 4},
+			func(t *testing.T, channels map[int]Channel) {
+				if ch := channels[2]; ch.Name != "Games" || ch.Topic != "Play" || ch.PID != 1 || ch.TotalClients != 1 {
+					t.Errorf("edited %+v", ch)
+				}
+			},
+		},
+		{
+			// A new channel_order moves the channel behind its new
+			// predecessor
+			`notifychanneledited cid=4 reasonid=10 channel_order=1`,
+			[]int{1, 4, 5, 2, 3},
+			func(t *testing.T, channels map[int]Channel) {
+				if ch := channels[4]; ch.ChannelOrder != 1 || ch.Name != "AFK" {
+					t.Errorf("reordered %+v", ch)
+				}
+			},
+		},
+		{
+			`notifychannelmoved cid=3 cpid=0 order=4 reasonid=1`,
+			[]int{1, 4, 3, 5, 2},
+			func(t *testing.T, channels map[int]Channel) {
+				if ch := channels[3]; ch.PID != 0 || ch.ChannelOrder != 4 {
+					t.Errorf("moved %+v", ch)
+				}
+			},
+		},
+		{
+			`notifychannelmoved cid=3 cpid=2 order=0 reasonid=1`,
+			[]int{1, 4, 5, 2, 3},
+			func(t *testing.T, channels map[int]Channel) {
+				if ch := channels[3]; ch.PID != 2 || ch.ChannelOrder != 0 {
+					t.Errorf("moved back %+v", ch)
+				}
+			},
+		},
+		{
+			`notifychanneldeleted cid=5 invokerid=5`,
+			[]int{1, 4, 2, 3},
+			nil,
+		},
+		{
+			`notifychanneledited cid=99 reasonid=10 channel_name=Ghost`,
+			[]int{1, 4, 2, 3},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		apply(t, m, tt.line)
+
+		order, channels, _ := snapshot(t, m)
+		if !slices.Equal(order, tt.order) {
+			t.Fatalf("after %q: order = %v, want %v", tt.line, order, tt.order)
+		}
+		if tt.check != nil {
+			tt.check(t, channels)
+		}
+	}
+}
+
+func TestModelChannelDeletedWithChildren(t *testing.T) {
+	// The sub-channel is listed before its parent and has a client of its
+	// own
+	m := newTestModel(t,
+		`cid=1 pid=0 channel_order=0 channel_name=Lobby`+
+			`|cid=3 pid=2 channel_order=0 channel_name=Room`+
+			`|cid=2 pid=1 channel_order=0 channel_name=Gaming`+
+			`|cid=4 pid=3 channel_order=0 channel_name=Corner`+
+			`|cid=5 pid=0 channel_order=1 channel_name=AFK`,
+		testClients+`|clid=7 cid=4 client_nickname=Carol client_type=0`)
+
+	apply(t, m, `notifychanneldeleted cid=2 invokerid=0`)
+
+	order, _, clients := snapshot(t, m)
+	if !slices.Equal(order, []int{1, 5}) {
+		t.Errorf("order = %v, want [1 5]", order)
+	}
+	if _, ok := clients[5]; !ok || len(clients) != 1 {
+		t.Errorf("clients = %+v, want only Alice", clients)
+	}
+}
+
+func TestModelServerEdited(t *testing.T) {
+	m := newTestModel(t, testChannels, testClients)
+
+	apply(t, m, `notifyserveredited reasonid=10 invokerid=5 virtualserver_name=Renamed\sServer virtualserver_welcomemessage=Hi`)
+
+	_, _, info, _ := m.Snapshot()
+	if info.Name != "Renamed Server" || info.WelcomeMessage != "Hi" {
+		t.Errorf("info = %+v", info)
+	}
+	if info.MaxClients != 32 {
+		t.Errorf("max clients = %d, want the 32 of the resync", info.MaxClients)
+	}
+}
+
+func TestModelIgnoresNotificationsBeforeSync(t *testing.T) {
+	m := NewModel(false)
+
+	apply(t, m, `notifycliententerview cfid=0 ctid=1 reasonid=0 clid=7 client_nickname=Carol client_type=0`)
+	if _, _, _, ok := m.Snapshot(); ok {
+		t.Fatal("synced by a notification")
+	}
+
+	m.Replace(nil, nil, &ServerInfo{})
+	if _, clients, _, _ := m.Snapshot(); len(clients) != 0 {
+		t.Errorf("clients = %+v, want none", clients)
+	}
+}
+
+// A notification sent while the watcher resyncs after registering must be
+// applied on top of the resync instead of being lost or overwritten.
+func TestWatcherAppliesNotificationsDuringResync(t *testing.T) {
+	srv, cfg := newTestServer(t)
+
+	var once sync.Once
+	srv.Handle("channellist", func(req *ts6test.Request) ts6test.Response {
+		if countCommands(srv, "servernotifyregister") == 2 {
+			once.Do(func() {
+				srv.Notify(`notifycliententerview cfid=0 ctid=1 reasonid=0 clid=7 client_nickname=Carol client_type=0`)
+			})
+		}
+		return ts6test.Response{Body: `cid=1 pid=0 channel_order=0 channel_name=Lobby total_clients=2`}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := NewWatcher(cfg, time.Hour)
+	w.Start(ctx)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		channels, clients, _, ok := w.Model().Snapshot()
+		if ok && slices.ContainsFunc(clients, func(cl Client) bool { return cl.Nickname == "Carol" }) {
+			if channels[0].TotalClients != 3 {
+				t.Errorf("total clients = %d, want 3", channels[0].TotalClients)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var cmds []string
+	for _, cmd := range srv.Commands() {
+		cmds = append(cmds, strings.Fields(cmd)[0])
+	}
+	t.Fatalf("Carol never appeared; commands: %v", cmds)
+}
+
+func TestModelChanged(t *testing.T) {
+	m := newTestModel(t, testChannels, testClients)
+
+	changed := m.Changed()
+	apply(t, m, `notifyclientleftview cfid=1 ctid=0 reasonid=8 clid=99`)
+	select {
+	case <-changed:
+		t.Fatal("woken up by a notification that changed nothing")
+	default:
+	}
+
+	apply(t, m, `notifyclientmoved ctid=4 reasonid=0 clid=5`)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("not woken up by a move")
+	}
+
+	if next := m.Changed(); next == changed {
+		t.Error("Changed returned the closed channel again")
+	}
+}
@@ -0,0 +1,65 @@
+package ts6
+
+import (
+	"strings"
+)
+
+// Notification is an asynchronous ServerQuery event such as
+// notifycliententerview. A single notification may describe several
+// entities separated by "|"; every entry inherits the properties of the
+// first entry it does not override itself.
+type Notification struct {
+	Name    string
+	Entries []map[string]string
+}
+
+// isNotification reports whether a raw ServerQuery line is an event
+// notification rather than part of a command response.
+func isNotification(line string) bool {
+	return strings.HasPrefix(line, "notify")
+}
+
+// ParseNotification parses a raw notify line. The second return value is
+// false if the line is not a notification.
+func ParseNotification(line string) (Notification, bool) {
+	line = strings.TrimSpace(line)
+	if !isNotification(line) {
+		return Notification{}, false
+	}
+
+	name, rest, _ := strings.Cut(line, " ")
+	n := Notification{Name: name}
+
+	for i, block := range strings.Split(rest, "|") {
+		entry := parseFields(block)
+
+		if i > 0 {
+			for k, v := range n.Entries[0] {
+				if _, ok := entry[k]; !ok {
+					entry[k] = v
+				}
+			}
+		}
+
+		n.Entries = append(n.Entries, entry)
+	}
+
+	return n, true
+}
+
+// parseFields splits a single ServerQuery entry into its unescaped
+// key/value pairs. Flags without a value are ignored.
+func parseFields(block string) map[string]string {
+	fields := strings.Fields(block)
+	kv := make(map[string]string, len(fields))
+
+	for _, f := range fields {
+		key, val, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+		kv[key] = Unescape(val)
+	}
+
+	return kv
+}
@@ -1,79 +1,42 @@
 package ts6
 
 import (
+	"context"
 	"fmt"
-	"strconv"
-	"strings"
+	"time"
 	"ts6-viewer/internal/config"
 )
 
 type ServerInfo struct {
-	ServerID               string
-	Name                   string
-	Uptime                 string
-	ClientsOnline          string
-	MaxClients             string
-	ChannelsOnline         string
-	HostBannerURL          string
-	HostBannerGfxURL       string
-	NeededIdentitySecurity string
-	QueryClientConnections string
-	ClientConnections      string
+	ServerID               int           `ts6:"virtualserver_id"`
+	Name                   string        `ts6:"virtualserver_name"`
+	Uptime                 time.Duration `ts6:"virtualserver_uptime"`
+	ClientsOnline          int           `ts6:"virtualserver_clientsonline"`
+	QueryClientsOnline     int           `ts6:"virtualserver_queryclientsonline"`
+	MaxClients             int           `ts6:"virtualserver_maxclients"`
+	ChannelsOnline         int           `ts6:"virtualserver_channelsonline"`
+	HostBannerURL          string        `ts6:"virtualserver_hostbanner_url"`
+	HostBannerGfxURL       string        `ts6:"virtualserver_hostbanner_gfx_url"`
+	WelcomeMessage         string        `ts6:"virtualserver_welcomemessage"`
+	NeededIdentitySecurity int           `ts6:"virtualserver_needed_identity_security_level"`
+	QueryClientConnections int64         `ts6:"virtualserver_query_client_connections"`
+	ClientConnections      int64         `ts6:"virtualserver_client_connections"`
 }
 
-func GetServerInfo(cfg *config.Config, c *SSHClient) (*ServerInfo, error) {
-	raw, err := c.exec("serverinfo")
+func GetServerInfo(ctx context.Context, cfg *config.Config, c *SSHClient) (*ServerInfo, error) {
+	raw, err := c.ExecContext(ctx, command("serverinfo", nil))
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute serverinfo: %w", err)
 	}
 
-	blocks := strings.Split(raw, "|")
-	first := strings.TrimSpace(blocks[0])
-	fields := strings.Fields(first)
-
 	info := &ServerInfo{}
-
-	for _, f := range fields {
-		if !strings.Contains(f, "=") {
-			continue
-		}
-
-		kv := strings.SplitN(f, "=", 2)
-		key := kv[0]
-		val := UnescapeTS6(kv[1])
-
-		switch key {
-		case "virtualserver_id":
-			info.ServerID = val
-		case "virtualserver_name":
-			info.Name = val
-		case "virtualserver_uptime":
-			info.Uptime = val
-		case "virtualserver_clientsonline":
-			info.ClientsOnline = val
-		case "virtualserver_maxclients":
-			info.MaxClients = val
-		case "virtualserver_channelsonline":
-			info.ChannelsOnline = val
-		case "virtualserver_hostbanner_url":
-			info.HostBannerURL = val
-		case "virtualserver_hostbanner_gfx_url":
-			info.HostBannerGfxURL = val
-		case "virtualserver_needed_identity_security_level":
-			info.NeededIdentitySecurity = val
-		case "virtualserver_query_client_connections":
-			info.QueryClientConnections = val
-		case "virtualserver_client_connections":
-			info.ClientConnections = val
-		}
+	if err := Unmarshal(raw, info); err != nil {
+		return nil, fmt.Errorf("failed to parse serverinfo: %w", err)
 	}
 
-	if n, err := strconv.Atoi(info.ClientsOnline); err == nil {
-		if n > 0 {
-			n--
-		}
-		info.ClientsOnline = strconv.Itoa(n)
-	}
+	// Do not count ServerQuery clients, among them our own command and
+	// event connections
+	info.ClientsOnline = max(info.ClientsOnline-info.QueryClientsOnline, 0)
 
 	return info, nil
 }
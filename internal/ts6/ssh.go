@@ -2,18 +2,19 @@ package ts6
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"net"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/metrics"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -23,133 +24,130 @@ import (
 // channellist or clientlist on servers with many channels/clients.
 const sshReadBufferSize = 65536
 
+const (
+	// commandTimeout bounds every command, even if the caller's context
+	// has no deadline. A server that does not answer within it is treated
+	// as lost.
+	commandTimeout = 15 * time.Second
+
+	// handshakeTimeout bounds waiting for the welcome message and login.
+	handshakeTimeout = 10 * time.Second
+)
+
+// errClosed is wrapped in ErrConnectionLost when a command is issued on a
+// closed client.
+var errClosed = errors.New("ssh connection is closed")
+
+var (
+	commandDuration = metrics.NewHistogramVec("ts6viewer_serverquery_command_duration_seconds",
+		"Time taken by ServerQuery commands, including flood-limited attempts.", metrics.DefaultBuckets, "command")
+	floodBans = metrics.NewCounterVec("ts6viewer_serverquery_flood_bans_total",
+		"ServerQuery commands rejected by the server's flood protection.")
+)
+
 // SSHClient represents a persistent SSH ServerQuery connection.
+//
+// A single goroutine reads the session output line by line. Commands are
+// serialized by mu and consume their response from that stream, so a
+// command abandoned by its caller leaves its response behind; the next
+// command discards it before reading its own.
 type SSHClient struct {
 	cfg      *config.Config
-	serverID string
+	serverID string // currently selected virtual server
 
 	ssh     *ssh.Client
 	session *ssh.Session
 	stdin   io.WriteCloser
-	reader  *bufio.Reader
 
-	mu   sync.Mutex // protects command execution and reconnect
-	done chan struct{}
-	once sync.Once
-}
-
-var (
-	globalClient *SSHClient
-	globalMu     sync.Mutex
+	lines   chan string // output of the session, closed when reading fails
+	readErr error       // why reading failed, set before lines is closed
 
-	floodWaitRegex = regexp.MustCompile(`wait (\d+)ms`)
-	errIDRegex     = regexp.MustCompile(`^error id=(\d+)`)
-)
+	mu       sync.Mutex // protects command execution and serverID
+	serverMu sync.Mutex // held by WithServer to keep the virtual server selected
+	stale    int        // responses still owed to abandoned commands, guarded by mu
+	done     chan struct{}
+	once     sync.Once
+}
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-// GetPersistentClient returns a singleton SSH connection.
-func GetPersistentClient(cfg *config.Config, serverID string) (*SSHClient, error) {
-	globalMu.Lock()
-	defer globalMu.Unlock()
-
-	if globalClient != nil && !globalClient.IsClosed() {
-		log.Println("[SSH] Reusing existing persistent connection")
-		return globalClient, nil
-	}
-
-	log.Println("[SSH] Creating new persistent SSH connection")
-
-	client, err := newSSHClientWithUse(cfg, serverID)
-	if err != nil {
-		log.Printf("[SSH] Connection creation failed: %v\n", err)
-		return nil, err
-	}
-
-	globalClient = client
-	log.Println("[SSH] Persistent SSH connection established")
-
-	return globalClient, nil
+// InstanceKey identifies a ServerQuery login on a TS6 instance. Virtual
+// servers with the same key share one command connection.
+func InstanceKey(cfg *config.Config) string {
+	return cfg.Teamspeak6.User + "@" + net.JoinHostPort(cfg.Teamspeak6.Host, cfg.Teamspeak6.Port)
 }
 
-// newSSHClientWithUse establishes a new SSH connection and selects the server.
-func newSSHClientWithUse(cfg *config.Config, serverID string) (*SSHClient, error) {
-	client, err := newSSHClientBase(cfg)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := client.Use(serverID); err != nil {
-		client.Close()
-		return nil, err
-	}
+// WithServer runs fn after selecting the virtual server serverID. Other
+// virtual servers sharing the connection wait until fn returns, so fn may
+// issue several commands with ExecContext without another "use" slipping
+// in between.
+func (c *SSHClient) WithServer(ctx context.Context, serverID string, fn func() error) error {
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
 
-	client.cfg = cfg
-	client.serverID = serverID
+	c.mu.Lock()
+	selected := c.serverID
+	c.mu.Unlock()
 
-	go client.keepAlive()
+	if selected != serverID {
+		if err := c.Use(ctx, serverID); err != nil {
+			return err
+		}
+	}
 
-	return client, nil
+	return fn()
 }
 
 // Use selects the virtual server by ID.
-func (c *SSHClient) Use(serverID string) error {
+func (c *SSHClient) Use(ctx context.Context, serverID string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.IsClosed() {
-		return fmt.Errorf("ssh connection is closed")
-	}
+	return c.use(ctx, serverID)
+}
 
+// use selects the virtual server by ID. The caller must hold c.mu.
+func (c *SSHClient) use(ctx context.Context, serverID string) error {
 	log.Printf("[SSH] Selecting virtual server: %s\n", serverID)
 
-	_, err := c.stdin.Write([]byte(fmt.Sprintf("use %s\n", serverID)))
-	if err != nil {
-		return fmt.Errorf("failed to send use command: %w", err)
+	if _, err := c.exec(ctx, command("use", map[string]string{"sid": serverID})); err != nil {
+		return fmt.Errorf("use command failed: %w", err)
 	}
 
-	timeout := time.After(5 * time.Second)
+	c.serverID = serverID
+	log.Println("[SSH] Virtual server selected successfully")
 
-	for {
-		select {
-		case <-timeout:
-			return fmt.Errorf("timeout while waiting for use response")
-		default:
-			line, err := c.reader.ReadString('\n')
-			if err != nil {
-				return fmt.Errorf("failed to read use response: %w", err)
-			}
+	return nil
+}
 
-			line = strings.TrimSpace(line)
+// newSSHClientBase creates a raw SSH connection and performs login.
+func newSSHClientBase(ctx context.Context, cfg *config.Config) (*SSHClient, error) {
+	c, err := dialSSH(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-			if strings.HasPrefix(line, "error id=") {
-				if line != "error id=0 msg=ok" {
-					return fmt.Errorf("use command failed: %s", line)
-				}
-				log.Println("[SSH] Virtual server selected successfully")
-				return nil
-			}
-		}
+	if err := c.login(ctx); err != nil {
+		c.Close()
+		return nil, err
 	}
-}
 
-// newSSHClientBase creates a raw SSH connection and performs login.
-func newSSHClientBase(cfg *config.Config) (*SSHClient, error) {
-	host := cfg.Teamspeak6.Host
-	port := cfg.Teamspeak6.Port
-	user := cfg.Teamspeak6.User
-	password := cfg.Teamspeak6.Password
+	return c, nil
+}
 
-	addr := net.JoinHostPort(host, port)
+// dialSSH opens the SSH session, starts the reader and waits for the
+// ServerQuery welcome message.
+func dialSSH(ctx context.Context, cfg *config.Config) (*SSHClient, error) {
+	addr := net.JoinHostPort(cfg.Teamspeak6.Host, cfg.Teamspeak6.Port)
 
 	log.Printf("[SSH] Connecting to %s\n", addr)
 
 	sshConfig := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(password)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            cfg.Teamspeak6.User,
+		Auth:            []ssh.AuthMethod{ssh.Password(cfg.Teamspeak6.Password)},
+		HostKeyCallback: hostKeyCallback(cfg),
 		Timeout:         10 * time.Second,
 	}
 
@@ -158,17 +156,17 @@ func newSSHClientBase(cfg *config.Config) (*SSHClient, error) {
 		KeepAlive: 30 * time.Second,
 	}
 
-	rawConn, err := dialer.Dial("tcp", addr)
+	rawConn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		log.Printf("[SSH] TCP dial failed: %v\n", err)
-		return nil, err
+		return nil, connectionLost(err)
 	}
 
 	sshConn, chans, reqs, err := ssh.NewClientConn(rawConn, addr, sshConfig)
 	if err != nil {
 		rawConn.Close()
 		log.Printf("[SSH] SSH handshake failed: %v\n", err)
-		return nil, err
+		return nil, connectionLost(err)
 	}
 
 	client := ssh.NewClient(sshConn, chans, reqs)
@@ -197,273 +195,295 @@ func newSSHClientBase(cfg *config.Config) (*SSHClient, error) {
 	}
 
 	c := &SSHClient{
+		cfg:     cfg,
 		ssh:     client,
 		session: session,
 		stdin:   stdin,
-		reader:  bufio.NewReaderSize(stdout, sshReadBufferSize),
+		lines:   make(chan string),
 		done:    make(chan struct{}),
 	}
 
+	go c.readLoop(bufio.NewReaderSize(stdout, sshReadBufferSize))
+
 	log.Println("[SSH] Waiting for welcome message")
 
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
 	for {
-		line, err := c.reader.ReadString('\n')
+		line, err := c.readLine(ctx)
 		if err != nil {
 			c.Close()
-			return nil, err
+			return nil, connectionLost(err)
 		}
 		if strings.Contains(line, "Welcome") || strings.Contains(line, "TS3") {
 			break
 		}
 	}
 
-	log.Println("[SSH] Sending login command")
+	return c, nil
+}
 
-	if _, err := c.stdin.Write([]byte(fmt.Sprintf("login %s %s\n", user, password))); err != nil {
-		c.Close()
-		return nil, err
-	}
+// readLoop is the only reader of the session output. It stops when reading
+// fails or the client is closed.
+func (c *SSHClient) readLoop(r *bufio.Reader) {
+	defer close(c.lines)
 
 	for {
-		line, err := c.reader.ReadString('\n')
+		line, err := r.ReadString('\n')
 		if err != nil {
-			c.Close()
-			return nil, err
+			c.readErr = err
+			return
 		}
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "error id=") {
-			if line != "error id=0 msg=ok" {
-				c.Close()
-				return nil, fmt.Errorf("login failed: %s", line)
-			}
-			break
+
+		select {
+		case c.lines <- strings.TrimSpace(line):
+		case <-c.done:
+			c.readErr = errClosed
+			return
 		}
 	}
+}
 
-	log.Printf("[SSH] Login successful to %s\n", addr)
-
-	return c, nil
+// readLine returns the next line of output. Transport failures are wrapped
+// in ErrConnectionLost; cancellation returns the context's error.
+func (c *SSHClient) readLine(ctx context.Context) (string, error) {
+	select {
+	case line, ok := <-c.lines:
+		if !ok {
+			return "", connectionLost(c.readErr)
+		}
+		return line, nil
+	case <-c.done:
+		return "", connectionLost(errClosed)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
 }
 
-// keepAlive sends periodic version commands to prevent idle timeout.
-// It stops when the client is closed via the done channel.
-func (c *SSHClient) keepAlive() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// login authenticates the ServerQuery session with the configured
+// credentials.
+func (c *SSHClient) login(ctx context.Context) error {
+	log.Println("[SSH] Sending login command")
 
-	for {
-		select {
-		case <-c.done:
-			log.Println("[SSH] keepAlive stopped (connection closed)")
-			return
-		case <-ticker.C:
-			if c.IsClosed() {
-				return
-			}
-			log.Println("[SSH] Sending keepalive ping")
-			_, err := c.Exec("version")
-			if err != nil {
-				log.Printf("[SSH] Keepalive failed: %v. Attempting reconnect\n", err)
-				_ = c.reconnect()
-				return // stop this goroutine; reconnect spawns a new one
-			}
-		}
+	login := command("login", map[string]string{
+		"client_login_name":     c.cfg.Teamspeak6.User,
+		"client_login_password": c.cfg.Teamspeak6.Password,
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	c.mu.Lock()
+	_, err := c.exec(ctx, login)
+	c.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
 	}
+
+	log.Printf("[SSH] Login successful to %s\n", InstanceKey(c.cfg))
+
+	return nil
 }
 
 // Exec executes a ServerQuery command safely.
 func (c *SSHClient) Exec(cmd string) (string, error) {
+	return c.ExecContext(context.Background(), cmd)
+}
+
+// ExecContext executes a ServerQuery command safely. If ctx is done before
+// the response arrives, ExecContext returns ctx.Err() and the response is
+// discarded by the next command. Commands that depend on the selected
+// virtual server belong in WithServer.
+func (c *SSHClient) ExecContext(ctx context.Context, cmd string) (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	log.Printf("[SSH] Executing command: %s\n", cmd)
-	return c.execSafe(cmd)
+	return c.execSafe(ctx, cmd)
+}
+
+// send writes a raw command without waiting for its response. It is used on
+// connections whose output is consumed elsewhere, such as the event listener.
+func (c *SSHClient) send(cmd string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.IsClosed() {
+		return connectionLost(errClosed)
+	}
+
+	if _, err := c.stdin.Write([]byte(cmd + "\n")); err != nil {
+		return connectionLost(err)
+	}
+	return nil
 }
 
-// exec sends a raw command and reads the response with a timeout.
-// It includes panic recovery to handle unexpected bufio errors gracefully
+// exec sends a raw command and reads its response. The caller must hold
+// c.mu. Notification lines interleaved with the response are skipped.
+// It includes panic recovery to handle unexpected errors gracefully
 // instead of crashing the process.
-func (c *SSHClient) exec(cmd string) (result string, err error) {
+func (c *SSHClient) exec(ctx context.Context, cmd string) (result string, err error) {
+	name, _, _ := strings.Cut(cmd, " ")
+	defer func(start time.Time) {
+		commandDuration.Observe(time.Since(start).Seconds(), name)
+	}(time.Now())
+
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("[SSH] Recovered from panic during exec(%q): %v\n", cmd, r)
-			err = fmt.Errorf("panic during command execution: %v", r)
+			err = connectionLost(fmt.Errorf("panic during command execution: %v", r))
 			result = ""
 		}
 	}()
 
-	_, err = c.stdin.Write([]byte(cmd + "\n"))
-	if err != nil {
-		return "", err
+	if c.IsClosed() {
+		return "", connectionLost(errClosed)
+	}
+
+	// The caller's deadline may be longer or missing
+	timeoutCtx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	if err := c.discardStale(timeoutCtx); err != nil {
+		return "", c.readError(ctx, err)
 	}
 
-	type readResult struct {
-		line string
-		err  error
+	if _, err := c.stdin.Write([]byte(cmd + "\n")); err != nil {
+		return "", connectionLost(err)
 	}
 
 	var lines []string
-	var last string
 
 	for {
-		ch := make(chan readResult, 1)
-		go func() {
-			line, readErr := c.reader.ReadString('\n')
-			ch <- readResult{line, readErr}
-		}()
-
-		select {
-		case res := <-ch:
-			if res.err != nil {
-				return "", res.err
-			}
-			line := strings.TrimSpace(res.line)
-			lines = append(lines, line)
-			last = line
-			if strings.HasPrefix(line, "error id=") {
-				goto done
+		line, err := c.readLine(timeoutCtx)
+		if err != nil {
+			if timeoutCtx.Err() != nil {
+				// The response is still on its way
+				c.stale++
 			}
-		case <-time.After(15 * time.Second):
-			return "", fmt.Errorf("read timeout: no response within 15s")
+			return "", c.readError(ctx, err)
+		}
+		if isNotification(line) {
+			continue
 		}
+		if isStatusLine(line) {
+			// The status line is not part of the response data
+			return strings.Join(lines, "\n"), parseStatus(line)
+		}
+		lines = append(lines, line)
 	}
+}
 
-done:
-	raw := strings.Join(lines, "\n")
-
-	if strings.HasPrefix(last, "error id=") && last != "error id=0 msg=ok" {
-		return raw, fmt.Errorf("%s", last)
+// discardStale skips the responses of commands whose callers gave up
+// before they arrived. The caller must hold c.mu.
+func (c *SSHClient) discardStale(ctx context.Context) error {
+	for c.stale > 0 {
+		line, err := c.readLine(ctx)
+		if err != nil {
+			return err
+		}
+		if isStatusLine(line) {
+			c.stale--
+		}
 	}
+	return nil
+}
 
-	return raw, nil
+// readError reports a failed read. If the caller's ctx is done its error is
+// returned as is; running into commandTimeout means the server stopped
+// answering.
+func (c *SSHClient) readError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return connectionLost(fmt.Errorf("read timeout: no response within %v", commandTimeout))
+	}
+	return err
 }
 
-// execSafe handles flood and reconnect logic.
-func (c *SSHClient) execSafe(cmd string) (string, error) {
+// execSafe retries commands rejected by the flood protection. Connection
+// errors are returned to the caller; reconnecting is up to the Supervisor.
+func (c *SSHClient) execSafe(ctx context.Context, cmd string) (string, error) {
 	const (
 		maxFloodRetries = 5
-		maxReconnects   = 2
 		maxWaitMs       = 10000
 		jitterMs        = 250
 	)
 
 	floodRetries := 0
-	reconnects := 0
 
 	for {
-		raw, err := c.exec(cmd)
+		raw, err := c.exec(ctx, cmd)
 		if err == nil {
 			return raw, nil
 		}
 
-		if m := errIDRegex.FindStringSubmatch(err.Error()); len(m) == 2 {
-			id, _ := strconv.Atoi(m[1])
-			if id == 524 {
-				wait := 1000
-				if match := floodWaitRegex.FindStringSubmatch(err.Error()); len(match) == 2 {
-					if ms, convErr := strconv.Atoi(match[1]); convErr == nil {
-						wait = ms
-					}
-				}
-
-				backoff := wait * (1 << floodRetries)
-				if backoff > maxWaitMs {
-					backoff = maxWaitMs
-				}
-
-				jitter := rand.Intn(jitterMs + 1)
-				sleepMs := backoff + jitter
-
-				log.Printf("[SSH] Flood detected. Backing off %d ms\n", sleepMs)
-
-				time.Sleep(time.Duration(sleepMs) * time.Millisecond)
-
-				floodRetries++
-				if floodRetries >= maxFloodRetries {
-					if reconnects >= maxReconnects {
-						return "", fmt.Errorf("max flood retries reached: %w", err)
-					}
-					log.Println("[SSH] Flood retry limit reached. Reconnecting")
-					c.Close()
-					time.Sleep(300 * time.Millisecond)
-					_ = c.reconnect()
-					floodRetries = 0
-					reconnects++
-				}
-				continue
+		var qe *QueryError
+		if errors.As(err, &qe) {
+			if !errors.Is(qe, ErrFlood) {
+				return raw, err
 			}
-			return raw, err
-		}
 
-		if isConnectionError(err) {
-			if reconnects >= maxReconnects {
-				return "", err
+			wait := 1000
+			if after := qe.RetryAfter(); after > 0 {
+				wait = int(after.Milliseconds())
 			}
-			log.Printf("[SSH] Connection error detected: %v. Reconnecting\n", err)
-			c.Close()
-			time.Sleep(300 * time.Millisecond)
-			_ = c.reconnect()
-			reconnects++
-			continue
-		}
-
-		return "", err
-	}
-}
 
-// reconnect recreates the SSH connection.
-func (c *SSHClient) reconnect() error {
-	globalMu.Lock()
-	defer globalMu.Unlock()
+			backoff := wait * (1 << floodRetries)
+			if backoff > maxWaitMs {
+				backoff = maxWaitMs
+			}
 
-	log.Println("[SSH] Attempting reconnect")
+			jitter := rand.Intn(jitterMs + 1)
+			sleepMs := backoff + jitter
 
-	if c.cfg == nil {
-		return fmt.Errorf("missing configuration for reconnect")
-	}
+			log.Printf("[SSH] Flood detected. Backing off %d ms\n", sleepMs)
+			floodBans.Inc()
 
-	newClient, err := newSSHClientWithUse(c.cfg, c.serverID)
-	if err != nil {
-		log.Printf("[SSH] Reconnect failed: %v\n", err)
-		return err
-	}
-
-	globalClient = newClient
-	log.Println("[SSH] Reconnect successful")
+			timer := time.NewTimer(time.Duration(sleepMs) * time.Millisecond)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return "", ctx.Err()
+			}
 
-	return nil
-}
+			floodRetries++
+			if floodRetries >= maxFloodRetries {
+				return "", fmt.Errorf("max flood retries reached: %w", err)
+			}
+			continue
+		}
 
-// isConnectionError detects network-level failures.
-func isConnectionError(err error) bool {
-	msg := strings.ToLower(err.Error())
-	return strings.Contains(msg, "eof") ||
-		strings.Contains(msg, "broken pipe") ||
-		strings.Contains(msg, "connection reset") ||
-		strings.Contains(msg, "use of closed network connection") ||
-		strings.Contains(msg, "panic during command execution") ||
-		strings.Contains(msg, "read timeout")
+		return "", err
+	}
 }
 
 // IsClosed checks whether the client is closed.
 func (c *SSHClient) IsClosed() bool {
-	return c == nil || c.ssh == nil
+	if c == nil {
+		return true
+	}
+
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
 }
 
-// Close terminates the SSH session and signals the keepAlive goroutine to stop.
+// Close terminates the SSH session and signals goroutines watching the
+// connection to stop.
 func (c *SSHClient) Close() {
-	log.Println("[SSH] Closing SSH connection")
-
 	c.once.Do(func() {
-		close(c.done)
-	})
+		log.Println("[SSH] Closing SSH connection")
 
-	if c.session != nil {
+		close(c.done)
 		_ = c.session.Close()
-		c.session = nil
-	}
-	if c.ssh != nil {
 		_ = c.ssh.Close()
-		c.ssh = nil
-	}
+	})
 }
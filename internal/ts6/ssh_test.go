@@ -0,0 +1,217 @@
+package ts6
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/ts6/ts6test"
+)
+
+// newTestServer starts a fake ServerQuery server and returns it with a
+// configuration that connects to it.
+func newTestServer(t *testing.T) (*ts6test.Server, *config.Config) {
+	t.Helper()
+
+	srv, err := ts6test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	return srv, &config.Config{Teamspeak6: srv.Teamspeak6()}
+}
+
+// newTestClient connects and logs in to srv.
+func newTestClient(t *testing.T, cfg *config.Config) *SSHClient {
+	t.Helper()
+
+	c, err := newSSHClientBase(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+// countCommands counts the commands srv received with the given name.
+func countCommands(srv *ts6test.Server, name string) int {
+	n := 0
+	for _, cmd := range srv.Commands() {
+		if cmd == name || strings.HasPrefix(cmd, name+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSSHClientLogin(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	cmds := srv.Commands()
+	if len(cmds) == 0 || cmds[0] != "login client_login_name=serveradmin client_login_password=***" {
+		t.Fatalf("commands = %q, want login first", cmds)
+	}
+
+	raw, err := c.Exec("version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(raw, "version=6.0.0") {
+		t.Errorf("version = %q", raw)
+	}
+}
+
+func TestSSHClientRejectsWrongPassword(t *testing.T) {
+	_, cfg := newTestServer(t)
+	cfg.Teamspeak6.Password = "wrong"
+
+	_, err := newSSHClientBase(context.Background(), cfg)
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("err = %v, want ErrConnectionLost", err)
+	}
+}
+
+func TestSSHClientRejectsWrongHostKey(t *testing.T) {
+	_, cfg := newTestServer(t)
+	cfg.Teamspeak6.HostKeyFingerprint = "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+	_, err := newSSHClientBase(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Fatalf("err = %v, want host key mismatch", err)
+	}
+}
+
+func TestSSHClientQueryError(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	srv.SetError("channellist", ts6test.ErrIDInsufficientPermissions, "insufficient client permissions")
+
+	_, err := c.Exec("channellist")
+	if !errors.Is(err, ErrInsufficientPermissions) {
+		t.Fatalf("err = %v, want ErrInsufficientPermissions", err)
+	}
+	var qe *QueryError
+	if !errors.As(err, &qe) || qe.ID != ts6test.ErrIDInsufficientPermissions {
+		t.Errorf("err = %#v, want QueryError with id %d", err, ts6test.ErrIDInsufficientPermissions)
+	}
+}
+
+func TestSSHClientFloodRetry(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	srv.Flood(2, 10*time.Millisecond)
+
+	raw, err := c.Exec("version")
+	if err != nil {
+		t.Fatalf("Exec after flood: %v", err)
+	}
+	if !strings.Contains(raw, "version=") {
+		t.Errorf("version = %q", raw)
+	}
+	if n := countCommands(srv, "version"); n != 3 {
+		t.Errorf("version sent %d times, want 3", n)
+	}
+}
+
+func TestSSHClientFloodGivesUp(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	srv.Flood(100, time.Millisecond)
+
+	_, err := c.Exec("version")
+	if !errors.Is(err, ErrFlood) {
+		t.Fatalf("err = %v, want ErrFlood", err)
+	}
+	if n := countCommands(srv, "version"); n != 5 {
+		t.Errorf("version sent %d times, want 5", n)
+	}
+}
+
+func TestSSHClientFloodRespectsContext(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	srv.Flood(1, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.ExecContext(ctx, "version"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestSSHClientAbandonedResponse(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	srv.SetResult("channellist", ts6test.Response{Body: "cid=1 pid=0", Delay: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.ExecContext(ctx, "channellist"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+
+	// The late channellist response must not be taken for this one
+	raw, err := c.Exec("version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(raw, "version=") {
+		t.Errorf("version = %q, got the abandoned response", raw)
+	}
+}
+
+func TestSSHClientConnectionLost(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	srv.DropConnections()
+
+	if _, err := c.Exec("version"); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("err = %v, want ErrConnectionLost", err)
+	}
+
+	c.Close()
+	if _, err := c.Exec("version"); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("err after Close = %v, want ErrConnectionLost", err)
+	}
+}
+
+func TestSSHClientWithServer(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	c := newTestClient(t, cfg)
+
+	srv.AddServer("2")
+	srv.SetServerResponse("2", "serverinfo", `virtualserver_id=2 virtualserver_name=Second`)
+
+	err := c.WithServer(context.Background(), "2", func() error {
+		info, err := GetServerInfo(context.Background(), cfg, c)
+		if err != nil {
+			return err
+		}
+		if info.Name != "Second" {
+			t.Errorf("name = %q, want Second", info.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.WithServer(context.Background(), "3", func() error { return nil })
+	if !errors.Is(err, ErrInvalidServerID) {
+		t.Fatalf("err = %v, want ErrInvalidServerID", err)
+	}
+}
@@ -0,0 +1,349 @@
+package ts6
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"ts6-viewer/internal/config"
+)
+
+// ConnState is the lifecycle state of a supervised ServerQuery connection.
+type ConnState int
+
+const (
+	StateConnecting ConnState = iota
+	StateLoggingIn
+	StateSelectingServer
+	StateReady
+	StateBackingOff
+	StateFailed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateLoggingIn:
+		return "logging in"
+	case StateSelectingServer:
+		return "selecting server"
+	case StateReady:
+		return "ready"
+	case StateBackingOff:
+		return "backing off"
+	case StateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+}
+
+// inProgress reports whether a connection attempt is under way.
+func (s ConnState) inProgress() bool {
+	return s == StateConnecting || s == StateLoggingIn || s == StateSelectingServer
+}
+
+// ConnStatus is a snapshot of a supervised connection.
+type ConnStatus struct {
+	State ConnState
+	// Since is when the current state was entered.
+	Since time.Time
+	// DownSince is when the connection was lost or the first attempt
+	// failed. It is zero while the connection is ready.
+	DownSince  time.Time
+	LastError  error
+	Reconnects int
+}
+
+const (
+	// supervisorPingInterval is how often an idle connection is checked,
+	// which also prevents the ServerQuery idle timeout.
+	supervisorPingInterval = 30 * time.Second
+
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 60 * time.Second
+
+	// After breakerThreshold failed attempts in a row the circuit opens:
+	// calls fail immediately and the next attempt waits breakerCooldown.
+	breakerThreshold = 5
+	breakerCooldown  = 2 * time.Minute
+
+	// readyTimeout is how long Do waits for an attempt in progress.
+	readyTimeout = 10 * time.Second
+)
+
+// UnavailableError is returned by Supervisor.Do while there is no usable
+// connection. It matches ErrConnectionLost and the error of the last
+// failed attempt.
+type UnavailableError struct {
+	State ConnState
+	Since time.Time
+	Err   error
+}
+
+func (e *UnavailableError) Error() string {
+	msg := fmt.Sprintf("ts6: server unreachable since %s (%s)", e.Since.Format("15:04:05"), e.State)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *UnavailableError) Unwrap() []error {
+	return []error{ErrConnectionLost, e.Err}
+}
+
+// Supervisor owns the command connection to one TS6 instance. It connects
+// in the background, pings the connection while idle and reconnects with
+// exponential backoff when it is lost. Callers never hold on to the
+// connection itself; they run commands through Do.
+type Supervisor struct {
+	cfg *config.Config
+
+	mu       sync.Mutex
+	client   *SSHClient // nil unless ready
+	status   ConnStatus
+	failures int           // consecutive failed attempts
+	changed  chan struct{} // closed and replaced on every state change
+}
+
+var (
+	// supervised command connections, one per TS6 instance login
+	supervisors   = make(map[string]*Supervisor)
+	supervisorsMu sync.Mutex
+)
+
+// GetSupervisor returns the supervisor of the TS6 instance cfg points to,
+// starting it on first use. Virtual servers on the same instance share it.
+func GetSupervisor(cfg *config.Config) *Supervisor {
+	supervisorsMu.Lock()
+	defer supervisorsMu.Unlock()
+
+	key := InstanceKey(cfg)
+	if s, ok := supervisors[key]; ok {
+		return s
+	}
+
+	s := &Supervisor{
+		cfg:     cfg,
+		status:  ConnStatus{State: StateConnecting, Since: time.Now()},
+		changed: make(chan struct{}),
+	}
+	supervisors[key] = s
+
+	log.Printf("[SSH] Supervising command connection to %s\n", key)
+	go s.run()
+
+	return s
+}
+
+// Status returns the current state of the connection.
+func (s *Supervisor) Status() ConnStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+// Do runs fn on the connection after selecting the virtual server
+// serverID, see SSHClient.WithServer. fn should pass ctx on to the
+// commands it runs. If an attempt to connect is under way, Do waits for it
+// for a short while; otherwise it fails fast with an *UnavailableError. A
+// connection error returned by fn hands the connection back to the
+// supervisor for reconnecting.
+func (s *Supervisor) Do(ctx context.Context, serverID string, fn func(c *SSHClient) error) error {
+	c, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.WithServer(ctx, serverID, func() error {
+		return fn(c)
+	})
+	if errors.Is(err, ErrConnectionLost) {
+		s.lose(c, err)
+	}
+
+	return err
+}
+
+func (s *Supervisor) acquire(ctx context.Context) (*SSHClient, error) {
+	deadline := time.NewTimer(readyTimeout)
+	defer deadline.Stop()
+
+	for {
+		s.mu.Lock()
+		c, st, changed := s.client, s.status, s.changed
+		s.mu.Unlock()
+
+		if c != nil {
+			return c, nil
+		}
+
+		if st.State.inProgress() {
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-deadline.C:
+			}
+		}
+
+		since := st.DownSince
+		if since.IsZero() {
+			since = st.Since
+		}
+		return nil, &UnavailableError{State: st.State, Since: since, Err: st.LastError}
+	}
+}
+
+// setState records a state change and wakes up waiting callers. The caller
+// must hold s.mu.
+func (s *Supervisor) setState(state ConnState) {
+	s.status.State = state
+	s.status.Since = time.Now()
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Supervisor) transition(state ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setState(state)
+}
+
+// run connects, watches the connection until it is lost and starts over.
+func (s *Supervisor) run() {
+	connected := false
+
+	for {
+		c, err := s.connect()
+		if err != nil {
+			wait := s.fail(err)
+			log.Printf("[SSH] Connection to %s failed: %v. Retrying in %v\n", InstanceKey(s.cfg), err, wait)
+			time.Sleep(wait)
+			continue
+		}
+
+		s.mu.Lock()
+		s.client = c
+		s.failures = 0
+		s.status.DownSince = time.Time{}
+		if connected {
+			s.status.Reconnects++
+		}
+		s.setState(StateReady)
+		s.mu.Unlock()
+
+		connected = true
+		log.Printf("[SSH] Command connection to %s ready\n", InstanceKey(s.cfg))
+
+		s.watch(c)
+		s.lose(c, ErrConnectionLost) // if it closed by itself
+
+		// A lost connection is not redialed right away either, so that a
+		// flapping server is not hit by every viewer at the same moment
+		wait := minReconnectBackoff + time.Duration(rand.Int63n(int64(minReconnectBackoff)))
+		log.Printf("[SSH] Reconnecting to %s in %v\n", InstanceKey(s.cfg), wait)
+		time.Sleep(wait)
+	}
+}
+
+// connect dials, logs in and selects the configured virtual server.
+func (s *Supervisor) connect() (*SSHClient, error) {
+	ctx := context.Background()
+
+	s.transition(StateConnecting)
+	c, err := dialSSH(ctx, s.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.transition(StateLoggingIn)
+	if err := c.login(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	s.transition(StateSelectingServer)
+	if err := c.Use(ctx, s.cfg.Teamspeak6.ServerID); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// fail records a failed attempt and returns how long to wait before the
+// next one.
+func (s *Supervisor) fail(err error) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures++
+	s.status.LastError = err
+	if s.status.DownSince.IsZero() {
+		s.status.DownSince = time.Now()
+	}
+
+	if s.failures >= breakerThreshold {
+		s.setState(StateFailed)
+		return breakerCooldown
+	}
+
+	s.setState(StateBackingOff)
+
+	backoff := minReconnectBackoff << (s.failures - 1)
+	if backoff > maxReconnectBackoff {
+		backoff = maxReconnectBackoff
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff/4)+1))
+}
+
+// watch pings the connection until it is lost.
+func (s *Supervisor) watch(c *SSHClient) {
+	ticker := time.NewTicker(supervisorPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			_, err := c.Exec(command("version", nil))
+			if errors.Is(err, ErrConnectionLost) {
+				log.Printf("[SSH] Keepalive failed: %v\n", err)
+				s.lose(c, err)
+				return
+			}
+		}
+	}
+}
+
+// lose takes c out of service after a connection error. It is a no-op if
+// c has already been replaced.
+func (s *Supervisor) lose(c *SSHClient, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != c {
+		return
+	}
+
+	log.Printf("[SSH] Command connection to %s lost: %v\n", InstanceKey(s.cfg), err)
+
+	s.client = nil
+	s.status.LastError = err
+	s.status.DownSince = time.Now()
+	s.setState(StateBackingOff)
+
+	c.Close()
+}
@@ -0,0 +1,110 @@
+package ts6
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/config"
+)
+
+// waitForState polls s until it reaches state with at least reconnects
+// reconnects.
+func waitForState(t *testing.T, s *Supervisor, state ConnState, reconnects int) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		st := s.Status()
+		if st.State == state && st.Reconnects >= reconnects {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("status = %+v, want %s with %d reconnects", s.Status(), state, reconnects)
+}
+
+func execVersion(s *Supervisor) (string, error) {
+	var raw string
+	err := s.Do(context.Background(), "1", func(c *SSHClient) error {
+		var err error
+		raw, err = c.Exec("version")
+		return err
+	})
+	return raw, err
+}
+
+func TestSupervisorReconnects(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	s := GetSupervisor(cfg)
+
+	if _, err := execVersion(s); err != nil {
+		t.Fatalf("first command: %v", err)
+	}
+	waitForState(t, s, StateReady, 0)
+
+	srv.DropConnections()
+
+	// The command that finds the connection dead hands it back
+	if _, err := execVersion(s); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("err = %v, want ErrConnectionLost", err)
+	}
+
+	waitForState(t, s, StateReady, 1)
+
+	raw, err := execVersion(s)
+	if err != nil {
+		t.Fatalf("command after reconnect: %v", err)
+	}
+	if !strings.Contains(raw, "version=") {
+		t.Errorf("version = %q", raw)
+	}
+	if n := srv.Connections(); n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+}
+
+func TestSupervisorShared(t *testing.T) {
+	_, cfg := newTestServer(t)
+
+	other := *cfg
+	other.Teamspeak6.ServerID = "2"
+	if GetSupervisor(cfg) != GetSupervisor(&other) {
+		t.Error("virtual servers of one instance got different supervisors")
+	}
+}
+
+func TestSupervisorUnavailable(t *testing.T) {
+	// A port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	cfg := &config.Config{Teamspeak6: config.Teamspeak6{
+		Host:               host,
+		Port:               port,
+		User:               "serveradmin",
+		ServerID:           "1",
+		HostKeyFingerprint: "SHA256:unused",
+	}}
+	s := GetSupervisor(cfg)
+
+	_, err = execVersion(s)
+
+	var ue *UnavailableError
+	if !errors.As(err, &ue) {
+		t.Fatalf("err = %v, want *UnavailableError", err)
+	}
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("err = %v does not match ErrConnectionLost", err)
+	}
+	if ue.Since.IsZero() {
+		t.Error("Since is zero")
+	}
+}
@@ -0,0 +1,112 @@
+package ts6test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ftKeyLength is the length of the key a client sends on the file
+// transfer port, as on real servers.
+const ftKeyLength = 32
+
+// SetFile makes ftinitdownload serve data under name, e.g. "/icon_123",
+// on every virtual server.
+func (s *Server) SetFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[name] = data
+}
+
+// RemoveFile makes ftinitdownload report name as missing.
+func (s *Server) RemoveFile(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, name)
+}
+
+// Downloads returns the number of files sent on the file transfer port.
+func (s *Server) Downloads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.downloads
+}
+
+// initDownload answers ftinitdownload with a key for the transfer
+// listener. Like real servers, a missing file is reported in the response
+// body with status and msg rather than in the status line. The caller
+// must hold s.mu.
+func (s *Server) initDownload(req *Request) Response {
+	id := req.Params["clientftfid"]
+
+	data, ok := s.files[req.Params["name"]]
+	if !ok {
+		return Response{Body: fmt.Sprintf("clientftfid=%s status=%d msg=%s size=0", id, ErrIDFileNotFound, escape("file not found"))}
+	}
+
+	if s.ftListener == nil {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return Response{ID: ErrIDCommandNotFound, Msg: "file transfer unavailable"}
+		}
+		s.ftListener = ln
+
+		s.wg.Add(1)
+		go s.transferLoop(ln)
+	}
+
+	raw := make([]byte, ftKeyLength/2)
+	rand.Read(raw)
+	key := hex.EncodeToString(raw)
+	s.transfers[key] = data
+	s.ftIDs++
+
+	_, port, _ := net.SplitHostPort(s.ftListener.Addr().String())
+
+	return Response{Body: fmt.Sprintf("clientftfid=%s serverftfid=%d ftkey=%s port=%s size=%d ip=%s",
+		id, s.ftIDs, key, port, len(data), escape("0.0.0.0,::"))}
+}
+
+// transferLoop serves the file transfer port: every connection sends a
+// key and receives the file it was issued for.
+func (s *Server) transferLoop(ln net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+			key := make([]byte, ftKeyLength)
+			if _, err := io.ReadFull(conn, key); err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			data, ok := s.transfers[string(key)]
+			delete(s.transfers, string(key))
+			if ok {
+				s.downloads++
+			}
+			s.mu.Unlock()
+
+			if ok {
+				conn.Write(data)
+			}
+		}()
+	}
+}
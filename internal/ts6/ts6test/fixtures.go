@@ -0,0 +1,30 @@
+package ts6test
+
+// defaultFixtures answer the commands the viewer issues. The virtual server
+// has a lobby with one user, a sub-channel, a spacer and the query client
+// itself, and the default server and channel groups.
+var defaultFixtures = map[string]string{
+	"channellist": `cid=1 pid=0 channel_order=0 channel_name=Lobby channel_topic=Welcome channel_flag_default=1 channel_flag_password=0 channel_flag_permanent=1 channel_flag_semi_permanent=0 channel_maxclients=-1 channel_maxfamilyclients=-1 channel_flag_maxclients_unlimited=1 channel_flag_maxfamilyclients_unlimited=1 channel_needed_talk_power=0 channel_codec=4 channel_codec_quality=6 total_clients=2 channel_icon_id=0 seconds_empty=-1` +
+		`|cid=2 pid=1 channel_order=0 channel_name=Gaming channel_topic= channel_flag_default=0 channel_flag_password=1 channel_flag_permanent=1 channel_flag_semi_permanent=0 channel_maxclients=5 channel_maxfamilyclients=-1 channel_flag_maxclients_unlimited=0 channel_flag_maxfamilyclients_unlimited=1 channel_needed_talk_power=0 channel_codec=4 channel_codec_quality=6 total_clients=0 channel_icon_id=0 seconds_empty=120` +
+		`|cid=3 pid=0 channel_order=1 channel_name=[cspacer0]---\sAFK\s--- channel_topic= channel_flag_default=0 channel_flag_password=0 channel_flag_permanent=1 channel_flag_semi_permanent=0 channel_maxclients=0 channel_maxfamilyclients=-1 channel_flag_maxclients_unlimited=0 channel_flag_maxfamilyclients_unlimited=1 channel_needed_talk_power=0 channel_codec=4 channel_codec_quality=6 total_clients=0 channel_icon_id=0 seconds_empty=3600`,
+
+	"clientlist": `clid=1 cid=1 client_database_id=1 client_nickname=serveradmin client_type=1 client_unique_identifier=serveradmin client_away=0 client_away_message client_input_muted=0 client_output_muted=0 client_outputonly_muted=0 client_input_hardware=0 client_output_hardware=0 client_talk_power=0 client_is_talking=0 client_servergroups=2 client_channel_group_id=8 client_idle_time=0 client_connection_connected_time=60000 client_country client_icon_id=0 client_version=ServerQuery client_platform=ServerQuery` +
+		`|clid=5 cid=1 client_database_id=3 client_nickname=Alice client_type=0 client_unique_identifier=YWxpY2UtdWlkLWZvci10ZXN0cz0= client_away=0 client_away_message client_input_muted=0 client_output_muted=0 client_outputonly_muted=0 client_input_hardware=1 client_output_hardware=1 client_talk_power=75 client_is_talking=0 client_servergroups=6,9 client_channel_group_id=5 client_idle_time=1500 client_connection_connected_time=3600000 client_country=DE client_icon_id=0 client_version=6.0.0\s[Build:\s1700000000] client_platform=Windows`,
+
+	"serverinfo": `virtualserver_id=1 virtualserver_name=Test\sServer virtualserver_uptime=3600 virtualserver_clientsonline=2 virtualserver_queryclientsonline=1 virtualserver_maxclients=32 virtualserver_channelsonline=3 virtualserver_hostbanner_url virtualserver_hostbanner_gfx_url virtualserver_welcomemessage=Welcome\sto\s[b]Test\sServer[\/b]! virtualserver_needed_identity_security_level=8 virtualserver_query_client_connections=12 virtualserver_client_connections=42`,
+
+	"servergrouplist": `sgid=1 name=Guest\sServer\sQuery type=2 iconid=0 savedb=0 sortid=0 namemode=0 n_modifyp=0 n_member_addp=0 n_member_removep=0` +
+		`|sgid=2 name=Admin\sServer\sQuery type=2 iconid=500 savedb=1 sortid=0 namemode=0 n_modifyp=100 n_member_addp=100 n_member_removep=100` +
+		`|sgid=6 name=Server\sAdmin type=1 iconid=300 savedb=1 sortid=0 namemode=0 n_modifyp=75 n_member_addp=75 n_member_removep=75` +
+		`|sgid=8 name=Guest type=1 iconid=0 savedb=0 sortid=0 namemode=0 n_modifyp=0 n_member_addp=0 n_member_removep=0` +
+		`|sgid=9 name=Member type=1 iconid=100 savedb=1 sortid=10 namemode=0 n_modifyp=25 n_member_addp=25 n_member_removep=25`,
+
+	"channelgrouplist": `cgid=5 name=Channel\sAdmin type=1 iconid=100 savedb=1 sortid=0 namemode=0 n_modifyp=75 n_member_addp=75 n_member_removep=75` +
+		`|cgid=8 name=Guest type=1 iconid=0 savedb=0 sortid=0 namemode=0 n_modifyp=0 n_member_addp=0 n_member_removep=0`,
+
+	"clientinfo": `cid=1 client_idle_time=1500 client_unique_identifier=YWxpY2UtdWlkLWZvci10ZXN0cz0= client_nickname=Alice client_version=6.0.0\s[Build:\s1700000000] client_platform=Windows client_input_muted=0 client_output_muted=0 client_input_hardware=1 client_output_hardware=1 client_database_id=3 client_channel_group_id=5 client_servergroups=6,9 client_type=0 client_flag_avatar client_talk_power=75 client_base64HashClientUID=gbhmjgdlgdbgjmhehnilgbiocheefaocpmdnbmji client_country=DE client_icon_id=0`,
+
+	"channelinfo": `pid=0 channel_name=Lobby channel_topic=Welcome channel_description=Welcome\sto\sthe\s[b]Lobby[\/b].\nPlease\sbe\snice. channel_password channel_codec=4 channel_codec_quality=6 channel_maxclients=-1 channel_maxfamilyclients=-1 channel_order=0 channel_flag_permanent=1 channel_flag_semi_permanent=0 channel_flag_default=1 channel_flag_password=0 channel_codec_latency_factor=1 channel_codec_is_unencrypted=1 channel_security_salt channel_delete_delay=0 channel_flag_maxclients_unlimited=1 channel_flag_maxfamilyclients_unlimited=1 channel_flag_maxfamilyclients_inherited=0 channel_filepath=files\/virtualserver_1\/channel_1 channel_needed_talk_power=0 channel_forced_silence=0 channel_name_phonetic channel_icon_id=0 channel_banner_gfx_url channel_banner_mode=0 seconds_empty=-1`,
+
+	"version": `version=6.0.0 build=1700000000 platform=Linux`,
+}
@@ -0,0 +1,612 @@
+// Package ts6test provides an in-process fake TS6 ServerQuery server for
+// tests. It speaks the ServerQuery dialect over a real SSH connection on
+// localhost, so SSHClient, the supervisor and the HTTP router can be
+// exercised end to end without a TeamSpeak installation:
+//
+//	srv, err := ts6test.NewServer()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//
+//	cfg := &config.Config{Teamspeak6: srv.Teamspeak6()}
+//	srv.SetResponse("channellist", `cid=1 pid=0 channel_order=0 channel_name=Lobby`)
+//	srv.Flood(2, 100*time.Millisecond)
+//
+// Responses are scripted per command name, optionally per virtual server,
+// and can be replaced by a Handler for full control. The package does not
+// import ts6 so that the tests of package ts6 itself can use it.
+package ts6test
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"ts6-viewer/internal/config"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Default credentials and virtual server of a new Server.
+const (
+	DefaultUser     = "serveradmin"
+	DefaultPassword = "secret"
+	DefaultServerID = "1"
+)
+
+// Error IDs the fake server answers with.
+const (
+	ErrIDOK                      = 0
+	ErrIDCommandNotFound         = 256
+	ErrIDInvalidLogin            = 520
+	ErrIDFlood                   = 524
+	ErrIDInvalidServerID         = 1024
+	ErrIDFileNotFound            = 2051
+	ErrIDInsufficientPermissions = 2568
+)
+
+// Lines end in "\n\r" like on real servers.
+const (
+	lineEnd = "\n\r"
+	banner  = "TS3" + lineEnd + "Welcome to the TeamSpeak ServerQuery interface, type \"help\" for a list of commands and \"help <command>\" for information on a specific command." + lineEnd
+)
+
+// Request is a command received by the server.
+type Request struct {
+	Name     string
+	Params   map[string]string // unescaped
+	Flags    []string          // options such as "-voice"
+	Raw      string
+	ServerID string // virtual server selected with "use", empty if none
+}
+
+// Response is the server's answer to a command.
+type Response struct {
+	// Body holds the response data without the status line, e.g.
+	// "cid=1 pid=0|cid=2 pid=0". Values must already be escaped.
+	Body string
+
+	// ID, Msg and ExtraMsg form the status line. A zero ID answers
+	// "error id=0 msg=ok".
+	ID       int
+	Msg      string
+	ExtraMsg string
+
+	// Delay holds the answer back, e.g. to provoke client timeouts.
+	Delay time.Duration
+
+	// Disconnect drops the connection instead of answering.
+	Disconnect bool
+}
+
+// Handler answers a command. It runs on the session's goroutine.
+type Handler func(req *Request) Response
+
+// Server is a fake ServerQuery SSH server listening on localhost.
+type Server struct {
+	// Host and Port the server listens on.
+	Host string
+	Port string
+
+	// Credentials accepted for SSH and for the login command.
+	User     string
+	Password string
+
+	hostKey  ssh.Signer
+	listener net.Listener
+	sshCfg   *ssh.ServerConfig
+
+	mu        sync.Mutex
+	servers   map[string]bool       // existing virtual servers
+	responses map[string]Response   // keyed by "sid/command" or "command"
+	handlers  map[string]Handler    // keyed by command name
+	commands  []string              // every command received, in order
+	sessions  map[*session]struct{} // open sessions
+	open      map[net.Conn]struct{} // open connections, with or without session
+	floods    int                   // commands still to reject as flooding
+	floodWait time.Duration         // wait time announced with floods
+	conns     int                   // connections accepted so far
+
+	files      map[string][]byte // served by ftinitdownload, keyed by name
+	transfers  map[string][]byte // pending downloads keyed by ftkey
+	ftListener net.Listener      // file transfer port, opened on first use
+	ftIDs      int               // transfers started so far
+	downloads  int               // files sent so far
+
+	wg sync.WaitGroup
+}
+
+// session is one ServerQuery connection.
+type session struct {
+	srv  *Server
+	conn *ssh.ServerConn
+	ch   ssh.Channel
+
+	writeMu sync.Mutex
+
+	// guarded by srv.mu
+	loggedIn   bool
+	serverID   string
+	registered bool
+}
+
+// NewServer starts a fake server on 127.0.0.1 with an ephemeral port, the
+// default credentials and virtual server, and default fixtures for
+// channellist, clientlist, serverinfo and version.
+func NewServer() (*Server, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ts6test: generate host key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("ts6test: host key: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("ts6test: listen: %w", err)
+	}
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	s := &Server{
+		Host:      host,
+		Port:      port,
+		User:      DefaultUser,
+		Password:  DefaultPassword,
+		hostKey:   signer,
+		listener:  ln,
+		servers:   map[string]bool{DefaultServerID: true},
+		responses: make(map[string]Response),
+		handlers:  make(map[string]Handler),
+		sessions:  make(map[*session]struct{}),
+		open:      make(map[net.Conn]struct{}),
+		files:     make(map[string][]byte),
+		transfers: make(map[string][]byte),
+	}
+
+	s.sshCfg = &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if meta.User() != s.User || string(password) != s.Password {
+				return nil, fmt.Errorf("invalid credentials for %q", meta.User())
+			}
+			return nil, nil
+		},
+	}
+	s.sshCfg.AddHostKey(signer)
+
+	for cmd, body := range defaultFixtures {
+		s.responses[cmd] = Response{Body: body}
+	}
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, s.Port)
+}
+
+// Fingerprint returns the SHA256 fingerprint of the server's host key, as
+// expected by host_key_fingerprint.
+func (s *Server) Fingerprint() string {
+	return ssh.FingerprintSHA256(s.hostKey.PublicKey())
+}
+
+// Teamspeak6 returns a configuration block that connects to the server
+// with the host key pinned.
+func (s *Server) Teamspeak6() config.Teamspeak6 {
+	return config.Teamspeak6{
+		Name:               config.DefaultServerName,
+		Host:               s.Host,
+		Port:               s.Port,
+		User:               s.User,
+		Password:           s.Password,
+		ServerID:           DefaultServerID,
+		EnableVoiceStatus:  "true",
+		HostKeyFingerprint: s.Fingerprint(),
+	}
+}
+
+// AddServer makes a virtual server ID selectable with "use".
+func (s *Server) AddServer(serverID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.servers[serverID] = true
+}
+
+// SetResponse sets the response body of a command for all virtual servers.
+func (s *Server) SetResponse(cmd, body string) {
+	s.SetResult(cmd, Response{Body: body})
+}
+
+// SetServerResponse sets the response body of a command for one virtual
+// server. It takes precedence over SetResponse.
+func (s *Server) SetServerResponse(serverID, cmd, body string) {
+	s.SetResult(serverID+"/"+cmd, Response{Body: body})
+}
+
+// SetError makes a command fail with the given error ID and message.
+func (s *Server) SetError(cmd string, id int, msg string) {
+	s.SetResult(cmd, Response{ID: id, Msg: msg})
+}
+
+// SetResult sets the full response of a command. key is a command name or
+// "sid/command" for a single virtual server.
+func (s *Server) SetResult(key string, resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[key] = resp
+}
+
+// Handle answers a command with h instead of the scripted response.
+func (s *Server) Handle(cmd string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[cmd] = h
+}
+
+// Flood rejects the next n commands after login with error 524, announcing
+// wait as the time to back off.
+func (s *Server) Flood(n int, wait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.floods = n
+	s.floodWait = wait
+}
+
+// Notify sends a raw notification line, such as
+// "notifyclientleftview cfid=1 ctid=0 reasonid=8 clid=5", to every session
+// registered with servernotifyregister.
+func (s *Server) Notify(line string) {
+	s.mu.Lock()
+	var targets []*session
+	for sess := range s.sessions {
+		if sess.registered {
+			targets = append(targets, sess)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sess := range targets {
+		sess.write(line + lineEnd)
+	}
+}
+
+// DropConnections closes every open connection, as a server restart or
+// network failure would. This includes connections still in the handshake
+// or without a session yet.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	var open []net.Conn
+	for conn := range s.open {
+		open = append(open, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range open {
+		conn.Close()
+	}
+}
+
+// Commands returns every command received so far, in order. Login
+// commands are included with the password redacted.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+// Connections returns the number of SSH connections accepted so far.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conns
+}
+
+// Close stops the server and drops all connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	if s.ftListener != nil {
+		s.ftListener.Close()
+	}
+	s.mu.Unlock()
+
+	s.DropConnections()
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.open[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.open, conn)
+				s.mu.Unlock()
+			}()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshCfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+
+	s.mu.Lock()
+	s.conns++
+	s.mu.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			return
+		}
+
+		sess := &session{srv: s, conn: sshConn, ch: ch}
+
+		s.mu.Lock()
+		s.sessions[sess] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.sessions, sess)
+				s.mu.Unlock()
+			}()
+			sess.serve(chReqs)
+		}()
+	}
+}
+
+// serve waits for the shell request and then runs the command loop.
+func (sess *session) serve(reqs <-chan *ssh.Request) {
+	defer sess.ch.Close()
+
+	for req := range reqs {
+		switch req.Type {
+		case "shell":
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+			sess.run()
+			return
+		case "pty-req", "env":
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (sess *session) run() {
+	sess.write(banner)
+
+	r := bufio.NewReader(sess.ch)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				sess.conn.Close()
+			}
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if line == "quit" {
+			sess.conn.Close()
+			return
+		}
+
+		resp := sess.handle(parseRequest(line))
+
+		if resp.Delay > 0 {
+			time.Sleep(resp.Delay)
+		}
+		if resp.Disconnect {
+			sess.conn.Close()
+			return
+		}
+
+		sess.write(formatResponse(resp))
+	}
+}
+
+// handle dispatches a request to the built-in commands, a Handler or the
+// scripted responses.
+func (sess *session) handle(req *Request) Response {
+	s := sess.srv
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req.ServerID = sess.serverID
+
+	if req.Name == "login" {
+		// Keep the password out of the command log
+		s.commands = append(s.commands, "login client_login_name="+escape(req.Params["client_login_name"])+" client_login_password=***")
+
+		if req.Params["client_login_name"] != s.User || req.Params["client_login_password"] != s.Password {
+			return Response{ID: ErrIDInvalidLogin, Msg: "invalid loginname or password"}
+		}
+		sess.loggedIn = true
+		return Response{}
+	}
+
+	s.commands = append(s.commands, req.Raw)
+
+	if !sess.loggedIn {
+		return Response{ID: ErrIDInsufficientPermissions, Msg: "insufficient client permissions"}
+	}
+
+	if s.floods > 0 {
+		s.floods--
+		return floodResponse(s.floodWait)
+	}
+
+	if h, ok := s.handlers[req.Name]; ok {
+		s.mu.Unlock()
+		resp := h(req)
+		s.mu.Lock()
+		return resp
+	}
+
+	switch req.Name {
+	case "use":
+		sid := req.Params["sid"]
+		if !s.servers[sid] {
+			return Response{ID: ErrIDInvalidServerID, Msg: "invalid serverID"}
+		}
+		sess.serverID = sid
+		return Response{}
+
+	case "servernotifyregister":
+		sess.registered = true
+		return Response{}
+
+	case "ftinitdownload":
+		return s.initDownload(req)
+	}
+
+	if resp, ok := s.responses[sess.serverID+"/"+req.Name]; ok {
+		return resp
+	}
+	if resp, ok := s.responses[req.Name]; ok {
+		return resp
+	}
+
+	return Response{ID: ErrIDCommandNotFound, Msg: "command not found"}
+}
+
+func (sess *session) write(data string) {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+
+	_, _ = io.WriteString(sess.ch, data)
+}
+
+// floodResponse announces wait the way TS servers do, in seconds where
+// possible.
+func floodResponse(wait time.Duration) Response {
+	extra := fmt.Sprintf("please wait %d milliseconds", wait.Milliseconds())
+	if wait > 0 && wait%time.Second == 0 {
+		extra = fmt.Sprintf("please wait %d seconds", int(wait/time.Second))
+	}
+
+	return Response{ID: ErrIDFlood, Msg: "client is flooding", ExtraMsg: extra}
+}
+
+func formatResponse(resp Response) string {
+	var b strings.Builder
+
+	if resp.Body != "" {
+		b.WriteString(resp.Body)
+		b.WriteString(lineEnd)
+	}
+
+	msg := resp.Msg
+	if resp.ID == ErrIDOK && msg == "" {
+		msg = "ok"
+	}
+
+	fmt.Fprintf(&b, "error id=%d msg=%s", resp.ID, escape(msg))
+	if resp.ExtraMsg != "" {
+		b.WriteString(" extra_msg=" + escape(resp.ExtraMsg))
+	}
+	b.WriteString(lineEnd)
+
+	return b.String()
+}
+
+// parseRequest splits a command line into its name, parameters and flags.
+func parseRequest(line string) *Request {
+	fields := strings.Fields(line)
+
+	req := &Request{
+		Name:   fields[0],
+		Params: make(map[string]string),
+		Raw:    line,
+	}
+
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "-") {
+			req.Flags = append(req.Flags, f)
+			continue
+		}
+		key, val, _ := strings.Cut(f, "=")
+		req.Params[key] = unescape(val)
+	}
+
+	return req
+}
+
+var (
+	escaper = strings.NewReplacer(
+		`\`, `\\`, `/`, `\/`, ` `, `\s`, `|`, `\p`,
+		"\a", `\a`, "\b", `\b`, "\f", `\f`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "\v", `\v`,
+	)
+	unescaper = strings.NewReplacer(
+		`\\`, `\`, `\/`, `/`, `\s`, ` `, `\p`, `|`,
+		`\a`, "\a", `\b`, "\b", `\f`, "\f", `\n`, "\n", `\r`, "\r", `\t`, "\t", `\v`, "\v",
+	)
+)
+
+// escape and unescape implement the ServerQuery escaping independently of
+// package ts6, so that the fake server does not trust the code under test.
+func escape(s string) string   { return escaper.Replace(s) }
+func unescape(s string) string { return unescaper.Replace(s) }
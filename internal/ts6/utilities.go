@@ -1,22 +1,107 @@
 package ts6
 
 import (
+	"sort"
 	"strings"
 )
 
-func UnescapeTS6(s string) string {
-	replacer := strings.NewReplacer(
-		`\s`, " ",
-		`\p`, "|",
-		`\/`, "/",
-		`\:`, ":",
-		`\.`, ".",
-		`\(`, "(",
-		`\)`, ")",
-		`\?`, "?",
-		`\!`, "!",
-		`\-`, "-",
-		`\_`, "_",
-	)
-	return replacer.Replace(s)
+// escapes maps characters to their ServerQuery escape sequence. All of them
+// are ASCII, so escaping works byte-wise and leaves invalid UTF-8 intact.
+var escapes = map[byte]string{
+	'\\': `\\`,
+	'/':  `\/`,
+	' ':  `\s`,
+	'|':  `\p`,
+	'\a': `\a`,
+	'\b': `\b`,
+	'\f': `\f`,
+	'\n': `\n`,
+	'\r': `\r`,
+	'\t': `\t`,
+	'\v': `\v`,
+}
+
+// unescapes maps the character following a backslash to the character it
+// stands for.
+var unescapes = map[byte]byte{
+	'\\': '\\',
+	'/':  '/',
+	's':  ' ',
+	'p':  '|',
+	'a':  '\a',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+}
+
+// Escape encodes s for use as a ServerQuery parameter value.
+func Escape(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		if esc, ok := escapes[s[i]]; ok {
+			b.WriteString(esc)
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// Unescape decodes a ServerQuery value in a single pass, so that "\\s" is
+// read as a backslash followed by "s". Escaped characters without a special
+// meaning, such as the "\:" or "\." TS6 sends for punctuation, decode to the
+// character itself. A trailing lone backslash is kept.
+func Unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		if u, ok := unescapes[s[i]]; ok {
+			b.WriteByte(u)
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+// command formats a ServerQuery command. Parameter values are escaped and
+// written in key order; flags such as "-voice" are appended unchanged.
+func command(name string, params map[string]string, flags ...string) string {
+	var b strings.Builder
+	b.WriteString(name)
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b.WriteString(" " + k + "=" + Escape(params[k]))
+	}
+
+	for _, f := range flags {
+		b.WriteString(" " + f)
+	}
+
+	return b.String()
 }
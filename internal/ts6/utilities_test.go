@@ -0,0 +1,42 @@
+package ts6
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzEscapeRoundTrip(f *testing.F) {
+	for _, s := range []string{
+		"",
+		" ",
+		"|",
+		`\`,
+		"/",
+		"\a\b\f\n\r\t\v",
+		`\s\p\\`,
+		"Lobby | AFK",
+		"[cspacer0]--- AFK ---",
+		"trailing backslash\\",
+		"http://example.com/a b",
+		"Ünïcödé ✓",
+		"\xff\xfe invalid UTF-8",
+	} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		escaped := Escape(s)
+
+		if got := Unescape(escaped); got != s {
+			t.Fatalf("Unescape(Escape(%q)) = %q", s, got)
+		}
+
+		// Every character with an escape sequence is escaped. Other
+		// control characters have none in ServerQuery and pass through.
+		for c := range escapes {
+			if c != '\\' && c != '/' && strings.IndexByte(escaped, c) >= 0 {
+				t.Fatalf("Escape(%q) = %q contains raw %q", s, escaped, c)
+			}
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package ts6
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ts6-viewer/internal/config"
+)
+
+const (
+	// eventKeepAliveInterval is how often the event connection sends a
+	// version command to prevent the ServerQuery idle timeout.
+	eventKeepAliveInterval = 30 * time.Second
+
+	maxEventBackoff = 60 * time.Second
+
+	// groupRefreshInterval is how often the server and channel groups are
+	// fetched along with a resync.
+	groupRefreshInterval = 10 * time.Minute
+)
+
+// Watcher keeps the Model of one virtual server current. It listens for
+// ServerQuery notifications on a dedicated event connection and
+// periodically replaces the model with a full resync as a safety net for
+// missed events and for properties the server does not notify about, such
+// as mute and talk status.
+//
+// Notification registrations are bound to the selected virtual server, so
+// every watcher needs its own event connection. Resyncs go through the
+// command connection shared by all virtual servers of the instance.
+type Watcher struct {
+	cfg            *config.Config
+	model          *Model
+	supervisor     *Supervisor
+	resyncInterval time.Duration
+
+	resyncMu      sync.Mutex // serializes resyncs on the shared command connection
+	groupsFetched time.Time  // guarded by resyncMu
+}
+
+// NewWatcher creates a watcher for the virtual server described by cfg.
+func NewWatcher(cfg *config.Config, resyncInterval time.Duration) *Watcher {
+	if resyncInterval <= 0 {
+		resyncInterval = 60 * time.Second
+	}
+
+	return &Watcher{
+		cfg:            cfg,
+		model:          NewModel(cfg.Teamspeak6.EnableVoiceStatus == "true"),
+		supervisor:     GetSupervisor(cfg),
+		resyncInterval: resyncInterval,
+	}
+}
+
+// Model returns the model maintained by the watcher.
+func (w *Watcher) Model() *Model {
+	return w.model
+}
+
+// Status returns the state of the command connection the watcher resyncs
+// over.
+func (w *Watcher) Status() ConnStatus {
+	return w.supervisor.Status()
+}
+
+// Start launches the event listener and the periodic resync in the
+// background. Both stop when ctx is done.
+func (w *Watcher) Start(ctx context.Context) {
+	go w.listenLoop(ctx)
+	go w.resyncLoop(ctx)
+}
+
+// Resync replaces the model with channellist, clientlist and serverinfo
+// fetched over the supervised command connection of the instance. Every
+// groupRefreshInterval, the server and channel groups are fetched too.
+func (w *Watcher) Resync(ctx context.Context) error {
+	w.resyncMu.Lock()
+	defer w.resyncMu.Unlock()
+
+	var (
+		channels []Channel
+		clients  []Client
+		info     *ServerInfo
+
+		serverGroups  []ServerGroup
+		channelGroups []ChannelGroup
+		groupsErr     error
+	)
+
+	fetchGroups := time.Since(w.groupsFetched) >= groupRefreshInterval
+
+	err := w.supervisor.Do(ctx, w.cfg.Teamspeak6.ServerID, func(sshClient *SSHClient) error {
+		var err error
+
+		if channels, err = GetChannelList(ctx, w.cfg, sshClient); err != nil {
+			return err
+		}
+		if clients, err = GetClientList(ctx, w.cfg, sshClient); err != nil {
+			return err
+		}
+		if info, err = GetServerInfo(ctx, w.cfg, sshClient); err != nil {
+			return err
+		}
+
+		// Badges are optional; a query user lacking the permission to
+		// list groups still gets the channel tree
+		if fetchGroups {
+			serverGroups, groupsErr = GetServerGroupList(ctx, w.cfg, sshClient)
+			if groupsErr == nil {
+				channelGroups, groupsErr = GetChannelGroupList(ctx, w.cfg, sshClient)
+			}
+			if errors.Is(groupsErr, ErrConnectionLost) {
+				return groupsErr
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if fetchGroups {
+		w.groupsFetched = time.Now()
+		if groupsErr != nil {
+			log.Printf("[SSH] Fetching groups of %q failed: %v\n", w.cfg.Teamspeak6.Name, groupsErr)
+		} else {
+			w.model.SetGroups(serverGroups, channelGroups)
+		}
+	}
+
+	w.model.Replace(channels, clients, info)
+	log.Printf("[SSH] Model %q resynced: %d channels, %d clients\n", w.cfg.Teamspeak6.Name, len(channels), len(clients))
+
+	return nil
+}
+
+func (w *Watcher) resyncLoop(ctx context.Context) {
+	ticker := time.NewTicker(w.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Resync(ctx); err != nil {
+				log.Printf("[SSH] Periodic resync of %q failed: %v\n", w.cfg.Teamspeak6.Name, err)
+			}
+		}
+	}
+}
+
+// listenLoop keeps the event connection alive, reconnecting with a capped
+// exponential backoff whenever it drops.
+func (w *Watcher) listenLoop(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		started := time.Now()
+		err := w.listen(ctx)
+		log.Printf("[SSH] Event listener for %q stopped: %v\n", w.cfg.Teamspeak6.Name, err)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > maxEventBackoff {
+			backoff = time.Second
+		}
+
+		log.Printf("[SSH] Reconnecting event listener in %v\n", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxEventBackoff {
+			backoff = maxEventBackoff
+		}
+	}
+}
+
+// listen opens the event connection, registers for server and channel
+// events, resyncs once and then applies notifications until the
+// connection fails.
+func (w *Watcher) listen(ctx context.Context) error {
+	c, err := newSSHClientBase(ctx, w.cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Use(ctx, w.cfg.Teamspeak6.ServerID); err != nil {
+		return err
+	}
+
+	for _, cmd := range []string{
+		command("servernotifyregister", map[string]string{"event": "server"}),
+		command("servernotifyregister", map[string]string{"event": "channel", "id": "0"}),
+	} {
+		if _, err := c.ExecContext(ctx, cmd); err != nil {
+			return fmt.Errorf("failed to register for notifications: %w", err)
+		}
+	}
+
+	log.Printf("[SSH] Registered for server and channel notifications of %q\n", w.cfg.Teamspeak6.Name)
+
+	// Events may have been missed while the listener was down
+	if err := w.Resync(ctx); err != nil {
+		log.Printf("[SSH] Resync after event registration failed: %v\n", err)
+	}
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				return fmt.Errorf("event connection lost: %w", c.readErr)
+			}
+			if n, ok := ParseNotification(line); ok {
+				w.model.Apply(n)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-keepAlive.C:
+			if err := c.send(command("version", nil)); err != nil {
+				return fmt.Errorf("event keepalive failed: %w", err)
+			}
+		}
+	}
+}
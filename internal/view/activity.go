@@ -0,0 +1,41 @@
+package view
+
+import (
+	"time"
+
+	"ts6-viewer/internal/activity"
+	"ts6-viewer/internal/ts6"
+)
+
+type VMActivity struct {
+	Time     string // local time of day
+	DateTime string // RFC 3339, for <time datetime>
+	Kind     string
+	Nickname string
+	Detail   string
+}
+
+// ChannelNames maps channel IDs to their names as displayed, without
+// spacer markup.
+func ChannelNames(channels []ts6.Channel) map[int]string {
+	names := make(map[int]string, len(channels))
+	for _, ch := range channels {
+		_, _, _, name := ParseChannelName(ch.Name)
+		names[ch.CID] = name
+	}
+	return names
+}
+
+func BuildVMActivity(events []activity.Event) []*VMActivity {
+	vms := make([]*VMActivity, 0, len(events))
+	for _, e := range events {
+		vms = append(vms, &VMActivity{
+			Time:     e.Time.Format("15:04"),
+			DateTime: e.Time.Format(time.RFC3339),
+			Kind:     string(e.Kind),
+			Nickname: e.Nickname,
+			Detail:   e.Detail(),
+		})
+	}
+	return vms
+}
@@ -0,0 +1,436 @@
+// Package bbcode renders the BBCode of TeamSpeak channel descriptions,
+// topics and welcome messages as HTML that is safe to embed in a page.
+//
+// Text is always escaped. Only the tags below produce markup, and only
+// with valid attributes; anything else, including misnested closing tags,
+// is shown as the text it was written as:
+//
+//	[b] [i] [u] [s]            bold, italic, underlined, struck through
+//	[color=#f00] [color=red]   colour, as a font attribute for the CSP
+//	[size=12] [size=+2]        font size
+//	[url] [url=...]            links with http or https URLs
+//	[img]                      http or https images, passed through Options.ImageURL
+//	[center] [left] [right]    alignment
+//	[list] [list=1] [*]        bulleted and numbered lists
+//	[table] [tr] [td] [th]     tables
+//	[hr]                       horizontal line
+//
+// Every element that is opened is closed, so the output cannot end
+// elements of the surrounding page.
+package bbcode
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxDepth bounds the nesting of tags; deeper tags are shown as text.
+	maxDepth = 32
+
+	// maxTagLength bounds the length of a tag including its attribute.
+	maxTagLength = 512
+)
+
+// Options control how images are rendered.
+type Options struct {
+	// ImageURL returns the address an image is loaded from, e.g. through
+	// an image proxy, or "" to link to the image instead. Without it,
+	// images are shown as links, since the page may only load images
+	// from the viewer itself.
+	ImageURL func(src string) string
+}
+
+// urlSchemes are the URL schemes links and images may use.
+var urlSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+}
+
+var (
+	reHexColor   = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	reNamedColor = regexp.MustCompile(`^[a-zA-Z]{1,20}$`)
+)
+
+// Kinds of open elements, which decide where list items, table rows and
+// cells may appear.
+const (
+	kindInline = iota
+	kindBlock
+	kindList
+	kindItem
+	kindTable
+	kindRow
+	kindCell
+	kindLink
+)
+
+type frame struct {
+	tag   string // BBCode tag that closes it
+	close string // HTML that closes it
+	kind  int
+}
+
+type renderer struct {
+	b     strings.Builder
+	stack []frame
+	opts  *Options
+}
+
+// Render converts BBCode to HTML. opts may be nil.
+func Render(s string, opts *Options) template.HTML {
+	if opts == nil {
+		opts = &Options{}
+	}
+	r := &renderer{opts: opts}
+	r.b.Grow(len(s) + len(s)/4)
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '[')
+		if i < 0 {
+			r.text(s)
+			break
+		}
+		r.text(s[:i])
+		s = s[i:]
+
+		t, n, ok := parseTag(s)
+		if !ok {
+			r.text("[")
+			s = s[1:]
+			continue
+		}
+
+		// [url] and [img] take their content as the address
+		if !t.closing && t.value == "" && (t.name == "url" || t.name == "img") {
+			if used, ok := r.raw(t.name, s[n:]); ok {
+				s = s[n+used:]
+				continue
+			}
+		}
+
+		if !r.tag(t) {
+			r.text(s[:n])
+		}
+		s = s[n:]
+	}
+
+	for len(r.stack) > 0 {
+		r.pop()
+	}
+
+	return template.HTML(r.b.String())
+}
+
+type tag struct {
+	name     string // lower case
+	value    string // after "=", unescaped
+	closing  bool
+	hasValue bool
+}
+
+// parseTag reads a tag such as "[b]", "[/b]" or "[color=red]" at the start
+// of s and returns it with its length.
+func parseTag(s string) (tag, int, bool) {
+	// Searched within the bound, so that many "[" cost linear time
+	end := strings.IndexByte(s[:min(len(s), maxTagLength+1)], ']')
+	if end < 0 {
+		return tag{}, 0, false
+	}
+	body := s[1:end]
+	if strings.ContainsAny(body, "[\n\r") {
+		return tag{}, 0, false
+	}
+
+	var t tag
+	if strings.HasPrefix(body, "/") {
+		t.closing = true
+		body = body[1:]
+	}
+
+	name, value, hasValue := strings.Cut(body, "=")
+	if name == "" || (t.closing && hasValue) {
+		return tag{}, 0, false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '*') {
+			return tag{}, 0, false
+		}
+	}
+
+	t.name = strings.ToLower(name)
+	t.value = strings.Trim(strings.TrimSpace(value), `"'`)
+	t.hasValue = hasValue
+
+	return t, end + 1, true
+}
+
+// tag applies an opening or closing tag and reports whether it was
+// understood.
+func (r *renderer) tag(t tag) bool {
+	if t.closing {
+		return r.close(t.name)
+	}
+	if len(r.stack) >= maxDepth {
+		return false
+	}
+
+	switch t.name {
+	case "b", "i", "u", "s":
+		if t.hasValue {
+			return false
+		}
+		r.push(t.name, "<"+t.name+">", "</"+t.name+">", kindInline)
+
+	case "color":
+		if !reHexColor.MatchString(t.value) && !reNamedColor.MatchString(t.value) {
+			return false
+		}
+		r.push(t.name, `<font color="`+t.value+`">`, "</font>", kindInline)
+
+	case "size":
+		size, ok := fontSize(t.value)
+		if !ok {
+			return false
+		}
+		r.push(t.name, `<font size="`+strconv.Itoa(size)+`">`, "</font>", kindInline)
+
+	case "url":
+		href, ok := safeURL(t.value)
+		if !ok || r.inside(kindLink) {
+			return false
+		}
+		r.push(t.name, `<a href="`+html.EscapeString(href)+`" rel="nofollow noopener noreferrer">`, "</a>", kindLink)
+
+	case "center", "left", "right":
+		if t.hasValue {
+			return false
+		}
+		r.push(t.name, `<div class="bb-`+t.name+`">`, "</div>", kindBlock)
+
+	case "list":
+		open, close := "<ul>", "</ul>"
+		switch t.value {
+		case "":
+		case "1":
+			open, close = "<ol>", "</ol>"
+		case "a", "A", "i", "I":
+			open, close = `<ol type="`+t.value+`">`, "</ol>"
+		default:
+			return false
+		}
+		r.push(t.name, open, close, kindList)
+
+	case "*":
+		// A new item ends the previous one
+		if !r.closeTo(kindItem, kindList) {
+			return false
+		}
+		r.push(t.name, "<li>", "</li>", kindItem)
+
+	case "table":
+		r.push(t.name, `<table class="bb-table">`, "</table>", kindTable)
+
+	case "tr":
+		// A new row ends the open cell and row
+		r.closeTo(kindCell, kindRow)
+		if !r.closeTo(kindRow, kindTable) {
+			return false
+		}
+		r.push(t.name, "<tr>", "</tr>", kindRow)
+
+	case "td", "th":
+		if !r.closeTo(kindCell, kindRow) {
+			return false
+		}
+		r.push(t.name, "<"+t.name+">", "</"+t.name+">", kindCell)
+
+	case "hr":
+		r.b.WriteString("<hr>")
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// raw renders [url]address[/url] or [img]address[/img] from the text
+// after the opening tag and returns how much of it was used.
+func (r *renderer) raw(name, rest string) (int, bool) {
+	closing := "[/" + name + "]"
+	end := indexFold(rest[:min(len(rest), maxTagLength+len(closing))], closing)
+	if end < 0 {
+		return 0, false
+	}
+	addr := strings.TrimSpace(rest[:end])
+
+	u, ok := safeURL(addr)
+	if !ok {
+		return 0, false
+	}
+
+	src := ""
+	if name == "img" && r.opts.ImageURL != nil {
+		src = r.opts.ImageURL(u)
+	}
+
+	switch {
+	case src != "":
+		r.b.WriteString(`<img class="bb-img" src="` + html.EscapeString(src) + `" alt="">`)
+	case r.inside(kindLink):
+		r.text(addr)
+	default:
+		r.b.WriteString(`<a href="` + html.EscapeString(u) + `" rel="nofollow noopener noreferrer">`)
+		r.text(addr)
+		r.b.WriteString("</a>")
+	}
+
+	return end + len(closing), true
+}
+
+// text writes escaped text. Line breaks become <br>, except directly in
+// lists and tables, where they only lay out the BBCode.
+func (r *renderer) text(s string) {
+	s = strings.ReplaceAll(s, "\r", "")
+
+	structural := false
+	if n := len(r.stack); n > 0 {
+		switch r.stack[n-1].kind {
+		case kindList, kindTable, kindRow:
+			structural = true
+		}
+	}
+
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 && !structural {
+			r.b.WriteString("<br>")
+		}
+		if structural && strings.TrimSpace(line) == "" {
+			continue
+		}
+		r.b.WriteString(html.EscapeString(line))
+	}
+}
+
+func (r *renderer) push(name, open, close string, kind int) {
+	r.b.WriteString(open)
+	r.stack = append(r.stack, frame{tag: name, close: close, kind: kind})
+}
+
+func (r *renderer) pop() {
+	f := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	r.b.WriteString(f.close)
+}
+
+// close ends the innermost element opened with tag name, and the elements
+// opened inside it. A closing tag without an open element is not
+// understood.
+func (r *renderer) close(name string) bool {
+	if name == "*" {
+		return r.closeTo(kindItem, kindList)
+	}
+
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		if r.stack[i].tag == name {
+			for len(r.stack) > i {
+				r.pop()
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// closeTo prepares a list item, row or cell: it ends the open element of
+// kind sibling, if any, so that the new one follows it, and reports
+// whether the innermost container is then of kind parent. Inline elements
+// left open in between are ended too; other blocks stop the search.
+func (r *renderer) closeTo(sibling, parent int) bool {
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		switch r.stack[i].kind {
+		case kindInline, kindLink:
+			continue
+		case sibling:
+			for len(r.stack) > i {
+				r.pop()
+			}
+			return len(r.stack) > 0 && r.stack[len(r.stack)-1].kind == parent
+		case parent:
+			for len(r.stack) > i+1 {
+				r.pop()
+			}
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// inside reports whether an element of kind is open.
+func (r *renderer) inside(kind int) bool {
+	for _, f := range r.stack {
+		if f.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL returns s if it is an absolute http or https URL
+// without characters browsers would ignore or reinterpret.
+func safeURL(s string) (string, bool) {
+	if s == "" || len(s) > maxTagLength {
+		return "", false
+	}
+	for _, c := range s {
+		if c <= ' ' || c == 0x7f || c == '\\' || c == '"' || c == '<' || c == '>' || c == '`' {
+			return "", false
+		}
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || !urlSchemes[strings.ToLower(u.Scheme)] || u.Host == "" {
+		return "", false
+	}
+
+	return s, true
+}
+
+// fontSize maps a TeamSpeak size, in points or relative like "+2", to the
+// 1 to 7 of the font element.
+func fontSize(v string) (int, bool) {
+	if strings.HasPrefix(v, "+") || strings.HasPrefix(v, "-") {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, false
+		}
+		return min(max(3+n, 1), 7), true
+	}
+
+	pt, err := strconv.Atoi(v)
+	if err != nil || pt <= 0 {
+		return 0, false
+	}
+	for size, upTo := range []int{8, 10, 13, 16, 20, 28} {
+		if pt <= upTo {
+			return size + 1, true
+		}
+	}
+	return 7, true
+}
+
+// indexFold is strings.Index ignoring ASCII case.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
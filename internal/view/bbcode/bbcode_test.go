@@ -0,0 +1,136 @@
+package bbcode
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// allowedAttrs lists the elements Render may produce and their attributes.
+var allowedAttrs = map[string]map[string]bool{
+	"b":     {},
+	"i":     {},
+	"u":     {},
+	"s":     {},
+	"font":  {"color": true, "size": true},
+	"a":     {"href": true, "rel": true},
+	"div":   {"class": true},
+	"ul":    {},
+	"ol":    {"type": true},
+	"li":    {},
+	"table": {"class": true},
+	"tr":    {},
+	"td":    {},
+	"th":    {},
+	"hr":    {},
+	"br":    {},
+	"img":   {"class": true, "src": true, "alt": true},
+}
+
+// voidElements have no end tag.
+var voidElements = map[string]bool{"hr": true, "br": true, "img": true}
+
+// checkHTML reports the first way out differs from what Render may produce:
+// a tag or attribute outside the allowlist, unbalanced nesting or a link
+// or image that is not http(s).
+func checkHTML(t *testing.T, in, out string) {
+	t.Helper()
+
+	z := html.NewTokenizer(strings.NewReader(out))
+	var open []string
+
+	for {
+		tt := z.Next()
+		tok := z.Token()
+
+		switch tt {
+		case html.ErrorToken:
+			if len(open) > 0 {
+				t.Fatalf("Render(%q) = %q leaves %v open", in, out, open)
+			}
+			return
+
+		case html.TextToken:
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs, ok := allowedAttrs[tok.Data]
+			if !ok {
+				t.Fatalf("Render(%q) = %q contains <%s>", in, out, tok.Data)
+			}
+			for _, a := range tok.Attr {
+				if a.Namespace != "" || !attrs[a.Key] {
+					t.Fatalf("Render(%q) = %q: <%s> has attribute %s", in, out, tok.Data, a.Key)
+				}
+				if a.Key == "href" || a.Key == "src" {
+					u, err := url.Parse(a.Val)
+					if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+						t.Fatalf("Render(%q) = %q: %s=%q is not http(s)", in, out, a.Key, a.Val)
+					}
+				}
+			}
+			if tt == html.StartTagToken && !voidElements[tok.Data] {
+				open = append(open, tok.Data)
+			}
+
+		case html.EndTagToken:
+			if len(open) == 0 || open[len(open)-1] != tok.Data {
+				t.Fatalf("Render(%q) = %q: </%s> does not close %v", in, out, tok.Data, open)
+			}
+			open = open[:len(open)-1]
+
+		default:
+			t.Fatalf("Render(%q) = %q contains %s", in, out, tok)
+		}
+	}
+}
+
+func FuzzRender(f *testing.F) {
+	for _, s := range []string{
+		"",
+		"plain text\nwith a line break",
+		"[b]bold[/b] [i]italic[/i] [u]under[/u] [s]struck[/s]",
+		"[b][i]misnested[/b][/i]",
+		"[/b]closing only",
+		"[color=red]red[/color] [color=#ff8800]orange[/color]",
+		`[color=red" onmouseover="alert(1)]x[/color]`,
+		"[size=+2]big[/size] [size=40]huge[/size] [size=-9]tiny",
+		"[url]https://example.com/a?b=c&d=e[/url]",
+		"[url=http://example.com]link [b]bold[/b][/url]",
+		"[url=javascript:alert(1)]x[/url]",
+		"[url]javascript:alert(1)[/url]",
+		"[url=mailto:admin@example.com]mail[/url]",
+		"[url=ts3server://ts.example.com?port=9987]join[/url]",
+		`[url=http://example.com/"><script>alert(1)</script>]x[/url]`,
+		"[url=//example.com]protocol relative[/url]",
+		"[url=http://a][url=http://b]nested[/url][/url]",
+		"[img]https://example.com/a.png[/img]",
+		`[img]https://example.com/a.png" onerror="alert(1)[/img]`,
+		"[img]data:image/png;base64,AAAA[/img]",
+		"[center]centered[/center][left]l[/left][right]r[/right]",
+		"[list][*]one[*]two[/list]",
+		"[list=1][*]one[list=a][*]nested[/list][/list]",
+		"[*]item outside a list",
+		"[table][tr][td]a[td]b[tr][th]c[/table]",
+		"[td]cell outside a table[tr]row outside a table",
+		"[hr][hr=1]",
+		"<script>alert(1)</script><img src=x onerror=alert(1)>",
+		"&lt;b&gt; &amp; &#x3c;",
+		"[B]upper[/B] [URL=HTTP://EXAMPLE.COM]x[/URL]",
+		strings.Repeat("[b]", 40) + "deep" + strings.Repeat("[/b]", 40),
+		strings.Repeat("[", 1000),
+		"\x00\xff[b]\x01[/b]",
+	} {
+		f.Add(s)
+	}
+
+	proxy := &Options{ImageURL: func(src string) string {
+		return "https://viewer.example/image?url=" + url.QueryEscape(src)
+	}}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		checkHTML(t, s, string(Render(s, nil)))
+		checkHTML(t, s, string(Render(s, proxy)))
+	})
+}
@@ -0,0 +1,145 @@
+package view
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/ts6"
+)
+
+// ClientOptions control the group badges and the order of the clients in a
+// channel. The zero value shows no badges and sorts clients by nickname.
+type ClientOptions struct {
+	Badges          bool
+	SortByTalkPower bool
+
+	// IconPath is the URL prefix of icons, followed by the icon ID.
+	// Icons are left out while it is empty.
+	IconPath string
+
+	// AvatarURL returns the avatar URL of a client by unique identifier,
+	// or "" for none. Avatars are left out while it is nil.
+	AvatarURL func(uid string) string
+
+	show map[string]bool // group names or IDs; empty shows all
+	hide map[string]bool
+
+	serverGroups  map[int]ts6.ServerGroup
+	channelGroups map[int]ts6.ChannelGroup
+}
+
+// NewClientOptions reads the group and sort settings of cfg.
+func NewClientOptions(cfg *config.Config) ClientOptions {
+	return ClientOptions{
+		Badges:          cfg.GroupBadges != "false",
+		SortByTalkPower: cfg.SortClients == "talk_power",
+		show:            splitList(cfg.ShowGroups),
+		hide:            splitList(cfg.HideGroups),
+	}
+}
+
+func splitList(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// WithGroups returns a copy of o that resolves group IDs to the given
+// groups.
+func (o ClientOptions) WithGroups(serverGroups []ts6.ServerGroup, channelGroups []ts6.ChannelGroup) ClientOptions {
+	o.serverGroups = make(map[int]ts6.ServerGroup, len(serverGroups))
+	for _, g := range serverGroups {
+		o.serverGroups[g.SGID] = g
+	}
+	o.channelGroups = make(map[int]ts6.ChannelGroup, len(channelGroups))
+	for _, g := range channelGroups {
+		o.channelGroups[g.CGID] = g
+	}
+	return o
+}
+
+// iconURL returns the URL of an icon, or "" for no icon and icons built
+// into the TeamSpeak client.
+func (o *ClientOptions) iconURL(id int64) string {
+	if o.IconPath == "" || !ts6.RemoteIcon(id) {
+		return ""
+	}
+	return o.IconPath + strconv.FormatUint(uint64(uint32(id)), 10)
+}
+
+// shown applies show_groups and hide_groups to a group.
+func (o *ClientOptions) shown(id int, name string) bool {
+	key := strconv.Itoa(id)
+	if len(o.show) > 0 && !o.show[key] && !o.show[name] {
+		return false
+	}
+	return !o.hide[key] && !o.hide[name]
+}
+
+// badges resolves the groups of c, server groups by sort ID first and the
+// channel group last. Unknown and hidden groups are left out.
+func (o *ClientOptions) badges(c ts6.Client) []*VMBadge {
+	if !o.Badges {
+		return nil
+	}
+
+	var badges []*VMBadge
+	for _, sgid := range c.ServerGroups {
+		g, ok := o.serverGroups[sgid]
+		if !ok || !o.shown(sgid, g.Name) {
+			continue
+		}
+		badges = append(badges, &VMBadge{ID: g.SGID, Name: g.Name, SortID: g.SortID, IconID: g.IconID, Icon: o.iconURL(g.IconID)})
+	}
+	sort.SliceStable(badges, func(i, j int) bool {
+		if badges[i].SortID != badges[j].SortID {
+			return badges[i].SortID < badges[j].SortID
+		}
+		return badges[i].ID < badges[j].ID
+	})
+
+	if g, ok := o.channelGroups[c.ChannelGroupID]; ok && o.shown(g.CGID, g.Name) {
+		badges = append(badges, &VMBadge{ID: g.CGID, Name: g.Name, SortID: g.SortID, IconID: g.IconID, Icon: o.iconURL(g.IconID), Channel: true})
+	}
+
+	return badges
+}
+
+// groupSortID is the lowest sort ID of the server groups of c, which ranks
+// it among clients of equal talk power.
+func (o *ClientOptions) groupSortID(c ts6.Client) int {
+	lowest := -1
+	for _, sgid := range c.ServerGroups {
+		if g, ok := o.serverGroups[sgid]; ok && (lowest < 0 || g.SortID < lowest) {
+			lowest = g.SortID
+		}
+	}
+	if lowest < 0 {
+		return int(^uint(0) >> 1) // clients without known groups last
+	}
+	return lowest
+}
+
+// sortClients orders the clients of a channel like the TeamSpeak client
+// with SortByTalkPower: by talk power, highest first, then by group sort
+// ID and nickname. Otherwise they are sorted by nickname only.
+func (o *ClientOptions) sortClients(clients []ts6.Client) {
+	sort.SliceStable(clients, func(i, j int) bool {
+		a, b := clients[i], clients[j]
+		if o.SortByTalkPower {
+			if a.TalkPower != b.TalkPower {
+				return a.TalkPower > b.TalkPower
+			}
+			if sa, sb := o.groupSortID(a), o.groupSortID(b); sa != sb {
+				return sa < sb
+			}
+		}
+		return a.Nickname < b.Nickname
+	})
+}
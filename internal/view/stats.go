@@ -0,0 +1,204 @@
+package view
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"ts6-viewer/internal/stats"
+)
+
+// StatsRanges are the periods offered on the statistics page, in days.
+var StatsRanges = []int{1, 7, 30, 90, 365}
+
+type VMStats struct {
+	Theme      string
+	BasePath   string
+	ServerName string
+	Days       int
+	Ranges     []int
+
+	Average        string
+	Peak           int
+	PeakAt         string
+	Sessions       int
+	UniqueClients  int
+	AverageSession string
+
+	DailyPeaks  VMBarChart
+	WeeklyPeaks []VMPeak
+	Heatmap     VMHeatmap
+	Channels    []VMChannelStat
+}
+
+type VMPeak struct {
+	Label string
+	Max   int
+}
+
+// VMBarChart holds bars laid out in SVG user units.
+type VMBarChart struct {
+	Width  int
+	Height int
+	Bars   []VMBar
+}
+
+type VMBar struct {
+	X, Y, Width, Height int
+	Label               string
+	Value               int
+}
+
+type VMHeatmap struct {
+	Width    int
+	Height   int
+	CellSize int
+	Hours    []VMHeatLabel // column labels
+	Days     []VMHeatLabel // row labels
+	Cells    []VMHeatCell
+}
+
+type VMHeatLabel struct {
+	X, Y  int
+	Label string
+}
+
+type VMHeatCell struct {
+	X, Y    int
+	Opacity string
+	Title   string
+}
+
+type VMChannelStat struct {
+	Name    string
+	Average string
+	Percent int // of the busiest channel
+}
+
+const (
+	barChartHeight = 120
+	barWidth       = 12
+	barGap         = 4
+
+	heatCell   = 20
+	heatMargin = 40 // room for the weekday labels
+)
+
+// heatmapDays lists weekdays starting on Monday.
+var heatmapDays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+func BuildVMStats(report *stats.Report, theme, basePath, serverName string, days int) VMStats {
+	vm := VMStats{
+		Theme:         theme,
+		BasePath:      basePath,
+		ServerName:    serverName,
+		Days:          days,
+		Ranges:        StatsRanges,
+		Average:       strconv.FormatFloat(report.Average, 'f', 1, 64),
+		Peak:          report.Peak,
+		Sessions:      report.Sessions,
+		UniqueClients: report.UniqueClients,
+		DailyPeaks:    buildBarChart(report.DailyPeaks),
+		Heatmap:       buildHeatmap(report.Heatmap),
+	}
+
+	if !report.PeakAt.IsZero() {
+		vm.PeakAt = report.PeakAt.Format("Mon Jan 2 15:04")
+	}
+	if report.AverageSession > 0 {
+		vm.AverageSession = report.AverageSession.String()
+	}
+
+	for _, p := range report.WeeklyPeaks {
+		vm.WeeklyPeaks = append(vm.WeeklyPeaks, VMPeak{Label: p.Start.Format("Jan 2, 2006"), Max: p.Max})
+	}
+
+	for _, ch := range report.Channels {
+		_, _, _, name := ParseChannelName(ch.Name)
+		if name == "" {
+			name = fmt.Sprintf("Channel %d", ch.CID)
+		}
+
+		percent := 100
+		if busiest := report.Channels[0].Average; busiest > 0 {
+			percent = int(ch.Average / busiest * 100)
+		}
+
+		vm.Channels = append(vm.Channels, VMChannelStat{
+			Name:    name,
+			Average: strconv.FormatFloat(ch.Average, 'f', 2, 64),
+			Percent: percent,
+		})
+	}
+
+	return vm
+}
+
+func buildBarChart(peaks []stats.Peak) VMBarChart {
+	chart := VMBarChart{
+		Width:  len(peaks) * (barWidth + barGap),
+		Height: barChartHeight,
+	}
+
+	highest := 1
+	for _, p := range peaks {
+		highest = max(highest, p.Max)
+	}
+
+	for i, p := range peaks {
+		h := p.Max * barChartHeight / highest
+		chart.Bars = append(chart.Bars, VMBar{
+			X:      i * (barWidth + barGap),
+			Y:      barChartHeight - h,
+			Width:  barWidth,
+			Height: h,
+			Label:  p.Start.Format("Mon Jan 2"),
+			Value:  p.Max,
+		})
+	}
+
+	return chart
+}
+
+func buildHeatmap(values [7][24]float64) VMHeatmap {
+	hm := VMHeatmap{
+		Width:    heatMargin + 24*heatCell,
+		Height:   heatCell + 7*heatCell,
+		CellSize: heatCell - 1, // leaves a gap between cells
+	}
+
+	highest := 0.0
+	for _, day := range values {
+		for _, v := range day {
+			highest = max(highest, v)
+		}
+	}
+
+	for hour := 0; hour < 24; hour += 3 {
+		hm.Hours = append(hm.Hours, VMHeatLabel{
+			X:     heatMargin + hour*heatCell,
+			Y:     heatCell - 6,
+			Label: fmt.Sprintf("%02d", hour),
+		})
+	}
+
+	for row, day := range heatmapDays {
+		y := heatCell + row*heatCell
+		hm.Days = append(hm.Days, VMHeatLabel{X: 0, Y: y + heatCell - 6, Label: day.String()[:3]})
+
+		for hour, v := range values[day] {
+			opacity := 0.0
+			if highest > 0 {
+				opacity = v / highest
+			}
+			hm.Cells = append(hm.Cells, VMHeatCell{
+				X:       heatMargin + hour*heatCell,
+				Y:       y,
+				Opacity: strconv.FormatFloat(0.08+0.92*opacity, 'f', 2, 64),
+				Title:   fmt.Sprintf("%s %02d:00: %.1f clients on average", day.String()[:3], hour, v),
+			})
+		}
+	}
+
+	return hm
+}
@@ -3,8 +3,8 @@ package view
 import (
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 )
 
 var reCmd = regexp.MustCompile(`(?i)\[([clr]|\*)?spacer([^\]]*?)\]`)
@@ -57,10 +57,10 @@ func isSpacerPattern(s string) bool {
 	}
 }
 
-func MakeUptimePretty(secondsStr string) string {
-	sec, err := strconv.Atoi(secondsStr)
-	if err != nil || sec < 0 {
-		return secondsStr
+func MakeUptimePretty(uptime time.Duration) string {
+	sec := int(uptime.Seconds())
+	if sec < 0 {
+		sec = 0
 	}
 
 	days := sec / 86400
@@ -1,10 +1,10 @@
 package view
 
 import (
-	"sort"
 	"strconv"
 	"ts6-viewer/internal/config"
 	"ts6-viewer/internal/ts6"
+	"ts6-viewer/internal/view/bbcode"
 )
 
 type ChannelType int
@@ -29,32 +29,44 @@ const (
 	AlignRight
 )
 
-func BuildVMChannels(channels []ts6.Channel, clients []ts6.Client) []*VMChannel {
+func BuildVMChannels(channels []ts6.Channel, clients []ts6.Client, opts *ClientOptions) []*VMChannel {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	channels = SortChannels(channels)
+
 	// Channels
-	viewMap := make(map[string]*VMChannel)
+	viewMap := make(map[int]*VMChannel)
 	for _, ch := range channels {
-		viewMap[ch.CID] = BuildVMChannel(ch)
+		vch := BuildVMChannel(ch)
+		if vch.Type == NormalChannel {
+			vch.Icon = opts.iconURL(ch.IconID)
+		}
+		viewMap[ch.CID] = vch
 	}
 
-	// Clients
+	// Clients, sorted by nickname or like the TeamSpeak client
+	clients = append([]ts6.Client(nil), clients...)
+	opts.sortClients(clients)
+
 	for _, c := range clients {
 		if vch, ok := viewMap[c.CID]; ok {
-			vch.Clients = append(vch.Clients, BuildVMClient(c))
+			vch.Clients = append(vch.Clients, BuildVMClient(c, opts))
 		}
 	}
 
-	// Sort clients in each channel alphabetically
+	// Counted from the listed clients rather than total_clients, which
+	// includes ServerQuery clients such as the viewer's own login
 	for _, vch := range viewMap {
-		sort.Slice(vch.Clients, func(i, j int) bool {
-			return vch.Clients[i].Nickname < vch.Clients[j].Nickname
-		})
+		vch.ClientCount = len(vch.Clients)
+		vch.Full = vch.Type == NormalChannel && vch.MaxClients >= 0 && vch.ClientCount >= vch.MaxClients
 	}
 
 	// Build tree
 	var roots []*VMChannel
 	for _, ch := range channels {
 		vch := viewMap[ch.CID]
-		if ch.PID == "0" {
+		if ch.PID == 0 {
 			roots = append(roots, vch)
 		} else if parent, ok := viewMap[ch.PID]; ok {
 			parent.Children = append(parent.Children, vch)
@@ -64,35 +76,153 @@ func BuildVMChannels(channels []ts6.Channel, clients []ts6.Client) []*VMChannel
 	return roots
 }
 
-func BuildVMServer(cfg *config.Config, info *ts6.ServerInfo, clients []ts6.Client) *VMServer {
+// SortChannels orders the siblings below every parent like the TeamSpeak
+// client does. channel_order is the CID of the sibling a channel is sorted
+// after, 0 for the first one, so the siblings form a linked list. Channels
+// the list does not reach, because of duplicate, missing or cyclic
+// references, keep their relative order and start a new list where they
+// are appended. Parents keep their relative order too.
+func SortChannels(channels []ts6.Channel) []ts6.Channel {
+	siblings := make(map[int][]int) // PID → indexes into channels
+	var parents []int
+	for i, ch := range channels {
+		if _, ok := siblings[ch.PID]; !ok {
+			parents = append(parents, ch.PID)
+		}
+		siblings[ch.PID] = append(siblings[ch.PID], i)
+	}
+
+	sorted := make([]ts6.Channel, 0, len(channels))
+	for _, pid := range parents {
+		group := siblings[pid]
+
+		// First sibling claiming each predecessor
+		after := make(map[int]int, len(group))
+		for _, i := range group {
+			if _, ok := after[channels[i].ChannelOrder]; !ok {
+				after[channels[i].ChannelOrder] = i
+			}
+		}
+
+		visited := make(map[int]bool, len(group))
+		prev := 0
+		for _, start := range group {
+			for !visited[start] {
+				// Follow the list from prev; once it ends, restart at
+				// the first sibling not placed yet
+				i, ok := after[prev]
+				if !ok || visited[i] {
+					i = start
+				}
+				for !visited[i] {
+					visited[i] = true
+					sorted = append(sorted, channels[i])
+					prev = channels[i].CID
+
+					next, ok := after[prev]
+					if !ok {
+						break
+					}
+					i = next
+				}
+			}
+		}
+	}
+
+	return sorted
+}
+
+func BuildVMServer(cfg *config.Config, info *ts6.ServerInfo, clients []ts6.Client, markup *bbcode.Options) *VMServer {
 	return &VMServer{
 		Name:               info.Name,
 		ClientsOnline:      strconv.Itoa(len(clients)),
-		MaxClients:         info.MaxClients,
+		MaxClients:         strconv.Itoa(info.MaxClients),
 		UptimePretty:       MakeUptimePretty(info.Uptime),
-		ChannelsOnline:     info.ChannelsOnline,
+		ChannelsOnline:     strconv.Itoa(info.ChannelsOnline),
 		HostBannerURL:      info.HostBannerURL,
 		HostConnectionLink: cfg.HostConnectionLink,
-		ClientConnections:  info.ClientConnections,
+		ClientConnections:  strconv.FormatInt(info.ClientConnections, 10),
+		WelcomeMessage:     bbcode.Render(info.WelcomeMessage, markup),
 	}
 }
 
+// BuildVMIndexServer summarizes a server for the index page. A nil info
+// marks the server as unavailable.
+func BuildVMIndexServer(name, path string, info *ts6.ServerInfo, clients []ts6.Client) *VMIndexServer {
+	if info == nil {
+		return &VMIndexServer{Name: name, Path: path, ServerName: name}
+	}
+
+	return &VMIndexServer{
+		Name:          name,
+		Path:          path,
+		ServerName:    info.Name,
+		ClientsOnline: strconv.Itoa(len(clients)),
+		MaxClients:    strconv.Itoa(info.MaxClients),
+		Available:     true,
+	}
+}
+
+// codecNames are the names the TeamSpeak client shows for channel_codec.
+var codecNames = map[int]string{
+	0: "Speex Narrowband",
+	1: "Speex Wideband",
+	2: "Speex Ultra-Wideband",
+	3: "CELT Mono",
+	4: "Opus Voice",
+	5: "Opus Music",
+}
+
 func BuildVMChannel(ch ts6.Channel) *VMChannel {
 	chType, align, repeat, cleanName := ParseChannelName(ch.Name)
 
+	maxClients := ch.MaxClients
+	if ch.FlagMaxClientsUnlimited || maxClients < 0 {
+		maxClients = -1
+	}
+
+	codec, ok := codecNames[ch.Codec]
+	if !ok {
+		codec = "Codec " + strconv.Itoa(ch.Codec)
+	}
+	codec += " (quality " + strconv.Itoa(ch.CodecQuality) + ")"
+
 	return &VMChannel{
+		CID:    ch.CID,
 		Name:   cleanName,
 		Type:   chType,
 		Align:  align,
 		Repeat: repeat,
+
+		Topic:         ch.Topic,
+		Password:      ch.FlagPassword,
+		Default:       ch.FlagDefault,
+		Permanent:     ch.FlagPermanent,
+		SemiPermanent: ch.FlagSemiPermanent,
+		MaxClients:    maxClients,
+		Codec:         codec,
 	}
 }
 
-func BuildVMClient(c ts6.Client) *VMClient {
+func BuildVMClient(c ts6.Client, opts *ClientOptions) *VMClient {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+
+	var avatar string
+	if opts.AvatarURL != nil {
+		avatar = opts.AvatarURL(c.UniqueIdentifier)
+	}
+
 	return &VMClient{
+		CLID:        c.CLID,
 		Nickname:    c.Nickname,
-		MicMuted:    c.InputMuted == "1" || c.InputHardware == "0",
-		OutputMuted: c.OutputMuted == "1",
-		IsTalking:   c.IsTalking == "1",
+		MicMuted:    c.InputMuted || !c.InputHardware,
+		OutputMuted: c.OutputMuted,
+		IsTalking:   c.IsTalking,
+		TalkPower:   c.TalkPower,
+		Icon:        opts.iconURL(c.IconID),
+		Avatar:      avatar,
+		Badges:      opts.badges(c),
 	}
 }
@@ -0,0 +1,125 @@
+package view
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"ts6-viewer/internal/ts6"
+)
+
+// loadChannelList reads a channellist response from testdata. The fixtures
+// are hand-written in the response format of the server rather than
+// captured, and cover the broken and cyclic chains the sort must tolerate.
+// They hold one channel per line for readability; the server separates
+// them with "|".
+func loadChannelList(t *testing.T, name string) []ts6.Channel {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := strings.ReplaceAll(strings.TrimSpace(string(data)), "\n", "|")
+
+	var channels []ts6.Channel
+	if err := ts6.Unmarshal(raw, &channels); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return channels
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// formatTree renders sorted channels as the tree the page shows, one
+// channel per line and children indented below their parent in sorted
+// order. Channels whose parent is missing, which the page leaves out,
+// follow at the end, marked.
+func formatTree(channels []ts6.Channel) string {
+	known := make(map[int]bool, len(channels))
+	children := make(map[int][]ts6.Channel)
+	for _, ch := range channels {
+		known[ch.CID] = true
+		children[ch.PID] = append(children[ch.PID], ch)
+	}
+
+	var b strings.Builder
+	var write func(ch ts6.Channel, depth int)
+	write = func(ch ts6.Channel, depth int) {
+		fmt.Fprintf(&b, "%s%d %s", strings.Repeat("  ", depth), ch.CID, ch.Name)
+		if ch.PID != 0 && !known[ch.PID] {
+			fmt.Fprintf(&b, " (parent %d missing)", ch.PID)
+		}
+		b.WriteByte('\n')
+
+		for _, child := range children[ch.CID] {
+			write(child, depth+1)
+		}
+	}
+
+	for _, ch := range children[0] {
+		write(ch, 0)
+	}
+	for _, ch := range channels {
+		if ch.PID != 0 && !known[ch.PID] {
+			write(ch, 0)
+		}
+	}
+	return b.String()
+}
+
+// TestSortChannels sorts the channellist responses in testdata and
+// compares the resulting trees with the .golden files next to them. Run
+// with -update to rewrite the golden files after an intended change.
+func TestSortChannels(t *testing.T) {
+	fixtures := []string{
+		// Listed out of order; every sibling list is intact
+		"ordered.txt",
+		// CID 3 was deleted, and CS2 is sorted after a missing channel
+		"broken_chain.txt",
+		// Gaming and Music point at each other, rooms 1 and 2 as well and
+		// room 3 at itself
+		"cyclic_chain.txt",
+		// The parent of the orphans is missing from the list
+		"orphaned_parent.txt",
+		// Gaming and Music both claim to follow the lobby
+		"duplicate_order.txt",
+	}
+
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(fixture, ".txt")
+		t.Run(name, func(t *testing.T) {
+			channels := loadChannelList(t, fixture)
+			got := formatTree(SortChannels(channels))
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("tree differs from %s:\n%s\nwant\n%s", golden, got, want)
+			}
+
+			// Every channel is listed exactly once
+			var cids []int
+			for _, ch := range SortChannels(channels) {
+				cids = append(cids, ch.CID)
+			}
+			slices.Sort(cids)
+			if len(slices.Compact(cids)) != len(channels) {
+				t.Errorf("sorted %d distinct channels, want %d", len(cids), len(channels))
+			}
+		})
+	}
+}
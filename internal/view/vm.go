@@ -1,11 +1,30 @@
 package view
 
+import "html/template"
+
 type VMTS6Viewer struct {
 	VMServer        *VMServer
 	VMChannels      []*VMChannel
 	Theme           string
 	RefreshInterval string
-	MaxWidth        string
+	BasePath        string
+	StatsPath       string // empty while statistics are disabled
+	Activity        []*VMActivity
+	Status          string // notice shown while the TS6 server is unreachable
+}
+
+type VMIndex struct {
+	Servers []*VMIndexServer
+	Theme   string
+}
+
+type VMIndexServer struct {
+	Name          string
+	Path          string
+	ServerName    string
+	ClientsOnline string
+	MaxClients    string
+	Available     bool
 }
 
 type VMServer struct {
@@ -17,22 +36,51 @@ type VMServer struct {
 	HostBannerURL      string
 	HostConnectionLink string
 	ClientConnections  string
+	WelcomeMessage     template.HTML // rendered BBCode
 }
 
 type VMClient struct {
+	CLID        int
 	Nickname    string
 	Platform    string
 	Version     string
 	MicMuted    bool
 	OutputMuted bool
 	IsTalking   bool
+	TalkPower   int
+	Icon        string // URL, empty without an icon
+	Avatar      string // URL, empty without an avatar
+	Badges      []*VMBadge
+}
+
+// VMBadge is a server or channel group of a client.
+type VMBadge struct {
+	ID      int
+	Name    string
+	SortID  int
+	IconID  int64
+	Icon    string // URL, empty without an icon
+	Channel bool   // channel group rather than server group
 }
 
 type VMChannel struct {
-	Name     string
-	Type     ChannelType
-	Align    Aligned
-	Repeat   bool
+	CID    int
+	Name   string
+	Type   ChannelType
+	Align  Aligned
+	Repeat bool
+	Icon   string // URL, empty without an icon
+
+	Topic         string
+	Password      bool
+	Default       bool
+	Permanent     bool
+	SemiPermanent bool
+	MaxClients    int // -1 for unlimited
+	ClientCount   int
+	Full          bool
+	Codec         string
+
 	Clients  []*VMClient
 	Children []*VMChannel
 }
@@ -1,5 +1,10 @@
+// Page settings rendered into <body data-*> by the server
+const refreshTime = Number(document.body.dataset.refreshInterval) || 60;
+const basePath = document.body.dataset.basePath || "/ts6viewer";
+
 // ==========================================
 // Refresh countdown with SESSIONSTORAGE persistence
+// (polling fallback when live updates are unavailable)
 // ==========================================
 
 // Load counter from sessionStorage
@@ -12,34 +17,83 @@ if (!counter || counter > refreshTime || counter < 0) {
 
 const refreshText = document.getElementById("refreshButtonText");
 
-// Immediately update UI with stored value
-refreshText.textContent = counter;
+let pollTimer = null;
 
-// Save initial value (in case it was missing)
-sessionStorage.setItem("refreshCounter", counter);
+function startPolling() {
+    if (pollTimer) return;
 
-// Countdown loop
-setInterval(() => {
-    counter--;
+    // Immediately update UI with stored value
     refreshText.textContent = counter;
 
-    // Save updated counter
+    // Save initial value (in case it was missing)
     sessionStorage.setItem("refreshCounter", counter);
 
-    // When countdown reaches zero → refresh data
-    if (counter <= 0) {
-        counter = refreshTime;
+    // Countdown loop
+    pollTimer = setInterval(() => {
+        counter--;
         refreshText.textContent = counter;
+
+        // Save updated counter
         sessionStorage.setItem("refreshCounter", counter);
-        fetchViewerData(true);
-    }
-}, 1000);
+
+        // When countdown reaches zero → refresh data from the live model
+        if (counter <= 0) {
+            counter = refreshTime;
+            refreshText.textContent = counter;
+            sessionStorage.setItem("refreshCounter", counter);
+            fetchViewerData();
+        }
+    }, 1000);
+
+    fetchViewerData();
+}
 
 // Save counter when leaving the page
 window.addEventListener("beforeunload", () => {
     sessionStorage.setItem("refreshCounter", counter);
 });
 
+// ==========================================
+// Live updates via Server-Sent Events
+// ==========================================
+
+function connectEvents() {
+    if (!window.EventSource) {
+        startPolling();
+        return;
+    }
+
+    const source = new EventSource(basePath + "/events");
+
+    // Some proxies buffer event streams forever → give up and poll
+    const openTimeout = setTimeout(() => {
+        source.close();
+        startPolling();
+    }, 10000);
+
+    source.addEventListener("open", () => {
+        clearTimeout(openTimeout);
+        refreshText.textContent = "live";
+    });
+
+    // Each event carries the fragment rendered by the server, also when
+    // only the notice about the age of the data changed
+    source.addEventListener("fragment", (event) => {
+        swapFragment(event.data);
+    });
+
+    source.addEventListener("error", () => {
+        if (source.readyState === EventSource.CLOSED) {
+            // Stream refused → fall back to polling
+            clearTimeout(openTimeout);
+            startPolling();
+        } else {
+            // Browser reconnects on its own
+            refreshText.textContent = "…";
+        }
+    });
+}
+
 // ==========================================
 // Spacer rendering helpers
 // ==========================================
@@ -108,148 +162,127 @@ function debug(msg) {
         box.style.fontFamily = "monospace";
         document.body.appendChild(box);
     }
-    box.innerHTML += msg + "<br>";
+    const line = document.createElement("div");
+    line.textContent = msg;
+    box.appendChild(line);
 }
 
 // ==========================================
-// Fetch viewer data from backend
+// Fetch server-rendered fragment from backend
 // ==========================================
 async function fetchViewerData(force = false) {
-    const url = force ? "/ts6viewer/data?force=1" : "/ts6viewer/data";
+    const url = basePath + (force ? "/fragment?force=1" : "/fragment");
 
     try {
         const response = await fetch(url);
-        const data = await response.json();
-
-        updateServerInfo(data.VMServer);
-        updateChannelTree(data.VMChannels);
-        updateAllSpacers();
+        if (response.status === 429) {
+            // Keep showing the current data until the next refresh
+            return;
+        }
+        if (!response.ok) {
+            showStatus(await response.text());
+            return;
+        }
+        swapFragment(await response.text());
     } catch (err) {
         console.error("Polling error:", err);
     }
 }
 
+// Shows a notice such as "TeamSpeak server unreachable since 14:02";
+// an empty message hides it. Successful responses carry their own notice
+// in the fragment.
+function showStatus(msg) {
+    const box = document.getElementById("server-status");
+    box.textContent = msg.trim();
+    box.hidden = box.textContent === "";
+}
+
 // ==========================================
-// Update server info box
+// Swap in server info, channel tree and activity
 // ==========================================
-function updateServerInfo(vmServer) {
-    let bannerHtml = "";
-    if (vmServer.HostBannerURL && vmServer.HostBannerURL.trim() !== "") {
-        bannerHtml = `
-        <div>
-            <div class="banner-url">
-                <a href="${vmServer.HostBannerURL}">
-                    ${vmServer.HostBannerURL}
-                </a>
-            </div>
-        </div>`;
-    }
-
-    let serverNameHtml = "";
-    if (vmServer.HostConnectionLink && vmServer.HostConnectionLink.trim() !== "") {
-        serverNameHtml = `
-            <h1 id="server-name">
-                <a href="ts3server://${vmServer.HostConnectionLink}">
-                    ${vmServer.Name}
-                </a>
-            </h1>`;
-    } else {
-        serverNameHtml = `<h1 id="server-name">${vmServer.Name}</h1>`;
+// The fragment is escaped by the server's html/template; it is parsed
+// into a separate document, so nothing in it runs, and only the known
+// nodes are moved into the page.
+function swapFragment(html) {
+    const doc = new DOMParser().parseFromString(html, "text/html");
+    const expanded = expandedChannels();
+
+    for (const selector of ["#server-status", ".server-info", "#channels", "#activity-list"]) {
+        const fresh = doc.querySelector(selector);
+        const current = document.querySelector(selector);
+        if (fresh && current) {
+            current.replaceWith(document.adoptNode(fresh));
+        }
     }
 
-    document.querySelector(".server-info").innerHTML = `
-        ${serverNameHtml}
-
-        <div><span>User: </span> ${vmServer.ClientsOnline} / ${vmServer.MaxClients}</div>
-        <div><span>Client Connections:</span> ${vmServer.ClientConnections}</div>
-        <div><span>Uptime:</span> ${vmServer.UptimePretty}</div>
-        <div><span>ChannelsOnline:</span> ${vmServer.ChannelsOnline}</div>
-
-        ${bannerHtml}
-    `;
+    restoreChannels(expanded);
+    requestAnimationFrame(updateAllSpacers);
 }
 
 // ==========================================
-// Render channel tree
+// Channel detail panels
 // ==========================================
-function updateChannelTree(tree) {
-    const container = document.getElementById("channels");
-    container.innerHTML = renderChannels(tree);
-    requestAnimationFrame(updateAllSpacers);
-}
+// Expanding a channel loads its description from the server, rendered by
+// the page template like the fragment.
+async function loadDescription(details) {
+    const box = details.querySelector(".channel-description");
+    if (!box || box.dataset.loaded) return;
+    box.dataset.loaded = "1";
 
-function renderChannels(nodes) {
-    let html = "";
-    for (const node of nodes) {
-        html += renderChannel(node);
+    try {
+        const response = await fetch(basePath + "/channel/" + encodeURIComponent(details.dataset.cid));
+        if (!response.ok) throw new Error("HTTP " + response.status);
+
+        const doc = new DOMParser().parseFromString(await response.text(), "text/html");
+        const fresh = doc.querySelector(".channel-description");
+        if (fresh) {
+            fresh.dataset.loaded = "1";
+            box.replaceWith(document.adoptNode(fresh));
+        }
+    } catch (err) {
+        console.error("Description error:", err);
+        box.textContent = "Description unavailable";
+        delete box.dataset.loaded;
     }
-    return html;
 }
 
-function renderChannel(ch) {
-    if (ch.Type === 8) {
-        return '<div class="row spacer blank-spacer"></div>';
-    }
-
-    const typeClass = ch.Type === 0 ? "channel" : "spacer";
-
-    const alignClassName =
-        ch.Align === 0 ? "spacer-left" :
-        ch.Align === 1 ? "spacer-center" :
-                         "spacer-right";
-
-    let html = '<div class="row ' + typeClass;
-
-    if (ch.Repeat) {
-        html += ' repeat spacer-mono';
+// "toggle" does not bubble, so it is caught on the way down
+document.addEventListener("toggle", (event) => {
+    const details = event.target;
+    if (details.classList && details.classList.contains("channel-details") && details.open) {
+        loadDescription(details);
     }
+}, true);
 
-    html += ' ' + alignClassName + '"';
-
-    if (ch.Repeat) {
-        html += ' data-pattern="' + ch.Name + '"';
+// Open panels and their loaded descriptions, by channel ID
+function expandedChannels() {
+    const expanded = new Map();
+    for (const details of document.querySelectorAll(".channel-details[open]")) {
+        expanded.set(details.dataset.cid, details.querySelector(".channel-description[data-loaded]"));
     }
+    return expanded;
+}
 
-    html += '>' + ch.Name + '</div>';
-    
-    if (ch.Clients && ch.Clients.length > 0) {
-        html += '<div class="children">';
-        for (const c of ch.Clients) {
-            let icon = '<i class="fa-solid fa-circle status-online"></i>';
-
-            if (c.OutputMuted) {
-                icon = '<i class="fa-solid fa-volume-xmark status-audio"></i>';
-            } else if (c.MicMuted) {
-                icon = '<i class="fa-solid fa-microphone-slash status-mic"></i>';
-            } else {
-                icon = '<i class="fa-solid fa-circle status-online"></i>';
-            }
-
-            html += '<div class="row client">' +
-                    icon +
-                    '<span class="client-name">' + c.Nickname + '</span>' +
-                    '</div>';
-
-        }
-        html += '</div>';
-    }
+// Keeps panels open across fragment swaps without reloading descriptions
+function restoreChannels(expanded) {
+    for (const [cid, description] of expanded) {
+        const details = document.querySelector(`.channel-details[data-cid="${CSS.escape(cid)}"]`);
+        if (!details) continue;
 
-    if (ch.Children && ch.Children.length > 0) {
-        html += '<div class="children">';
-        for (const child of ch.Children) {
-            html += renderChannel(child);
+        const box = details.querySelector(".channel-description");
+        if (description && box) {
+            box.replaceWith(description);
         }
-        html += '</div>';
+        details.open = true;
     }
-
-    return html;
 }
 
 // ==========================================
 // Initial load
 // ==========================================
 window.addEventListener("load", () => {
-    fetchViewerData();
+    connectEvents();
     requestAnimationFrame(updateAllSpacers);
 });
 
@@ -0,0 +1,41 @@
+// Package web holds the page templates and static assets. They are
+// embedded into the binary; an override directory with the same layout
+// (templates/, static/) can replace individual files without rebuilding.
+package web
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+//go:embed templates static
+var embedded embed.FS
+
+// FS returns the web assets. Files in overrideDir take precedence over the
+// embedded ones; with an empty overrideDir only the embedded files are used.
+func FS(overrideDir string) fs.FS {
+	if overrideDir == "" {
+		return embedded
+	}
+	return overlayFS{override: os.DirFS(overrideDir), base: embedded}
+}
+
+// overlayFS serves files from override and falls back to base for files
+// override does not have.
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.override.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.base.Open(name)
+}
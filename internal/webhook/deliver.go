@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// deliverLoop sends the events queued for t one at a time until ctx is
+// done.
+func (d *Dispatcher) deliverLoop(ctx context.Context, t *target) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-t.queue:
+			if err := d.deliver(ctx, t, &e); err != nil {
+				log.Printf("[WEBHOOK] Delivering %s event to %q failed: %v\n", e.Type, t.cfg.Name, err)
+				deliveries.Inc(t.cfg.Name, "failed")
+				continue
+			}
+			deliveries.Inc(t.cfg.Name, "sent")
+		}
+	}
+}
+
+// deliver sends e to t, retrying with exponential backoff on network
+// errors, 429 and 5xx responses. A Retry-After header overrides the
+// backoff.
+func (d *Dispatcher) deliver(ctx context.Context, t *target, e *Event) error {
+	body, contentType, err := t.format(e)
+	if err != nil {
+		return fmt.Errorf("render payload: %w", err)
+	}
+
+	delay := minRetryDelay
+	for attempt := 1; ; attempt++ {
+		wait, err := d.send(ctx, t, body, contentType)
+		if err == nil {
+			return nil
+		}
+		if wait < 0 || attempt == maxAttempts {
+			return err
+		}
+
+		if wait == 0 {
+			wait = delay
+			delay = min(2*delay, maxRetryDelay)
+		}
+		log.Printf("[WEBHOOK] %q: %v, retrying in %s\n", t.cfg.Name, err, wait)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// send makes a single request. On failure, wait is negative if retrying is
+// pointless, positive if the endpoint asked to wait that long, and zero
+// otherwise.
+func (d *Dispatcher) send(ctx context.Context, t *target, body []byte, contentType string) (wait time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "ts6-viewer")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			wait = min(time.Duration(secs)*time.Second, maxRetryDelay)
+		}
+		return wait, fmt.Errorf("endpoint answered %s", resp.Status)
+	default:
+		return -1, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+}
@@ -0,0 +1,262 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"ts6-viewer/internal/activity"
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/webhook/webhooktest"
+)
+
+// newTestTarget returns a dispatcher with the single target wh. Its
+// delivery loop is not started; tests call deliver or read the queue.
+func newTestTarget(t *testing.T, wh config.Webhook) (*Dispatcher, *target) {
+	t.Helper()
+
+	if wh.Name == "" {
+		wh.Name = "test"
+	}
+	d, err := New([]config.Webhook{wh}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, d.targets[0]
+}
+
+// queued drains the events queued for t.
+func queued(t *target) []Event {
+	var events []Event
+	for {
+		select {
+		case e := <-t.queue:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func statuses(requests []webhooktest.Request) []int {
+	var codes []int
+	for _, r := range requests {
+		codes = append(codes, r.Status)
+	}
+	return codes
+}
+
+func TestDeliverRetries(t *testing.T) {
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusTooManyRequests} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			t.Parallel()
+
+			srv := webhooktest.NewServer()
+			defer srv.Close()
+			srv.Fail(1, status)
+
+			d, tg := newTestTarget(t, config.Webhook{URL: srv.URL(), Format: "json"})
+
+			e := Event{Type: ServerDown, Server: "default", Message: "TeamSpeak server unreachable"}
+			if err := d.deliver(context.Background(), tg, &e); err != nil {
+				t.Fatalf("deliver: %v", err)
+			}
+
+			received := srv.Received()
+			if got, want := statuses(received), []int{status, http.StatusNoContent}; !slices.Equal(got, want) {
+				t.Fatalf("statuses = %v, want %v", got, want)
+			}
+
+			var sent Event
+			if err := json.Unmarshal(received[1].Body, &sent); err != nil {
+				t.Fatal(err)
+			}
+			if sent.Type != ServerDown || received[1].ContentType != contentTypeJSON {
+				t.Errorf("sent %s as %q", received[1].Body, received[1].ContentType)
+			}
+		})
+	}
+}
+
+func TestDeliverRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	srv := webhooktest.NewServer()
+	defer srv.Close()
+	srv.Fail(1, http.StatusTooManyRequests)
+	srv.RetryAfter(2 * time.Second)
+
+	d, tg := newTestTarget(t, config.Webhook{URL: srv.URL()})
+
+	start := time.Now()
+	if err := d.deliver(context.Background(), tg, &Event{Type: ServerUp}); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	// Without the header the first retry waits minRetryDelay
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("retried after %v, want at least the 2s of Retry-After", elapsed)
+	}
+	if n := len(srv.Received()); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{http.StatusServiceUnavailable, 0, 0},
+		{http.StatusServiceUnavailable, 3 * time.Second, 3 * time.Second},
+		{http.StatusTooManyRequests, 5 * time.Second, 5 * time.Second},
+		{http.StatusTooManyRequests, time.Hour, maxRetryDelay},
+		{http.StatusBadRequest, 3 * time.Second, -1},
+	}
+
+	for _, tt := range tests {
+		srv := webhooktest.NewServer()
+		srv.Fail(1, tt.status)
+		srv.RetryAfter(tt.retryAfter)
+
+		d, tg := newTestTarget(t, config.Webhook{URL: srv.URL()})
+
+		wait, err := d.send(context.Background(), tg, []byte("{}"), contentTypeJSON)
+		if err == nil {
+			t.Errorf("%d: no error", tt.status)
+		}
+		if wait != tt.want {
+			t.Errorf("%d with Retry-After %v: wait = %v, want %v", tt.status, tt.retryAfter, wait, tt.want)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestDeliverNoRetryOnClientError(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		srv := webhooktest.NewServer()
+		srv.Fail(1, status)
+
+		d, tg := newTestTarget(t, config.Webhook{URL: srv.URL()})
+
+		if err := d.deliver(context.Background(), tg, &Event{Type: ServerUp}); err == nil {
+			t.Errorf("%d: delivered", status)
+		}
+		if n := len(srv.Received()); n != 1 {
+			t.Errorf("%d: requests = %d, want 1", status, n)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestDeliverRespectsContext(t *testing.T) {
+	srv := webhooktest.NewServer()
+	defer srv.Close()
+	srv.Fail(maxAttempts, http.StatusServiceUnavailable)
+
+	d, tg := newTestTarget(t, config.Webhook{URL: srv.URL()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := d.deliver(ctx, tg, &Event{Type: ServerUp}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if n := len(srv.Received()); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	joined := Event{Type: ClientJoined, Server: "default", CLID: 5, UID: "alice", CID: 1}
+
+	other := joined
+	other.UID = "bob"
+
+	moved := joined
+	moved.Type = ClientMoved
+	moved.FromCID, moved.CID = 1, 2
+
+	later := joined
+	later.Time = time.Now().Add(time.Minute)
+
+	_, tg := newTestTarget(t, config.Webhook{URL: "http://127.0.0.1/"})
+	for _, e := range []Event{joined, other, joined, moved, later} {
+		tg.offer(e)
+	}
+
+	got := queued(tg)
+	if len(got) != 3 {
+		t.Fatalf("queued %d events, want 3: %+v", len(got), got)
+	}
+	if got[0].UID != "alice" || got[1].UID != "bob" || got[2].Type != ClientMoved {
+		t.Errorf("queued %+v", got)
+	}
+
+	// A dedup window of 0 sends every event
+	_, tg = newTestTarget(t, config.Webhook{URL: "http://127.0.0.1/", DedupWindow: "0"})
+	for range 3 {
+		tg.offer(joined)
+	}
+	if n := len(queued(tg)); n != 3 {
+		t.Errorf("queued %d events without dedup, want 3", n)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	at := time.Now()
+	alice := activity.Event{Time: at, Kind: activity.Joined, CLID: 5, UID: "alice", Nickname: "Alice", CID: 1, Channel: "Lobby"}
+
+	tests := []struct {
+		name          string
+		events        []activity.Event
+		before, after int
+		want          []string
+	}{
+		{"reaches threshold", nil, 2, 3, []string{OnlineAbove}},
+		{"jumps over threshold", nil, 0, 5, []string{OnlineAbove}},
+		{"falls below threshold", nil, 3, 2, []string{OnlineBelow}},
+		{"stays above", nil, 3, 4, nil},
+		{"stays below", nil, 1, 2, nil},
+		{"unchanged", nil, 5, 5, nil},
+		{"joins empty server", []activity.Event{alice}, 0, 1, []string{ClientJoined, FirstJoined}},
+		{"joins busy server", []activity.Event{alice}, 1, 2, []string{ClientJoined}},
+		{"joins and reaches threshold", []activity.Event{alice}, 2, 3, []string{ClientJoined, OnlineAbove}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, tg := newTestTarget(t, config.Webhook{URL: "http://127.0.0.1/", Threshold: "3"})
+
+			d.Observe("default", "Test Server", tt.events, tt.before, tt.after, at)
+
+			var got []string
+			for _, e := range queued(tg) {
+				got = append(got, e.Type)
+				if e.Online != tt.after {
+					t.Errorf("%s: online = %d, want %d", e.Type, e.Online, tt.after)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveWithoutThreshold(t *testing.T) {
+	d, tg := newTestTarget(t, config.Webhook{URL: "http://127.0.0.1/"})
+
+	d.Observe("default", "Test Server", nil, 0, 100, time.Now())
+
+	if got := queued(tg); len(got) != 0 {
+		t.Errorf("queued %+v without a threshold", got)
+	}
+}
@@ -0,0 +1,84 @@
+// Package webhook notifies HTTP endpoints such as Discord or Slack of
+// server events: clients joining and leaving, the server going down or
+// coming back and the online count crossing a threshold.
+package webhook
+
+import (
+	"fmt"
+	"time"
+
+	"ts6-viewer/internal/activity"
+)
+
+// Event types. Client events are named after the activity kinds.
+const (
+	ClientJoined  = "joined"
+	ClientLeft    = "left"
+	ClientMoved   = "moved"
+	ClientAway    = "away"
+	ClientBack    = "back"
+	ClientMuted   = "muted"
+	ClientUnmuted = "unmuted"
+
+	FirstJoined = "first_joined" // a client joined an empty server
+	ServerDown  = "server_down"
+	ServerUp    = "server_up"
+	OnlineAbove = "online_above" // online count reached the threshold
+	OnlineBelow = "online_below" // online count fell below the threshold
+)
+
+// Event is the payload of the "json" format and the data of templates.
+type Event struct {
+	Type       string    `json:"type"`
+	Server     string    `json:"server"`      // configured server name
+	ServerName string    `json:"server_name"` // virtual server name
+	Time       time.Time `json:"time"`
+	Message    string    `json:"message"` // human-readable summary
+
+	CLID         int    `json:"clid,omitempty"`
+	UID          string `json:"uid,omitempty"`
+	Nickname     string `json:"nickname,omitempty"`
+	ServerGroups []int  `json:"server_groups,omitempty"`
+	CID          int    `json:"cid,omitempty"`
+	Channel      string `json:"channel,omitempty"`
+	FromCID      int    `json:"from_cid,omitempty"`
+	FromChannel  string `json:"from_channel,omitempty"`
+
+	Online    int `json:"online"`
+	Threshold int `json:"threshold,omitempty"`
+}
+
+// isClientEvent reports whether e is about a single client, which is what
+// the channel and server group filters apply to.
+func (e *Event) isClientEvent() bool {
+	return e.CLID != 0
+}
+
+// dedupKey identifies events that are identical apart from their time.
+func (e *Event) dedupKey() string {
+	return fmt.Sprintf("%s/%s/%s/%d/%d", e.Server, e.Type, e.UID, e.FromCID, e.CID)
+}
+
+// clientEvent converts an activity event.
+func clientEvent(server, serverName string, a activity.Event, online int) Event {
+	return Event{
+		Type:         string(a.Kind),
+		Server:       server,
+		ServerName:   serverName,
+		Time:         a.Time,
+		Message:      clientMessage(a),
+		CLID:         a.CLID,
+		UID:          a.UID,
+		Nickname:     a.Nickname,
+		ServerGroups: a.ServerGroups,
+		CID:          a.CID,
+		Channel:      a.Channel,
+		FromCID:      a.FromCID,
+		FromChannel:  a.FromChannel,
+		Online:       online,
+	}
+}
+
+func clientMessage(a activity.Event) string {
+	return a.Nickname + " " + a.Detail()
+}
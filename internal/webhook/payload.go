@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// formatter renders an event as a request body and its content type.
+type formatter func(e *Event) ([]byte, string, error)
+
+const contentTypeJSON = "application/json"
+
+// Embed colours of the Discord format by event type.
+var discordColors = map[string]int{
+	ClientJoined: 0x4CAF50,
+	FirstJoined:  0x4CAF50,
+	ServerUp:     0x4CAF50,
+	OnlineAbove:  0x2196F3,
+	ClientLeft:   0x9E9E9E,
+	OnlineBelow:  0x9E9E9E,
+	ServerDown:   0xD9534F,
+}
+
+// discordEscaper escapes the characters Discord treats as markdown.
+var discordEscaper = strings.NewReplacer(
+	`\`, `\\`, `*`, `\*`, `_`, `\_`, `~`, `\~`, "`", "\\`", `|`, `\|`, `>`, `\>`, `#`, `\#`, `[`, `\[`, `]`, `\]`,
+)
+
+func formatDiscord(e *Event) ([]byte, string, error) {
+	payload := map[string]any{
+		"username": "TS6 Viewer",
+		// Names chosen by clients must never ping anyone
+		"allowed_mentions": map[string]any{"parse": []string{}},
+		"embeds": []map[string]any{{
+			"title":       e.ServerName,
+			"description": discordEscaper.Replace(e.Message),
+			"color":       discordColors[e.Type],
+			"timestamp":   e.Time.Format(time.RFC3339),
+		}},
+	}
+
+	body, err := json.Marshal(payload)
+	return body, contentTypeJSON, err
+}
+
+// slackEscaper escapes the characters Slack's mrkdwn treats as control
+// sequences.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func formatSlack(e *Event) ([]byte, string, error) {
+	text := slackEscaper.Replace(e.Message)
+
+	payload := map[string]any{
+		"text": text, // notification fallback
+		"blocks": []map[string]any{
+			{
+				"type": "section",
+				"text": map[string]string{
+					"type": "mrkdwn",
+					"text": "*" + slackEscaper.Replace(e.ServerName) + "*\n" + text,
+				},
+			},
+			{
+				"type": "context",
+				"elements": []map[string]string{{
+					"type": "mrkdwn",
+					"text": fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", e.Time.Unix(), e.Time.Format(time.RFC1123)),
+				}},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	return body, contentTypeJSON, err
+}
+
+func formatJSON(e *Event) ([]byte, string, error) {
+	body, err := json.Marshal(e)
+	return body, contentTypeJSON, err
+}
+
+// templateFuncs are available in user-supplied templates. {{json .Nickname}}
+// quotes and escapes a value for use in a JSON body.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// templateFormatter executes a user-supplied text/template with the event.
+func templateFormatter(name, text, contentType string) (formatter, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("webhook %q: invalid template: %w", name, err)
+	}
+	if contentType == "" {
+		contentType = contentTypeJSON
+	}
+
+	return func(e *Event) ([]byte, string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, e); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), contentType, nil
+	}, nil
+}
@@ -0,0 +1,307 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"ts6-viewer/internal/activity"
+	"ts6-viewer/internal/config"
+	"ts6-viewer/internal/metrics"
+	"ts6-viewer/internal/ts6"
+)
+
+const (
+	// queueSize is the number of events a target may fall behind before
+	// new ones are dropped.
+	queueSize = 64
+
+	maxAttempts    = 5
+	minRetryDelay  = time.Second
+	maxRetryDelay  = time.Minute
+	requestTimeout = 10 * time.Second
+
+	defaultDedupWindow = 60 * time.Second
+
+	// statusPollInterval is how often the connection state of a server is
+	// checked for the server_down and server_up events.
+	statusPollInterval = 5 * time.Second
+)
+
+var deliveries = metrics.NewCounterVec(
+	"ts6viewer_webhook_deliveries_total",
+	"Webhook deliveries by target and result.",
+	"target", "result",
+)
+
+// Dispatcher delivers events to the configured webhooks. Each target has
+// its own queue, so a slow endpoint does not hold up the others.
+type Dispatcher struct {
+	client  *http.Client
+	targets []*target
+}
+
+type target struct {
+	cfg       config.Webhook
+	format    formatter
+	threshold int // 0 disables the online events
+	dedup     time.Duration
+
+	events       map[string]bool
+	servers      map[string]bool
+	channels     map[string]bool
+	serverGroups map[int]bool
+
+	queue chan Event
+
+	mu   sync.Mutex
+	sent map[string]time.Time // dedup key → time last queued
+}
+
+// New creates a dispatcher for webhooks. A nil client uses one with a
+// request timeout; pass another to deliver through a custom transport.
+func New(webhooks []config.Webhook, client *http.Client) (*Dispatcher, error) {
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+
+	d := &Dispatcher{client: client}
+
+	for _, wh := range webhooks {
+		t := &target{
+			cfg:          wh,
+			dedup:        defaultDedupWindow,
+			events:       toSet(wh.Events),
+			servers:      toSet(wh.Servers),
+			channels:     toSet(wh.Channels),
+			serverGroups: make(map[int]bool),
+			queue:        make(chan Event, queueSize),
+			sent:         make(map[string]time.Time),
+		}
+
+		switch wh.Format {
+		case "discord":
+			t.format = formatDiscord
+		case "slack":
+			t.format = formatSlack
+		case "template":
+			f, err := templateFormatter(wh.Name, wh.Template, wh.ContentType)
+			if err != nil {
+				return nil, err
+			}
+			t.format = f
+		default:
+			t.format = formatJSON
+		}
+
+		if wh.Threshold != "" {
+			n, err := strconv.Atoi(wh.Threshold)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("webhook %q: invalid threshold %q", wh.Name, wh.Threshold)
+			}
+			t.threshold = n
+		}
+		if wh.DedupWindow != "" {
+			secs, err := strconv.Atoi(wh.DedupWindow)
+			if err != nil || secs < 0 {
+				return nil, fmt.Errorf("webhook %q: invalid dedup_window %q", wh.Name, wh.DedupWindow)
+			}
+			t.dedup = time.Duration(secs) * time.Second
+		}
+		for _, g := range wh.ServerGroups {
+			sgid, err := strconv.Atoi(g)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %q: invalid server group %q", wh.Name, g)
+			}
+			t.serverGroups[sgid] = true
+		}
+
+		d.targets = append(d.targets, t)
+	}
+
+	return d, nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// Start delivers queued events until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for _, t := range d.targets {
+		go d.deliverLoop(ctx, t)
+	}
+}
+
+// Publish queues e for every target whose filters match it.
+func (d *Dispatcher) Publish(e Event) {
+	for _, t := range d.targets {
+		t.offer(e)
+	}
+}
+
+// Observe publishes the activity of a server between two snapshots with
+// online clients before and after. It also reports a client joining an
+// empty server and the online count crossing a target's threshold.
+func (d *Dispatcher) Observe(server, serverName string, events []activity.Event, before, after int, at time.Time) {
+	if len(d.targets) == 0 {
+		return
+	}
+
+	for _, a := range events {
+		d.Publish(clientEvent(server, serverName, a, after))
+	}
+
+	if before == 0 {
+		for _, a := range events {
+			if a.Kind == activity.Joined {
+				e := clientEvent(server, serverName, a, after)
+				e.Type = FirstJoined
+				e.Message = a.Nickname + " joined the empty server"
+				d.Publish(e)
+				break
+			}
+		}
+	}
+
+	for _, t := range d.targets {
+		if t.threshold == 0 {
+			continue
+		}
+
+		e := Event{Server: server, ServerName: serverName, Time: at, Online: after, Threshold: t.threshold}
+		switch {
+		case before < t.threshold && after >= t.threshold:
+			e.Type = OnlineAbove
+			e.Message = fmt.Sprintf("Online count reached %d", after)
+		case before >= t.threshold && after < t.threshold:
+			e.Type = OnlineBelow
+			e.Message = fmt.Sprintf("Online count fell to %d, below %d", after, t.threshold)
+		default:
+			continue
+		}
+
+		t.offer(e)
+	}
+}
+
+// WatchConnection publishes server_down when the connection to a server is
+// lost and server_up when it is back, until ctx is done. serverName is
+// asked for the current name of the virtual server.
+func (d *Dispatcher) WatchConnection(ctx context.Context, server string, watcher *ts6.Watcher, serverName func() string) {
+	if len(d.targets) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(statusPollInterval)
+	defer ticker.Stop()
+
+	var downSince time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		st := watcher.Status()
+		switch {
+		case downSince.IsZero() && st.State != ts6.StateReady && !st.DownSince.IsZero():
+			// The error is only logged; it may name internal addresses
+			downSince = st.DownSince
+			d.Publish(Event{
+				Type:       ServerDown,
+				Server:     server,
+				ServerName: serverName(),
+				Time:       downSince,
+				Message:    "TeamSpeak server unreachable",
+			})
+
+		case !downSince.IsZero() && st.State == ts6.StateReady:
+			downtime := time.Since(downSince).Truncate(time.Second)
+			downSince = time.Time{}
+			d.Publish(Event{
+				Type:       ServerUp,
+				Server:     server,
+				ServerName: serverName(),
+				Time:       time.Now(),
+				Message:    fmt.Sprintf("TeamSpeak server is back after %s", downtime),
+			})
+		}
+	}
+}
+
+// offer queues e unless it is filtered out or a duplicate.
+func (t *target) offer(e Event) {
+	if !t.matches(&e) || t.duplicate(&e) {
+		return
+	}
+
+	select {
+	case t.queue <- e:
+	default:
+		log.Printf("[WEBHOOK] Queue of %q full, dropping %s event\n", t.cfg.Name, e.Type)
+		deliveries.Inc(t.cfg.Name, "dropped")
+	}
+}
+
+// matches applies the filters of t. The channel and server group filters
+// only restrict client events.
+func (t *target) matches(e *Event) bool {
+	if len(t.events) > 0 && !t.events[e.Type] {
+		return false
+	}
+	if len(t.servers) > 0 && !t.servers[e.Server] {
+		return false
+	}
+	if !e.isClientEvent() {
+		return true
+	}
+
+	if len(t.channels) > 0 &&
+		!t.channels[strconv.Itoa(e.CID)] && !t.channels[e.Channel] &&
+		(e.FromCID == 0 || !t.channels[strconv.Itoa(e.FromCID)] && !t.channels[e.FromChannel]) {
+		return false
+	}
+	if len(t.serverGroups) > 0 && !slices.ContainsFunc(e.ServerGroups, func(g int) bool { return t.serverGroups[g] }) {
+		return false
+	}
+
+	return true
+}
+
+// duplicate reports whether an identical event was queued within the
+// dedup window, and otherwise records e.
+func (t *target) duplicate(e *Event) bool {
+	if t.dedup == 0 {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for key, at := range t.sent {
+		if now.Sub(at) >= t.dedup {
+			delete(t.sent, key)
+		}
+	}
+
+	key := e.dedupKey()
+	if _, ok := t.sent[key]; ok {
+		deliveries.Inc(t.cfg.Name, "duplicate")
+		return true
+	}
+	t.sent[key] = now
+	return false
+}
@@ -0,0 +1,122 @@
+// Package webhooktest provides a local HTTP stand-in for webhook endpoints
+// such as Discord or Slack. It records every request and can be scripted
+// to fail, so that delivery, retries and payloads can be checked without
+// network access:
+//
+//	srv := webhooktest.NewServer()
+//	defer srv.Close()
+//
+//	cfg.Webhooks = []config.Webhook{{URL: srv.URL(), Format: "discord"}}
+//	srv.Fail(2, http.StatusServiceUnavailable)
+//	req := <-srv.Requests()
+package webhooktest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request is a request received by the server.
+type Request struct {
+	Method      string
+	Path        string
+	ContentType string
+	Body        []byte
+	Status      int // status the server answered with
+}
+
+// Server is a webhook endpoint on localhost.
+type Server struct {
+	srv *httptest.Server
+
+	mu         sync.Mutex
+	failures   int // requests left to fail
+	failStatus int
+	retryAfter time.Duration
+	received   []Request
+
+	requests chan Request
+}
+
+// NewServer starts a server that accepts every request with 204.
+func NewServer() *Server {
+	s := &Server{requests: make(chan Request, 100)}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// URL returns the address to configure as the webhook URL.
+func (s *Server) URL() string {
+	return s.srv.URL + "/webhook"
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Fail answers the next n requests with status.
+func (s *Server) Fail(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures, s.failStatus = n, status
+}
+
+// RetryAfter sets the Retry-After header sent with failures; zero omits
+// it.
+func (s *Server) RetryAfter(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retryAfter = d
+}
+
+// Requests returns a channel that receives every request as it arrives.
+// Requests are dropped from it if nobody reads them; Received still lists
+// them.
+func (s *Server) Requests() <-chan Request {
+	return s.requests
+}
+
+// Received returns all requests received so far.
+func (s *Server) Received() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.received...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	req := Request{
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		ContentType: r.Header.Get("Content-Type"),
+		Body:        body,
+		Status:      http.StatusNoContent,
+	}
+
+	s.mu.Lock()
+	if s.failures > 0 {
+		s.failures--
+		req.Status = s.failStatus
+		if s.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter/time.Second)))
+		}
+	}
+	s.received = append(s.received, req)
+	s.mu.Unlock()
+
+	select {
+	case s.requests <- req:
+	default:
+	}
+
+	w.WriteHeader(req.Status)
+}